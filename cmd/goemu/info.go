@@ -0,0 +1,85 @@
+package main
+
+import (
+	"fmt"
+
+	"github.com/omstrumpf/goemu/internal/app/backends/gbc/cartridge"
+	"github.com/omstrumpf/goemu/internal/app/loader"
+)
+
+// runInfo prints the cartridge header of the given romfile without starting the emulator,
+// and returns the process exit code. Roms with header errors exit with status 1.
+func runInfo(romfile string) int {
+	rom, romName, err := loader.LoadROM(romfile, *romentry)
+	if err != nil {
+		fmt.Printf("Failed to load romfile: %v\n", err)
+		return 2
+	}
+
+	fmt.Printf("File:             %s (%s, %#x bytes)\n", romfile, romName, len(rom))
+
+	h, err := cartridge.ParseHeader(rom)
+	if err != nil {
+		fmt.Printf("Error: %v\n", err)
+		return 1
+	}
+
+	fmt.Printf("Title:            %s\n", h.Title)
+	fmt.Printf("Manufacturer:     %s\n", h.Manufacturer)
+
+	if h.OldLicenseeCode == 0x33 {
+		fmt.Printf("Licensee:         %s (new code %q)\n", h.Licensee(), h.NewLicenseeCode)
+	} else {
+		fmt.Printf("Licensee:         %s (old code %#02x)\n", h.Licensee(), h.OldLicenseeCode)
+	}
+
+	fmt.Printf("CGB flag:         %#02x (%s)\n", h.CGBFlag, h.Mode)
+	fmt.Printf("SGB flag:         %#02x (%s)\n", h.SGBFlag, supported(h.SGB))
+
+	if h.KnownType {
+		fmt.Printf("Cartridge type:   %#02x (%s)\n", h.CartTypeCode, h.CartType)
+	} else {
+		fmt.Printf("Cartridge type:   %#02x (unknown)\n", h.CartTypeCode)
+	}
+
+	if h.KnownROM {
+		fmt.Printf("ROM size:         %#02x (%dKB, %d banks)\n", h.ROMSizeCode, h.ROMSize/1024, h.ROMSize/0x4000)
+	} else {
+		fmt.Printf("ROM size:         %#02x (unknown)\n", h.ROMSizeCode)
+	}
+
+	if h.KnownRAM {
+		fmt.Printf("RAM size:         %#02x (%dKB)\n", h.RAMSizeCode, h.RAMSize/1024)
+	} else {
+		fmt.Printf("RAM size:         %#02x (unknown)\n", h.RAMSizeCode)
+	}
+
+	fmt.Printf("Version:          %#02x\n", h.Version)
+	fmt.Printf("Header checksum:  %#02x (%s, computed %#02x)\n", h.HeaderChecksum, matches(h.HeaderChecksum == h.ComputedHeaderChecksum), h.ComputedHeaderChecksum)
+	fmt.Printf("Global checksum:  %#04x (%s, computed %#04x)\n", h.GlobalChecksum, matches(h.GlobalChecksum == h.ComputedGlobalChecksum), h.ComputedGlobalChecksum)
+	fmt.Printf("Nintendo logo:    %s\n", matches(h.LogoValid))
+
+	issues := h.Validate()
+	for _, issue := range issues {
+		fmt.Println(issue)
+	}
+
+	if cartridge.HasErrors(issues) {
+		return 1
+	}
+	return 0
+}
+
+func supported(b bool) string {
+	if b {
+		return "supported"
+	}
+	return "not supported"
+}
+
+func matches(b bool) string {
+	if b {
+		return "OK"
+	}
+	return "MISMATCH"
+}
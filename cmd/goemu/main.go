@@ -4,35 +4,84 @@ import (
 	"flag"
 	"fmt"
 	"io/ioutil"
-	"path"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
 	"time"
 
-	"github.com/faiface/pixel/pixelgl" // I/O
 	"github.com/juju/loggo"
 	"github.com/omstrumpf/goemu/internal/app/backends/gbc"
-	"github.com/omstrumpf/goemu/internal/app/io"
+	"github.com/omstrumpf/goemu/internal/app/backends/gbc/audio"
+	"github.com/omstrumpf/goemu/internal/app/backends/gbc/bios"
+	"github.com/omstrumpf/goemu/internal/app/backends/gbc/printer"
+	"github.com/omstrumpf/goemu/internal/app/io/headless"
+	"github.com/omstrumpf/goemu/internal/app/loader"
 	"github.com/omstrumpf/goemu/internal/app/log"
+	"github.com/omstrumpf/goemu/internal/app/patch"
 )
 
-// Runs a temporary version of the GBC emulator. Will have a global entrypoint later that allows selecting another backend.
-func main() {
-	pixelgl.Run(_main)
+// frontend drives the emulation loop, handling input and output
+type frontend interface {
+	ProcessInput()
+	ShouldEmulate() bool
+	ShouldExit() bool
+	Render()
 }
 
-func _main() {
-	fmt.Println("Welcome to goemu!")
+var (
+	loglevel     = flag.String("loglevel", "ERROR", "Log level. ERROR, WARNING, DEBUG, TRACE.")
+	skiplogo     = flag.Bool("skiplogo", false, "Skip the logo scroll sequence")
+	bootrom      = flag.String("bootrom", "", "Boot ROM image to run on startup. Defaults to the built-in DMG boot ROM.")
+	model        = flag.String("model", "auto", "Hardware model. auto, DMG, MGB, SGB, CGB. auto selects the model from the boot ROM, or else the cartridge header.")
+	forceDMG     = flag.Bool("dmg", false, "Run CGB cartridges in DMG mode, without color")
+	renderer     = flag.String("renderer", "scanline", "PPU renderer. scanline is fast, fifo is accurate for mid-scanline effects.")
+	palette      = flag.String("palette", "gray", "Display palette for DMG games. gray, green, pocket, light, auto (CGB colorization), or 4 or 12 (BG, OBJ0, OBJ1) comma-separated hex colors, lightest first.")
+	speed        = flag.Float64("speed", 1.0, "Emulation speed. 1.0 is real time, 0 is unlimited.")
+	ffSpeed      = flag.Float64("ff-speed", 3.0, "Speed multiplier while fast-forwarding (hold Tab, or toggle with F)")
+	slowSpeed    = flag.Float64("slow-speed", 0.5, "Speed multiplier in slow motion (toggle with S)")
+	synthesis    = flag.String("audio-synthesis", "blep", "Audio synthesis. blep is band-limited at any -sample-rate, point is faster but aliases high notes.")
+	sampleRate   = flag.Int("sample-rate", audio.DefaultSampleRate, "Audio samples per second, with -audio-synthesis blep")
+	audioLatency = flag.Duration("audio-latency", 60*time.Millisecond, "Target delay from emulating audio to playing it. Lower values may crackle. Press L to print audio stats.")
+	frames       = flag.Uint64("frames", 0, "Number of frames to emulate. 0 is infinite.")
+	savefile     = flag.String("savefile", "", "File to read/write cartridge save data to")
+	romentry     = flag.String("romentry", "", "Name of the ROM to load from a zip archive. Defaults to the first .gb/.gbc entry.")
+	patchfile    = flag.String("patch", "", "IPS/BPS/UPS patch to apply to the ROM. Defaults to a patch next to the romfile with the same name. \"none\" disables patching.")
+	headlessMode = flag.Bool("headless", false, "Run without a window or audio output, as fast as possible. Requires -frames. Build with -tags headless to leave out the window and audio dependencies.")
+	outpng       = flag.String("outpng", "", "Headless mode: file to write the final frame to, as a PNG")
+	shotDir      = flag.String("screenshot-dir", ".", "Directory to write screenshots (F12), recordings (F10), and audio exports (F9) to")
+	shotScale    = flag.Int("screenshot-scale", 1, "Integer scale for screenshots and -outpng. 1 is native resolution.")
+	outwav       = flag.String("outwav", "", "Write the audio output to a WAV from startup. Exports can also be toggled with F9, to -screenshot-dir.")
+	wavChannels  = flag.Bool("outwav-channels", false, "Also write each audio channel of WAV exports to its own file, named with a -chN suffix")
+	record       = flag.String("record", "", "Record every emulated frame and the audio output from startup. Paths ending in .avi write an uncompressed AVI, other paths a directory of PNG frames and a WAV.")
+	rewindSecs   = flag.Float64("rewind", 30, "Seconds of gameplay that can be rewound by holding R. 0 disables rewind.")
+	rewindStep   = flag.Int("rewind-interval", 2, "Frames between rewind snapshots. Higher values use less memory, but rewind in bigger steps.")
+	serial       = flag.String("serial", "none", "Serial port endpoint. none, stdout, loopback, printer.")
+	printerDir   = flag.String("printer-dir", ".", "Directory to write Game Boy Printer output to, as PNGs")
+	linkListen   = flag.String("link-listen", "", "Address to listen on for a link cable connection from another goemu instance (e.g. :5555)")
+	linkConnect  = flag.String("link-connect", "", "Address of another goemu instance to connect a link cable to (e.g. localhost:5555)")
+)
 
-	loglevel := flag.String("loglevel", "ERROR", "Log level. ERROR, WARNING, DEBUG, TRACE.")
-	skiplogo := flag.Bool("skiplogo", false, "Skip the logo scroll sequence")
-	speed := flag.Float64("speed", 1.0, "Emulation speed. 1.0 is real time, 0 is unlimited.")
-	frames := flag.Uint64("frames", 0, "Number of frames to emulate. 0 is infinite.")
-	savefile := flag.String("savefile", "", "File to read/write cartridge save data to")
+// Runs a temporary version of the GBC emulator. Will have a global entrypoint later that allows selecting another backend.
+func main() {
 	flag.Parse()
 
 	if flag.NArg() < 1 {
 		fmt.Println("Please specify a romfile.")
-		return
+		os.Exit(2)
+	}
+
+	// Subcommands
+	if flag.Arg(0) == "info" {
+		if flag.NArg() < 2 {
+			fmt.Println("Usage: goemu info <romfile>")
+			os.Exit(2)
+		}
+		os.Exit(runInfo(flag.Arg(1)))
 	}
+
+	fmt.Println("Welcome to goemu!")
+
 	romfile := flag.Arg(0)
 
 	switch *loglevel {
@@ -49,18 +98,53 @@ func _main() {
 		loggo.ConfigureLoggers(`<root>=TRACE`)
 	}
 
+	if len(*linkListen) > 0 && len(*linkConnect) > 0 {
+		fmt.Println("Only one of -link-listen and -link-connect may be specified.")
+		os.Exit(2)
+	}
+
+	if *shotScale < 1 {
+		fmt.Println("Screenshot scale (-screenshot-scale) must be at least 1.")
+		os.Exit(2)
+	}
+
+	if *audioLatency <= 0 {
+		fmt.Println("Audio latency (-audio-latency) must be positive.")
+		os.Exit(2)
+	}
+
+	if *ffSpeed <= 0 || *slowSpeed <= 0 {
+		fmt.Println("Fast-forward (-ff-speed) and slow motion (-slow-speed) multipliers must be positive.")
+		os.Exit(2)
+	}
+
+	if *rewindSecs < 0 || *rewindStep < 1 {
+		fmt.Println("Rewind length (-rewind) must not be negative, and the interval (-rewind-interval) must be at least 1.")
+		os.Exit(2)
+	}
+
+	if *headlessMode && *frames == 0 {
+		fmt.Println("Headless mode requires a frame count (-frames).")
+		os.Exit(2)
+	}
+
 	log.Tracef("Loading romfile")
 
-	rom, err := ioutil.ReadFile(romfile)
+	rom, romName, err := loader.LoadROM(romfile, *romentry)
 	if err != nil {
-		panic(err)
+		fmt.Printf("Failed to load romfile: %v\n", err)
+		os.Exit(1)
+	}
+
+	rom, err = applyPatch(rom, romfile, romName)
+	if err != nil {
+		fmt.Printf("Failed to patch romfile: %v\n", err)
+		os.Exit(1)
 	}
 
 	log.Tracef("Loading ram savefile")
 
 	if len(*savefile) == 0 {
-		romName := path.Base(romfile)[:len(path.Base(romfile))-len(path.Ext(romfile))]
-
 		*savefile = (romName + ".save")
 	}
 
@@ -69,46 +153,261 @@ func _main() {
 		log.Warningf("Failed to read savefile: %v", err)
 	}
 
+	config, err := gbcConfig()
+	if err != nil {
+		fmt.Printf("Invalid configuration: %v\n", err)
+		os.Exit(2)
+	}
+
+	if *headlessMode {
+		// Audio is output as emulated, regardless of how fast frames are emulated
+		config.SpeedFactor = 1
+
+		os.Exit(runHeadless(config, rom, ram))
+	}
+
+	os.Exit(startWindowed(config, rom, ram, romName))
+}
+
+// gbcConfig builds the gameboy configuration from the command line flags
+func gbcConfig() (gbc.Config, error) {
+	config := gbc.Config{
+		SkipLogo:    *skiplogo,
+		SpeedFactor: *speed,
+		ForceDMG:    *forceDMG,
+	}
+
+	m, err := gbc.ParseModel(*model)
+	if err != nil {
+		return config, err
+	}
+	config.Model = m
+
+	r, err := gbc.ParseRenderer(*renderer)
+	if err != nil {
+		return config, err
+	}
+	config.Renderer = r
+
+	s, err := audio.ParseSynthesis(*synthesis)
+	if err != nil {
+		return config, err
+	}
+	config.AudioSynthesis = s
+
+	if *sampleRate < 8000 || *sampleRate > 192000 {
+		return config, fmt.Errorf("sample rate %d is out of range (8000-192000)", *sampleRate)
+	}
+	config.SampleRate = *sampleRate
+
+	p, err := gbc.ParsePalette(*palette)
+	if err != nil {
+		return config, err
+	}
+	config.Palette = p
+
+	if len(*bootrom) > 0 {
+		data, err := ioutil.ReadFile(*bootrom)
+		if err != nil {
+			return config, fmt.Errorf("failed to read boot ROM: %v", err)
+		}
+
+		if err := bios.Validate(data); err != nil {
+			return config, fmt.Errorf("failed to load boot ROM: %v", err)
+		}
+
+		config.BootROM = data
+	}
+
+	return config, nil
+}
+
+// runHeadless runs the emulator for a fixed number of frames without any display, and returns the process exit code
+func runHeadless(config gbc.Config, rom []byte, ram []byte) int {
 	log.Tracef("Initializing gameboy")
 
-	gameboy := gbc.NewGBC(*skiplogo, *speed, rom, ram)
+	gameboy := gbc.NewGBC(config, rom, ram)
+	detachSerial, err := attachSerial(gameboy)
+	if err != nil {
+		fmt.Printf("Failed to connect serial port: %v\n", err)
+		return 1
+	}
+	defer detachSerial()
 
-	io := io.NewIO(gameboy)
+	h, err := headless.NewHeadless(gameboy, *frames, *outwav, *wavChannels)
+	if err != nil {
+		fmt.Printf("Failed to initialize headless frontend: %v\n", err)
+		return 1
+	}
 
-	var ticker *time.Ticker
-	if *speed <= 0 {
-		ticker = time.NewTicker(time.Nanosecond)
-	} else {
-		frameTime := time.Duration(int64(float64(gameboy.GetFrameTime().Nanoseconds()) / *speed))
+	if len(*record) > 0 {
+		if err := h.StartRecording(*record); err != nil {
+			fmt.Printf("Failed to start recording: %v\n", err)
+			h.Close()
+			return 1
+		}
+	}
 
-		ticker = time.NewTicker(frameTime)
+	runLoop(gameboy, h, nil)
+
+	status := 0
+
+	if err := h.Close(); err != nil {
+		fmt.Printf("Failed to write audio or recording output: %v\n", err)
+		status = 1
 	}
 
-	// Game loop
-	frame := uint64(0)
-	maxFrame := *frames - 1
-	for range ticker.C {
-		if maxFrame != 0 && frame > *frames-1 {
-			break
+	if len(*outpng) > 0 {
+		if err := h.WriteFrameBuffer(*outpng, *shotScale); err != nil {
+			fmt.Printf("Failed to write frame output: %v\n", err)
+			status = 1
 		}
-		log.Tracef("Emulating frame %d", frame)
-		frame++
+	}
 
-		if io.ShouldExit() {
-			err := ioutil.WriteFile(*savefile, gameboy.GetRAMSave(), 0644)
-			if err != nil {
-				log.Errorf("Failed to write to savefile: %v", err)
-			}
+	writeSavefile(gameboy)
+
+	fmt.Printf("Emulated %d frames.\n", h.RenderedFrames())
+
+	return status
+}
 
+// runLoop emulates frames until the frontend exits or the frame limit is reached.
+// Each frame first calls wait to pace the emulation, or runs immediately if wait is nil.
+func runLoop(gameboy *gbc.GBC, fe frontend, wait func()) {
+	for frame := uint64(0); *frames == 0 || frame < *frames; frame++ {
+		if wait != nil {
+			wait()
+		}
+
+		if fe.ShouldExit() {
 			return
 		}
 
-		io.ProcessInput()
+		log.Tracef("Emulating frame %d", frame)
+
+		fe.ProcessInput()
 
-		if io.ShouldEmulate() {
+		if fe.ShouldEmulate() {
 			gameboy.Tick()
 		}
 
-		io.Render()
+		fe.Render()
+	}
+}
+
+// attachSerial connects the configured endpoint to the gameboy's serial port, and returns a function that
+// disconnects it when emulation finishes. A link cable connection takes precedence over the -serial endpoint.
+func attachSerial(gameboy *gbc.GBC) (func(), error) {
+	if len(*linkListen) > 0 || len(*linkConnect) > 0 {
+		link, err := connectLink()
+		if err != nil {
+			return nil, err
+		}
+
+		gameboy.AttachSerial(link)
+		return func() {}, nil
+	}
+
+	switch *serial {
+	default:
+		fmt.Printf("Unsupported serial endpoint: %s.\n", *serial)
+		fallthrough
+	case "none":
+		gameboy.AttachSerial(nil)
+	case "stdout":
+		gameboy.AttachSerial(gbc.NewSerialLogger(os.Stdout))
+	case "loopback":
+		gameboy.AttachSerial(gbc.NewSerialLoopback())
+	case "printer":
+		p := printer.NewPrinter(*printerDir, func(filename string) {
+			fmt.Printf("Printed %s.\n", filename)
+		})
+		gameboy.AttachSerial(p)
+
+		return func() {
+			if err := p.Close(); err != nil {
+				log.Errorf("Failed to write printout: %v", err)
+			}
+		}, nil
+	}
+
+	return func() {}, nil
+}
+
+// connectLink establishes the link cable TCP connection with the peer instance
+func connectLink() (*gbc.SerialLink, error) {
+	var conn net.Conn
+
+	if len(*linkListen) > 0 {
+		listener, err := net.Listen("tcp", *linkListen)
+		if err != nil {
+			return nil, err
+		}
+		defer listener.Close()
+
+		fmt.Printf("Waiting for link cable connection on %s.\n", listener.Addr())
+
+		conn, err = listener.Accept()
+		if err != nil {
+			return nil, err
+		}
+	} else {
+		var err error
+
+		conn, err = net.Dial("tcp", *linkConnect)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	link, err := gbc.NewSerialLink(conn)
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+
+	fmt.Printf("Link cable connected to %s.\n", conn.RemoteAddr())
+
+	return link, nil
+}
+
+// applyPatch applies the configured patch to the rom, or a patch found next to the romfile
+func applyPatch(rom []byte, romfile string, romName string) ([]byte, error) {
+	filename := *patchfile
+
+	switch filename {
+	case "none":
+		return rom, nil
+	case "":
+		// Look for a patch named after the romfile, or the ROM inside an archive
+		base := filepath.Base(romfile)
+		filename = patch.FindPatch(filepath.Dir(romfile), strings.TrimSuffix(base, filepath.Ext(base)))
+		if len(filename) == 0 {
+			filename = patch.FindPatch(filepath.Dir(romfile), romName)
+		}
+		if len(filename) == 0 {
+			return rom, nil
+		}
+	}
+
+	data, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+
+	patched, err := patch.Apply(rom, data)
+	if err != nil {
+		return nil, err
+	}
+
+	fmt.Printf("Applied patch %s.\n", filename)
+
+	return patched, nil
+}
+
+func writeSavefile(gameboy *gbc.GBC) {
+	err := ioutil.WriteFile(*savefile, gameboy.GetRAMSave(), 0644)
+	if err != nil {
+		log.Errorf("Failed to write to savefile: %v", err)
 	}
 }
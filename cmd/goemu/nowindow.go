@@ -0,0 +1,17 @@
+//go:build headless
+// +build headless
+
+package main
+
+import (
+	"fmt"
+
+	"github.com/omstrumpf/goemu/internal/app/backends/gbc"
+)
+
+// startWindowed is unavailable in builds with the headless tag, which leave out the window and audio output so that
+// they build without X11, OpenGL, or ALSA. It returns the process exit code.
+func startWindowed(config gbc.Config, rom []byte, ram []byte, romName string) int {
+	fmt.Println("This build of goemu has no window or audio output. Run it with -headless.")
+	return 2
+}
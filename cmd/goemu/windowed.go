@@ -0,0 +1,77 @@
+//go:build !headless
+// +build !headless
+
+package main
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/faiface/pixel/pixelgl" // I/O
+	"github.com/omstrumpf/goemu/internal/app/backends/gbc"
+	"github.com/omstrumpf/goemu/internal/app/io"
+	"github.com/omstrumpf/goemu/internal/app/log"
+)
+
+// startWindowed runs the emulator in a window on the main thread, and returns the process exit code
+func startWindowed(config gbc.Config, rom []byte, ram []byte, romName string) int {
+	pixelgl.Run(func() {
+		runWindowed(config, rom, ram, romName)
+	})
+
+	return 0
+}
+
+// runWindowed runs the emulator in a window, paced to the configured speed
+func runWindowed(config gbc.Config, rom []byte, ram []byte, romName string) {
+	log.Tracef("Initializing gameboy")
+
+	gameboy := gbc.NewGBC(config, rom, ram)
+	detachSerial, err := attachSerial(gameboy)
+	if err != nil {
+		fmt.Printf("Failed to connect serial port: %v\n", err)
+		return
+	}
+	defer detachSerial()
+
+	io := io.NewIO(gameboy, io.Config{
+		StateFilePrefix:     romName,
+		ScreenshotDir:       *shotDir,
+		ScreenshotScale:     *shotScale,
+		SpeedFactor:         *speed,
+		FastForwardSpeed:    *ffSpeed,
+		SlowMotionSpeed:     *slowSpeed,
+		AudioLatency:        *audioLatency,
+		AudioExportChannels: *wavChannels,
+		RewindLength:        time.Duration(*rewindSecs * float64(time.Second)),
+		RewindInterval:      *rewindStep,
+	})
+
+	if len(*record) > 0 {
+		if err := io.StartRecording(*record); err != nil {
+			fmt.Printf("Failed to start recording: %v\n", err)
+			return
+		}
+		fmt.Printf("Recording to %s.\n", *record)
+	}
+
+	if len(*outwav) > 0 {
+		if err := io.StartAudioExport(*outwav); err != nil {
+			fmt.Printf("Failed to start audio export: %v\n", err)
+			io.StopRecording()
+			return
+		}
+		fmt.Printf("Exporting audio to %s.\n", *outwav)
+	}
+
+	runLoop(gameboy, io, io.WaitFrame)
+
+	if err := io.StopRecording(); err != nil {
+		fmt.Printf("Failed to write recording: %v\n", err)
+	}
+	if err := io.StopAudioExport(); err != nil {
+		fmt.Printf("Failed to write audio export: %v\n", err)
+	}
+
+	writeSavefile(gameboy)
+}
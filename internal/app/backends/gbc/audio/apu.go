@@ -1,14 +1,18 @@
 package audio
 
 import (
+	"github.com/omstrumpf/goemu/internal/app/backends/gbc/state"
 	"github.com/omstrumpf/goemu/internal/app/console/audio"
 	"github.com/omstrumpf/goemu/internal/app/log"
 )
 
-// Bitrate is the number of samples output per second
+// Bitrate is the number of samples output per second, of emulated time at the APU and of real time at the output
 // const Bitrate int = 44100
 const Bitrate int = 43690
 
+// DefaultSampleRate is the number of samples output per second with band-limited synthesis, if none is given
+const DefaultSampleRate int = 48000
+
 const bufferLength int = Bitrate // 1 second worth of buffer
 
 // APU is the gameboy's Audio Processing Unit
@@ -17,6 +21,13 @@ type APU struct {
 
 	sampleTimer *timer
 
+	synthesis  Synthesis
+	sampleRate int
+	synth      *blepSynth // Band-limited synthesizer, or nil when point sampling
+
+	speedFactor float64    // Emulation speed relative to real time, which the output is stretched for
+	stretcher   *stretcher // Stretches the output to real time without changing its pitch, or nil at normal speed
+
 	squareWave1   *squareWave
 	squareWave2   *squareWave
 	dataWave      *dataWave
@@ -40,13 +51,15 @@ type APU struct {
 	lastWrites map[uint16]byte
 }
 
-// NewAPU constructs a valid APU struct
-func NewAPU(speedFactor float64) *APU {
+// NewAPU constructs a valid APU struct, which outputs audio for normal speed
+func NewAPU() *APU {
 	apu := new(APU)
 
 	apu.outchan = make(chan audio.ChanneledSample, bufferLength)
 
-	apu.sampleTimer = newTimerByHz(int(float64(Bitrate)/speedFactor), apu.takeSample)
+	apu.sampleTimer = newTimerByHz(Bitrate, apu.takeSample)
+	apu.sampleRate = Bitrate
+	apu.speedFactor = 1
 
 	apu.squareWave1 = newSquareWave()
 	apu.squareWave2 = newSquareWave()
@@ -73,6 +86,20 @@ func NewAPU(speedFactor float64) *APU {
 
 // RunForClocks runs the APU for the given number of clock cycles
 func (apu *APU) RunForClocks(clocks int) {
+	if apu.synth != nil {
+		// Step a clock at a time, so that every change in level is placed at its exact clock
+		for i := 0; i < clocks; i++ {
+			apu.runChannels(1)
+			apu.synth.clock(apu.levels(), apu.enqueueSample)
+		}
+		return
+	}
+
+	apu.runChannels(clocks)
+	apu.sampleTimer.runForClocks(clocks)
+}
+
+func (apu *APU) runChannels(clocks int) {
 	if apu.enabled {
 		apu.channel1.runForClocks(clocks)
 		apu.channel2.runForClocks(clocks)
@@ -80,8 +107,62 @@ func (apu *APU) RunForClocks(clocks int) {
 		apu.channel4.runForClocks(clocks)
 		apu.sweep.runForClocks(clocks)
 	}
+}
 
-	apu.sampleTimer.runForClocks(clocks)
+// SetSynthesis sets how the APU turns channel output into samples. Point sampling always outputs Bitrate samples
+// per second. Band-limited synthesis outputs sampleRate samples per second, or DefaultSampleRate if it is 0 or less.
+func (apu *APU) SetSynthesis(synthesis Synthesis, sampleRate int) {
+	apu.synthesis = synthesis
+	apu.synth = nil
+	apu.sampleRate = Bitrate
+
+	if synthesis == SynthesisBLEP {
+		if sampleRate <= 0 {
+			sampleRate = DefaultSampleRate
+		}
+
+		apu.synth = newBLEPSynth(sampleRate)
+		apu.sampleRate = sampleRate
+	}
+
+	// The stretcher's buffered audio is at the old rate
+	if apu.stretcher != nil {
+		apu.stretcher = newStretcher(apu.speedFactor)
+	}
+}
+
+// Synthesis returns how the APU turns channel output into samples
+func (apu *APU) Synthesis() Synthesis {
+	return apu.synthesis
+}
+
+// SampleRate returns the number of samples output per second
+func (apu *APU) SampleRate() int {
+	return apu.sampleRate
+}
+
+// SetSpeedFactor sets the emulation speed relative to real time. The APU samples at SampleRate per second of emulated
+// time, and resamples its output to SampleRate per second of real time, keeping the pitch. At 1, samples are output as they
+// are taken. 0 or less, for emulation that is not paced to real time, is treated as 1.
+func (apu *APU) SetSpeedFactor(speedFactor float64) {
+	if speedFactor <= 0 {
+		speedFactor = 1
+	}
+	if speedFactor == apu.speedFactor {
+		return
+	}
+
+	apu.speedFactor = speedFactor
+
+	apu.stretcher = nil
+	if speedFactor != 1 {
+		apu.stretcher = newStretcher(speedFactor)
+	}
+}
+
+// SpeedFactor returns the emulation speed the output is resampled for
+func (apu *APU) SpeedFactor() float64 {
+	return apu.speedFactor
 }
 
 // GetOutputChannel returns the channel that the APU writes to.
@@ -90,50 +171,49 @@ func (apu *APU) GetOutputChannel() *chan audio.ChanneledSample {
 }
 
 func (apu *APU) takeSample() {
-	sample := audio.ChanneledSample{Channels: []audio.Sample{
-		{0, 0},
-		{0, 0},
-		{0, 0},
-		{0, 0},
-	}}
+	levels := apu.levels()
 
+	apu.enqueueSample(audio.ChanneledSample{Channels: levels[:]})
+}
+
+// levels returns the current output of each channel to the left and right terminals
+func (apu *APU) levels() (levels [4]audio.Sample) {
 	if !apu.enabled {
-		apu.enqueueSample(sample)
 		return
 	}
 
 	volumeLeft := float64(apu.volumeLeft+1) / 64
 	volumeRight := float64(apu.volumeRight+1) / 64
 
-	if apu.outputSelect&0b1000_0000 > 0 {
-		sample.Channels[3][0] += apu.channel4.sample() * volumeLeft
-	}
-	if apu.outputSelect&0b0100_0000 > 0 {
-		sample.Channels[2][0] += apu.channel3.sample() * volumeLeft
-	}
-	if apu.outputSelect&0b0010_0000 > 0 {
-		sample.Channels[1][0] += apu.channel2.sample() * volumeLeft
-	}
-	if apu.outputSelect&0b0001_0000 > 0 {
-		sample.Channels[0][0] += apu.channel1.sample() * volumeLeft
-	}
-	if apu.outputSelect&0b0000_1000 > 0 {
-		sample.Channels[3][1] += apu.channel4.sample() * volumeRight
-	}
-	if apu.outputSelect&0b0000_0100 > 0 {
-		sample.Channels[2][1] += apu.channel3.sample() * volumeRight
-	}
-	if apu.outputSelect&0b0000_0010 > 0 {
-		sample.Channels[1][1] += apu.channel2.sample() * volumeRight
-	}
-	if apu.outputSelect&0b0000_0001 > 0 {
-		sample.Channels[0][1] += apu.channel1.sample() * volumeRight
+	for i, c := range [4]*channel{apu.channel1, apu.channel2, apu.channel3, apu.channel4} {
+		left := apu.outputSelect&(0b0001_0000<<i) != 0
+		right := apu.outputSelect&(0b0000_0001<<i) != 0
+		if !left && !right {
+			continue
+		}
+
+		sample := c.sample()
+		if left {
+			levels[i][0] = sample * volumeLeft
+		}
+		if right {
+			levels[i][1] = sample * volumeRight
+		}
 	}
 
-	apu.enqueueSample(sample)
+	return
 }
 
 func (apu *APU) enqueueSample(sample audio.ChanneledSample) {
+	if apu.stretcher != nil {
+		apu.stretcher.push(sample, apu.output)
+		return
+	}
+
+	apu.output(sample)
+}
+
+func (apu *APU) output(sample audio.ChanneledSample) {
 	select {
 	case apu.outchan <- sample:
 		log.Tracef("APU produced audio sample: %v", sample)
@@ -142,6 +222,52 @@ func (apu *APU) enqueueSample(sample audio.ChanneledSample) {
 	}
 }
 
+// SaveState writes the APU registers and channel state to the save state
+func (apu *APU) SaveState(w *state.Writer) {
+	apu.sampleTimer.saveState(w)
+
+	apu.channel1.saveState(w)
+	apu.channel2.saveState(w)
+	apu.channel3.saveState(w)
+	apu.channel4.saveState(w)
+	apu.sweep.saveState(w)
+
+	w.U8(apu.volumeLeft)
+	w.U8(apu.volumeRight)
+	w.U8(apu.outputSelect)
+	w.Bool(apu.enabled)
+
+	for addr := uint16(0xFF10); addr < 0xFF40; addr++ {
+		w.U8(apu.lastWrites[addr])
+	}
+}
+
+// LoadState restores the APU registers and channel state from the save state.
+// The sample timer period is kept as-is, since older save states stored a period that depended on the emulation speed.
+func (apu *APU) LoadState(r *state.Reader) {
+	period := apu.sampleTimer.period
+	apu.sampleTimer.loadState(r)
+	apu.sampleTimer.period = period
+	if apu.sampleTimer.countdown > period {
+		apu.sampleTimer.countdown = period
+	}
+
+	apu.channel1.loadState(r)
+	apu.channel2.loadState(r)
+	apu.channel3.loadState(r)
+	apu.channel4.loadState(r)
+	apu.sweep.loadState(r)
+
+	apu.volumeLeft = r.U8()
+	apu.volumeRight = r.U8()
+	apu.outputSelect = r.U8()
+	apu.enabled = r.Bool()
+
+	for addr := uint16(0xFF10); addr < 0xFF40; addr++ {
+		apu.lastWrites[addr] = r.U8()
+	}
+}
+
 func (apu *APU) enable() {
 	apu.enabled = true
 }
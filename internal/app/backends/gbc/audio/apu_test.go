@@ -5,7 +5,7 @@ import (
 )
 
 func TestAPUPower(t *testing.T) {
-	apu := NewAPU(1)
+	apu := NewAPU()
 
 	if !apu.enabled {
 		t.Errorf("Expected APU to start enabled")
@@ -25,7 +25,7 @@ func TestAPUPower(t *testing.T) {
 }
 
 func TestAPUWaveRAM(t *testing.T) {
-	apu := NewAPU(1)
+	apu := NewAPU()
 
 	apu.Write(0xFF26, 0b1000_0000)
 
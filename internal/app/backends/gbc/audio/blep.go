@@ -0,0 +1,158 @@
+package audio
+
+import (
+	"fmt"
+	"math"
+	"strings"
+
+	"github.com/omstrumpf/goemu/internal/app/backends/gbc/constants"
+	"github.com/omstrumpf/goemu/internal/app/console/audio"
+)
+
+// Synthesis selects how the APU turns channel output into samples
+type Synthesis int
+
+// Synthesis modes
+const (
+	SynthesisPoint Synthesis = iota // Samples each channel's level at Bitrate. Fast and deterministic, but aliases high frequencies.
+	SynthesisBLEP                   // Adds a band-limited step for each change in level, at its exact clock, at any sample rate
+)
+
+func (s Synthesis) String() string {
+	switch s {
+	case SynthesisPoint:
+		return "point"
+	case SynthesisBLEP:
+		return "blep"
+	default:
+		return "UNKNOWN"
+	}
+}
+
+// ParseSynthesis parses a synthesis mode name, as returned by Synthesis.String
+func ParseSynthesis(name string) (Synthesis, error) {
+	for _, s := range []Synthesis{SynthesisPoint, SynthesisBLEP} {
+		if strings.EqualFold(name, s.String()) {
+			return s, nil
+		}
+	}
+
+	return SynthesisPoint, fmt.Errorf("unknown audio synthesis %q", name)
+}
+
+const (
+	blepChannels = 4    // Audio channels in a sample
+	blepWidth    = 16   // Output samples a step is spread over
+	blepPhases   = 64   // Positions between output samples the step kernel is tabulated for
+	blepCutoff   = 0.45 // Cutoff frequency of the step kernel, as a fraction of the sample rate
+
+	// highPassCharge is the fraction of the output capacitor's charge kept per 4MHz clock on the DMG
+	highPassCharge = 0.999958
+)
+
+// blepKernel holds the impulse that is added to the output deltas for a step, at each position between output samples.
+// Summing the deltas turns the impulse into a band-limited step. Each phase sums to 1, so steps settle at their full size.
+var blepKernel = func() (k [blepPhases][blepWidth]float64) {
+	for p := range k {
+		center := blepWidth/2 - 1 + float64(p)/blepPhases
+
+		sum := 0.0
+		for i := range k[p] {
+			x := float64(i) - center
+
+			// Windowed sinc, with a Blackman window over the kernel width
+			v := 2 * blepCutoff
+			if x != 0 {
+				v = math.Sin(2*math.Pi*blepCutoff*x) / (math.Pi * x)
+			}
+			w := 2 * math.Pi * x / blepWidth
+			v *= 0.42 + 0.5*math.Cos(w) + 0.08*math.Cos(2*w)
+
+			k[p][i] = v
+			sum += v
+		}
+
+		for i := range k[p] {
+			k[p][i] /= sum
+		}
+	}
+	return
+}()
+
+// blepSynth synthesizes band-limited output from the level of each channel at every clock.
+// Changes in level are added to a ring of output deltas as band-limited steps, at their position between output samples.
+// Output samples are the running sum of the deltas once no later step can reach them, passed through a high-pass
+// filter like the capacitor on the hardware's output, which removes the DACs' DC offset.
+type blepSynth struct {
+	sampleRate int
+
+	// Time since the next output sample in the ring, in units where a clock is sampleRate and an output sample is
+	// constants.ClockSpeed, so that the position of each clock is exact.
+	time int
+
+	levels [blepChannels]audio.Sample            // Channel levels at the last clock
+	deltas [blepWidth][blepChannels]audio.Sample // Ring of output deltas, starting at the next output sample
+	start  int                                   // Position of the next output sample in the ring
+
+	sums     [blepChannels]audio.Sample // Channel levels at the next output sample, before the high-pass filter
+	charge   [blepChannels]audio.Sample // Charge of the high-pass filter's capacitor
+	highPass float64                    // Fraction of the charge kept per output sample
+}
+
+// newBLEPSynth constructs a valid blepSynth that outputs sampleRate samples per second of emulated time
+func newBLEPSynth(sampleRate int) *blepSynth {
+	return &blepSynth{
+		sampleRate: sampleRate,
+		highPass:   math.Pow(highPassCharge, float64(4*constants.ClockSpeed)/float64(sampleRate)),
+	}
+}
+
+// clock advances a clock with the channel levels during it, and calls emit with the output sample if one is complete
+func (s *blepSynth) clock(levels [blepChannels]audio.Sample, emit func(audio.ChanneledSample)) {
+	if levels != s.levels {
+		kernel := &blepKernel[s.time*blepPhases/constants.ClockSpeed]
+
+		for c := range levels {
+			for side := range levels[c] {
+				delta := levels[c][side] - s.levels[c][side]
+				if delta == 0 {
+					continue
+				}
+
+				for i, k := range kernel {
+					s.deltas[(s.start+i)%blepWidth][c][side] += delta * k
+				}
+			}
+		}
+
+		s.levels = levels
+	}
+
+	s.time += s.sampleRate
+	if s.time >= constants.ClockSpeed {
+		s.time -= constants.ClockSpeed
+		emit(s.next())
+	}
+}
+
+// next removes the next output sample from the ring
+func (s *blepSynth) next() audio.ChanneledSample {
+	sample := audio.ChanneledSample{Channels: make([]audio.Sample, blepChannels)}
+
+	deltas := &s.deltas[s.start]
+	for c := range deltas {
+		for side := range deltas[c] {
+			s.sums[c][side] += deltas[c][side]
+
+			out := s.sums[c][side] - s.charge[c][side]
+			s.charge[c][side] = s.sums[c][side] - out*s.highPass
+
+			sample.Channels[c][side] = out
+		}
+	}
+
+	*deltas = [blepChannels]audio.Sample{}
+	s.start = (s.start + 1) % blepWidth
+
+	return sample
+}
@@ -0,0 +1,119 @@
+package audio
+
+import (
+	"math"
+	"testing"
+
+	"github.com/omstrumpf/goemu/internal/app/backends/gbc/constants"
+)
+
+// mixAll sends every channel to both terminals at full volume
+func mixAll(apu *APU) {
+	apu.Write(0xFF24, 0x77)
+	apu.Write(0xFF25, 0xFF)
+}
+
+// playSquare plays channel 2 as a square wave with the given frequency register for an emulated second, and returns
+// the left output after the first tenth of a second, while the filters settle
+func playSquare(apu *APU, frequency uint16) []float64 {
+	mixAll(apu)
+	apu.Write(0xFF16, 0b1000_0000) // 50% duty
+	apu.Write(0xFF17, 0xF0)        // Full volume
+	apu.Write(0xFF18, byte(frequency))
+	apu.Write(0xFF19, 0b1000_0000|byte(frequency>>8))
+
+	var out []float64
+	for i := 0; i < constants.ClockSpeed; i += 64 {
+		apu.RunForClocks(64)
+
+		for len(apu.outchan) > 0 {
+			out = append(out, (<-apu.outchan).Channels[1][0])
+		}
+	}
+
+	return out[len(out)/10:]
+}
+
+func rms(samples []float64) float64 {
+	sum := 0.0
+	for _, s := range samples {
+		sum += s * s
+	}
+	return math.Sqrt(sum / float64(len(samples)))
+}
+
+func TestBLEPKernel(t *testing.T) {
+	for p := range blepKernel {
+		sum := 0.0
+		for _, k := range blepKernel[p] {
+			sum += k
+		}
+
+		if math.Abs(sum-1) > 1e-12 {
+			t.Errorf("Expected phase %d of the kernel to sum to 1, got %v", p, sum)
+		}
+	}
+}
+
+func TestBLEPSampleRate(t *testing.T) {
+	for _, rate := range []int{44100, 48000} {
+		apu := NewAPU()
+		apu.SetSynthesis(SynthesisBLEP, rate)
+
+		if apu.SampleRate() != rate {
+			t.Errorf("Expected a sample rate of %d, got %d", rate, apu.SampleRate())
+		}
+
+		// A 440Hz tone, at an eighth of full scale
+		out := playSquare(apu, 2048-constants.ClockSpeed/8/440)
+
+		if n := len(out) * 10 / 9; math.Abs(float64(n-rate)) > 10 {
+			t.Errorf("Expected about %d samples per emulated second, got %d", rate, n)
+		}
+		if level := rms(out); math.Abs(level-0.125) > 0.01 {
+			t.Errorf("Expected an audible tone to pass through at its full level, got an RMS of %v", level)
+		}
+	}
+}
+
+func TestBLEPAliasing(t *testing.T) {
+	// A 65536Hz square wave is above the range of any output rate, so it should be inaudible rather than alias
+	const frequency = 2046
+
+	point := NewAPU()
+	if level := rms(playSquare(point, frequency)); level < 0.1 {
+		t.Errorf("Expected point sampling to alias the ultrasonic tone, got an RMS of %v", level)
+	}
+
+	blep := NewAPU()
+	blep.SetSynthesis(SynthesisBLEP, 48000)
+	if level := rms(playSquare(blep, frequency)); level > 0.005 {
+		t.Errorf("Expected band-limited synthesis to filter the ultrasonic tone, got an RMS of %v", level)
+	}
+}
+
+func TestBLEPHighPass(t *testing.T) {
+	apu := NewAPU()
+	apu.SetSynthesis(SynthesisBLEP, 48000)
+
+	// With its DAC on at zero volume, the channel outputs a constant negative level
+	mixAll(apu)
+	apu.Write(0xFF17, 0x08)
+	apu.Write(0xFF19, 0b1000_0000)
+
+	var out []float64
+	for i := 0; i < constants.ClockSpeed/10; i++ {
+		apu.RunForClocks(1)
+
+		for len(apu.outchan) > 0 {
+			out = append(out, (<-apu.outchan).Channels[1][0])
+		}
+	}
+
+	if out[len(out)/100] > -0.05 {
+		t.Errorf("Expected the DC offset to be output at first, got %v", out[len(out)/100])
+	}
+	if last := out[len(out)-1]; math.Abs(last) > 1e-4 {
+		t.Errorf("Expected the high-pass filter to remove the DC offset, got %v", last)
+	}
+}
@@ -1,5 +1,7 @@
 package audio
 
+import "github.com/omstrumpf/goemu/internal/app/backends/gbc/state"
+
 type channel struct {
 	source        signalSource
 	lengthCounter *lengthCounter
@@ -71,3 +73,21 @@ func (c *channel) enable() {
 func (c *channel) active() bool {
 	return c.enabled && c.dac.enabled
 }
+
+// saveState writes the channel and all of its units to the save state
+func (c *channel) saveState(w *state.Writer) {
+	c.source.saveState(w)
+	c.lengthCounter.saveState(w)
+	c.volume.saveState(w)
+	w.Bool(c.dac.enabled)
+	w.Bool(c.enabled)
+}
+
+// loadState restores the channel and all of its units from the save state
+func (c *channel) loadState(r *state.Reader) {
+	c.source.loadState(r)
+	c.lengthCounter.loadState(r)
+	c.volume.loadState(r)
+	c.dac.enabled = r.Bool()
+	c.enabled = r.Bool()
+}
@@ -1,5 +1,7 @@
 package audio
 
+import "github.com/omstrumpf/goemu/internal/app/backends/gbc/state"
+
 type dataWave struct {
 	timer *timer
 
@@ -42,3 +44,19 @@ func (dw *dataWave) trigger() {
 func (dw *dataWave) updateFrequency() {
 	dw.timer.period = (2048 - int(dw.frequency)) / 2
 }
+
+func (dw *dataWave) saveState(w *state.Writer) {
+	dw.timer.saveState(w)
+	w.Bytes(dw.data[:])
+	w.U8(dw.positionCounter)
+	w.U16(dw.frequency)
+	w.U8(dw.value)
+}
+
+func (dw *dataWave) loadState(r *state.Reader) {
+	dw.timer.loadState(r)
+	r.Bytes(dw.data[:])
+	dw.positionCounter = r.U8()
+	dw.frequency = r.U16()
+	dw.value = r.U8()
+}
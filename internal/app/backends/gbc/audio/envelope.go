@@ -1,5 +1,7 @@
 package audio
 
+import "github.com/omstrumpf/goemu/internal/app/backends/gbc/state"
+
 type envelope struct {
 	timer *timer
 
@@ -50,3 +52,21 @@ func (envelope *envelope) trigger() {
 	envelope.sweepCounter = envelope.sweepPeriod
 	envelope.volume = envelope.initVolume
 }
+
+func (envelope *envelope) saveState(w *state.Writer) {
+	envelope.timer.saveState(w)
+	w.U8(envelope.initVolume)
+	w.U8(envelope.volume)
+	w.U8(envelope.sweepPeriod)
+	w.U8(envelope.sweepCounter)
+	w.Bool(envelope.mode)
+}
+
+func (envelope *envelope) loadState(r *state.Reader) {
+	envelope.timer.loadState(r)
+	envelope.initVolume = r.U8()
+	envelope.volume = r.U8()
+	envelope.sweepPeriod = r.U8()
+	envelope.sweepCounter = r.U8()
+	envelope.mode = r.Bool()
+}
@@ -1,5 +1,7 @@
 package audio
 
+import "github.com/omstrumpf/goemu/internal/app/backends/gbc/state"
+
 type lengthCounter struct {
 	channel *channel
 	timer   *timer
@@ -70,3 +72,17 @@ func (lc *lengthCounter) enable(trigger bool) {
 func (lc *lengthCounter) disable() {
 	lc.enabled = false
 }
+
+func (lc *lengthCounter) saveState(w *state.Writer) {
+	lc.timer.saveState(w)
+	w.Int(lc.initCounter)
+	w.Int(lc.counter)
+	w.Bool(lc.enabled)
+}
+
+func (lc *lengthCounter) loadState(r *state.Reader) {
+	lc.timer.loadState(r)
+	lc.initCounter = r.Int()
+	lc.counter = r.Int()
+	lc.enabled = r.Bool()
+}
@@ -1,5 +1,7 @@
 package audio
 
+import "github.com/omstrumpf/goemu/internal/app/backends/gbc/state"
+
 type noiseWave struct {
 	timer *timer
 
@@ -60,3 +62,21 @@ func (nw *noiseWave) trigger() {
 func (nw *noiseWave) updateDivisor(code byte) {
 	nw.timer.resetDuration(int(code) + 1)
 }
+
+func (nw *noiseWave) saveState(w *state.Writer) {
+	nw.timer.saveState(w)
+	w.U8(nw.divisorCode)
+	w.U8(nw.clockShift)
+	w.Bool(nw.widthMode)
+	w.U16(nw.countdown)
+	w.U16(nw.lsfr)
+}
+
+func (nw *noiseWave) loadState(r *state.Reader) {
+	nw.timer.loadState(r)
+	nw.divisorCode = r.U8()
+	nw.clockShift = r.U8()
+	nw.widthMode = r.Bool()
+	nw.countdown = r.U16()
+	nw.lsfr = r.U16()
+}
@@ -1,7 +1,12 @@
 package audio
 
+import "github.com/omstrumpf/goemu/internal/app/backends/gbc/state"
+
 type signalSource interface {
 	runForClocks(int)
 	sample() byte
 	trigger()
+
+	saveState(*state.Writer)
+	loadState(*state.Reader)
 }
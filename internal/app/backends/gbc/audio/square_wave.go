@@ -1,5 +1,7 @@
 package audio
 
+import "github.com/omstrumpf/goemu/internal/app/backends/gbc/state"
+
 type squareWave struct {
 	timer *timer
 
@@ -49,6 +51,22 @@ func (sw *squareWave) updateFrequency() {
 	sw.timer.period = 2048 - int(sw.frequency)
 }
 
+func (sw *squareWave) saveState(w *state.Writer) {
+	sw.timer.saveState(w)
+	w.U8(sw.duty)
+	w.Int(sw.dutyCounter)
+	w.U16(sw.frequency)
+	w.U8(sw.value)
+}
+
+func (sw *squareWave) loadState(r *state.Reader) {
+	sw.timer.loadState(r)
+	sw.duty = r.U8()
+	sw.dutyCounter = r.Int()
+	sw.frequency = r.U16()
+	sw.value = r.U8()
+}
+
 var dutyMap [4][8]byte = [4][8]byte{
 	{0, 0, 0, 0, 0, 0, 0, 1},
 	{1, 0, 0, 0, 0, 0, 0, 1},
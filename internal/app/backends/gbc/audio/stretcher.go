@@ -0,0 +1,153 @@
+package audio
+
+import (
+	"math"
+
+	"github.com/omstrumpf/goemu/internal/app/console/audio"
+)
+
+const (
+	stretchChannels  = 4                         // Audio channels in a sample
+	stretchGrain     = 1024                      // Samples in a grain (~23ms)
+	stretchHop       = stretchGrain / 2          // Output samples per grain
+	stretchTolerance = 256                       // Max samples a grain is shifted by to line up with the previous grain
+	stretchOverlap   = stretchGrain - stretchHop // Samples of overlap compared when lining up grains
+	stretchStride    = 4                         // Compare every nth sample when lining up grains, to save time
+)
+
+// stretchFrame is a multi-channel stereo sample, as left/right pairs
+type stretchFrame [stretchChannels * 2]float64
+
+// stretchWindow is a periodic Hann window, which sums to 1 when grains overlap by half
+var stretchWindow = func() (w [stretchGrain]float64) {
+	for i := range w {
+		w[i] = 0.5 - 0.5*math.Cos(2*math.Pi*float64(i)/stretchGrain)
+	}
+	return
+}()
+
+// stretcher changes the duration of an audio stream without changing its pitch (WSOLA).
+// Grains of input are taken every speed*stretchHop samples, and overlapped every stretchHop samples of output.
+// Each grain is shifted to the position that best continues the previous grain's waveform, to avoid phase
+// cancellation in the overlap.
+type stretcher struct {
+	speed float64
+
+	in   []stretchFrame // Input not yet consumed by a grain
+	mix  []float64      // Mono mix of the input, for lining up grains
+	pos  float64        // Position of the next grain in the input, before shifting
+	prev int            // Position of the previous grain in the input, or -1 if there is none
+
+	accum [stretchGrain]stretchFrame // Overlapped output of the grains so far
+}
+
+// newStretcher constructs a valid stretcher that speeds audio up by the given factor
+func newStretcher(speed float64) *stretcher {
+	return &stretcher{
+		speed: speed,
+		pos:   stretchTolerance,
+		prev:  -1,
+	}
+}
+
+// push adds an input sample, and calls emit with any output samples that are complete
+func (s *stretcher) push(sample audio.ChanneledSample, emit func(audio.ChanneledSample)) {
+	var f stretchFrame
+	for c := 0; c < stretchChannels && c < len(sample.Channels); c++ {
+		f[c*2] = sample.Channels[c][0]
+		f[c*2+1] = sample.Channels[c][1]
+	}
+	s.in = append(s.in, f)
+	s.mix = append(s.mix, mono(f))
+
+	for s.ready() {
+		s.overlapGrain(emit)
+	}
+}
+
+// ready returns true if there is enough input to overlap the next grain
+func (s *stretcher) ready() bool {
+	if len(s.in) < int(s.pos)+stretchTolerance+stretchGrain {
+		return false
+	}
+	return s.prev < 0 || len(s.in) >= s.prev+stretchHop+stretchOverlap
+}
+
+// overlapGrain adds the next grain to the output, and emits the output samples that no more grains overlap
+func (s *stretcher) overlapGrain(emit func(audio.ChanneledSample)) {
+	start := int(s.pos)
+	if s.prev >= 0 {
+		start = s.bestStart(start)
+	}
+
+	for i := range s.accum {
+		for c := range s.accum[i] {
+			s.accum[i][c] += s.in[start+i][c] * stretchWindow[i]
+		}
+	}
+
+	for _, f := range s.accum[:stretchHop] {
+		sample := audio.ChanneledSample{Channels: make([]audio.Sample, stretchChannels)}
+		for c := range sample.Channels {
+			sample.Channels[c] = audio.Sample{f[c*2], f[c*2+1]}
+		}
+		emit(sample)
+	}
+
+	copy(s.accum[:], s.accum[stretchHop:])
+	for i := stretchOverlap; i < stretchGrain; i++ {
+		s.accum[i] = stretchFrame{}
+	}
+
+	s.prev = start
+	s.pos += s.speed * stretchHop
+
+	// Drop input that no later grain can use
+	drop := int(s.pos) - stretchTolerance
+	if s.prev < drop {
+		drop = s.prev
+	}
+	if drop > 0 {
+		s.in = append(s.in[:0], s.in[drop:]...)
+		s.mix = append(s.mix[:0], s.mix[drop:]...)
+		s.prev -= drop
+		s.pos -= float64(drop)
+	}
+}
+
+// bestStart returns the grain position within stretchTolerance of target whose start best matches
+// the natural continuation of the previous grain, by normalized cross-correlation
+func (s *stretcher) bestStart(target int) int {
+	continuation := s.mix[s.prev+stretchHop : s.prev+stretchHop+stretchOverlap]
+
+	lo := target - stretchTolerance
+	if lo < 0 {
+		lo = 0
+	}
+
+	best, bestScore := target, math.Inf(-1)
+	for start := lo; start <= target+stretchTolerance; start++ {
+		candidate := s.mix[start : start+stretchOverlap]
+
+		corr, energy := 0.0, 1e-9
+		for i := 0; i < stretchOverlap; i += stretchStride {
+			corr += candidate[i] * continuation[i]
+			energy += candidate[i] * candidate[i]
+		}
+
+		if score := corr / math.Sqrt(energy); score > bestScore {
+			best, bestScore = start, score
+		}
+	}
+
+	return best
+}
+
+// mono returns the sum of all channels of a frame
+func mono(f stretchFrame) float64 {
+	sum := 0.0
+	for _, v := range f {
+		sum += v
+	}
+	return sum
+}
@@ -0,0 +1,78 @@
+package audio
+
+import (
+	"math"
+	"testing"
+
+	"github.com/omstrumpf/goemu/internal/app/backends/gbc/constants"
+	"github.com/omstrumpf/goemu/internal/app/console/audio"
+)
+
+// stretchTone runs a second of a 440Hz tone through a stretcher, and returns the output
+func stretchTone(speed float64) []float64 {
+	s := newStretcher(speed)
+
+	var out []float64
+	for i := 0; i < Bitrate; i++ {
+		v := math.Sin(2 * math.Pi * 440 * float64(i) / float64(Bitrate))
+		s.push(audio.ChanneledSample{Channels: []audio.Sample{{v, v}, {}, {}, {}}}, func(sample audio.ChanneledSample) {
+			out = append(out, sample.L())
+		})
+	}
+
+	return out
+}
+
+// toneFrequency estimates the frequency of a tone at Bitrate from its rising zero crossings, skipping the fade in
+func toneFrequency(samples []float64) float64 {
+	samples = samples[stretchGrain:]
+
+	crossings := 0
+	for i := 1; i < len(samples); i++ {
+		if samples[i-1] < 0 && samples[i] >= 0 {
+			crossings++
+		}
+	}
+
+	return float64(crossings) * float64(Bitrate) / float64(len(samples))
+}
+
+func TestStretcherKeepsPitch(t *testing.T) {
+	for _, speed := range []float64{0.5, 2, 4} {
+		out := stretchTone(speed)
+
+		// Output lags by up to a grain and the search tolerance of input
+		expected := float64(Bitrate) / speed
+		if n := float64(len(out)); math.Abs(n-expected) > 3*stretchGrain {
+			t.Errorf("Speed %v: expected about %v samples, got %v", speed, expected, n)
+		}
+
+		if f := toneFrequency(out); math.Abs(f-440) > 10 {
+			t.Errorf("Speed %v: expected the tone to stay at 440Hz, got %vHz", speed, f)
+		}
+	}
+}
+
+func TestAPUSpeedFactor(t *testing.T) {
+	apu := NewAPU()
+
+	for _, speed := range []float64{1, 2, 1} {
+		apu.SetSpeedFactor(speed)
+
+		// One second of emulated time, draining the output as it would be played
+		n := 0
+		for i := 0; i < constants.ClockSpeed; i += 64 {
+			apu.RunForClocks(64)
+
+			for len(apu.outchan) > 0 {
+				<-apu.outchan
+				n++
+			}
+		}
+
+		expected := float64(Bitrate) / speed
+		if math.Abs(float64(n)-expected) > 3*stretchGrain {
+			t.Errorf("Speed %v: expected about %v samples per emulated second, got %d", speed, expected, n)
+		}
+	}
+}
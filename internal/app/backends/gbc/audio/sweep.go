@@ -1,5 +1,7 @@
 package audio
 
+import "github.com/omstrumpf/goemu/internal/app/backends/gbc/state"
+
 type sweep struct {
 	timer *timer
 
@@ -109,3 +111,25 @@ func (sweep *sweep) writeFreq(freq uint16) {
 	sweep.squareWave.frequency = freq
 	sweep.freqShadow = freq
 }
+
+func (sweep *sweep) saveState(w *state.Writer) {
+	sweep.timer.saveState(w)
+	w.U8(sweep.period)
+	w.U8(sweep.counter)
+	w.U8(sweep.shift)
+	w.Bool(sweep.enabled)
+	w.Bool(sweep.negate)
+	w.U16(sweep.freqShadow)
+	w.Bool(sweep.negativeSweepSinceLastTrigger)
+}
+
+func (sweep *sweep) loadState(r *state.Reader) {
+	sweep.timer.loadState(r)
+	sweep.period = r.U8()
+	sweep.counter = r.U8()
+	sweep.shift = r.U8()
+	sweep.enabled = r.Bool()
+	sweep.negate = r.Bool()
+	sweep.freqShadow = r.U16()
+	sweep.negativeSweepSinceLastTrigger = r.Bool()
+}
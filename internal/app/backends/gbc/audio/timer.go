@@ -4,6 +4,7 @@ import (
 	"math"
 
 	"github.com/omstrumpf/goemu/internal/app/backends/gbc/constants"
+	"github.com/omstrumpf/goemu/internal/app/backends/gbc/state"
 )
 
 type timer struct {
@@ -44,3 +45,13 @@ func (t *timer) resetDuration(clocks int) {
 	t.countdown = clocks
 	t.period = clocks
 }
+
+func (t *timer) saveState(w *state.Writer) {
+	w.Int(t.countdown)
+	w.Int(t.period)
+}
+
+func (t *timer) loadState(r *state.Reader) {
+	t.countdown = r.Int()
+	t.period = r.Int()
+}
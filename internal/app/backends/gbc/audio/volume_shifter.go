@@ -1,6 +1,9 @@
 package audio
 
-import "github.com/omstrumpf/goemu/internal/app/log"
+import (
+	"github.com/omstrumpf/goemu/internal/app/backends/gbc/state"
+	"github.com/omstrumpf/goemu/internal/app/log"
+)
 
 type volumeShifter struct {
 	volumeCode byte
@@ -29,3 +32,11 @@ func (vol *volumeShifter) sample() float64 {
 }
 
 func (vol *volumeShifter) trigger() {}
+
+func (vol *volumeShifter) saveState(w *state.Writer) {
+	w.U8(vol.volumeCode)
+}
+
+func (vol *volumeShifter) loadState(r *state.Reader) {
+	vol.volumeCode = r.U8()
+}
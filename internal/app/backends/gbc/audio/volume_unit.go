@@ -1,7 +1,12 @@
 package audio
 
+import "github.com/omstrumpf/goemu/internal/app/backends/gbc/state"
+
 type volumeUnit interface {
 	runForClocks(int)
 	sample() float64
 	trigger()
+
+	saveState(*state.Writer)
+	loadState(*state.Reader)
 }
@@ -1,5 +1,7 @@
 package banking
 
+import "github.com/omstrumpf/goemu/internal/app/backends/gbc/state"
+
 // Controller is a memory bank controller
 type Controller interface {
 	Read(uint16) byte
@@ -9,4 +11,13 @@ type Controller interface {
 
 	GetRamSave() []byte
 	LoadRamSave([]byte)
+
+	SaveState(*state.Writer)
+	LoadState(*state.Reader)
+}
+
+// Rumbler is implemented by controllers for cartridges with a rumble motor
+type Rumbler interface {
+	// Rumbling returns true if the rumble motor is currently running
+	Rumbling() bool
 }
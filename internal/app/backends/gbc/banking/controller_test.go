@@ -581,3 +581,103 @@ func TestMBC3RTC(t *testing.T) {
 		t.Errorf("Expected RTCM to remain 0, got %d", c.Read(0xA000))
 	}
 }
+
+func TestMBC5ROM(t *testing.T) {
+	c := NewMBC5(bigTestData(0x800000, 0x4000), 0x800000, 0, false)
+
+	if c.Read(0x1000) != 0x00 {
+		t.Errorf("Expected NewMBC5 to accept data argument.")
+	}
+
+	if c.Read(0x4000) != 0x01 {
+		t.Errorf("Expected MBC5.Read to find 0x01 at rom bank 1, got %#02X", c.Read(0x4000))
+	}
+
+	// Select bank 0x42
+	c.Write(0x2000, 0x42)
+	if c.Read(0x4000) != 0x42 {
+		t.Errorf("Expected MBC5 to switch to bank 0x42 and read 0x42, got %#02X", c.Read(0x4000))
+	}
+
+	// Select bank 0
+	c.Write(0x2000, 0)
+	if c.Read(0x7FFF) != 0x00 {
+		t.Errorf("Expected MBC5 to switch to bank 0 and read 0x00, got %#02X", c.Read(0x7FFF))
+	}
+
+	// Select bank 0x1FF with the 9th bit
+	c.Write(0x3000, 0x01)
+	c.Write(0x2FFF, 0xFF)
+	if c.Read(0x4000) != 0xFF || c.romBank != 0x1FF {
+		t.Errorf("Expected MBC5 to switch to bank 0x1FF and read 0xFF, got bank %#03X value %#02X", c.romBank, c.Read(0x4000))
+	}
+
+	// Clear the 9th bit, leaving the low bits
+	c.Write(0x3FFF, 0x00)
+	if c.romBank != 0xFF {
+		t.Errorf("Expected MBC5 to switch to bank 0xFF, got %#03X", c.romBank)
+	}
+
+	// 0x0000 still shows bank 0
+	if c.Read(0x3FFF) != 0x00 {
+		t.Errorf("Expected MBC5.Read to find 0x00 at rom bank 0, got %#02X", c.Read(0x3FFF))
+	}
+}
+
+func TestMBC5RAM(t *testing.T) {
+	c := NewMBC5(nil, 0x8000, 0x20000, false)
+
+	// Only 0x0A enables RAM
+	c.Write(0x0000, 0x1B)
+	c.Write(0xA000, 0xAA)
+	if c.Read(0xA000) != 0xFF {
+		t.Errorf("Expected MBC5 RAM to remain disabled, got %#02X", c.Read(0xA000))
+	}
+
+	c.Write(0x0000, 0x0A)
+
+	if c.Read(0xA000) != 0x00 {
+		t.Errorf("Expected MBC5 to not write to RAM when disabled, got %#02X", c.Read(0xA000))
+	}
+
+	// Write a distinct value to each of the 16 banks
+	for bank := byte(0); bank < 16; bank++ {
+		c.Write(0x4000, bank)
+		c.Write(0xBFFF, bank+0x10)
+	}
+
+	for bank := byte(0); bank < 16; bank++ {
+		c.Write(0x4000, bank)
+		if c.Read(0xBFFF) != bank+0x10 {
+			t.Errorf("Expected MBC5 to read %#02X from RAM bank %d, got %#02X", bank+0x10, bank, c.Read(0xBFFF))
+		}
+	}
+
+	if c.Rumbling() {
+		t.Errorf("Expected MBC5 without rumble to never rumble")
+	}
+}
+
+func TestMBC5Rumble(t *testing.T) {
+	c := NewMBC5(nil, 0x8000, 0x8000, true)
+
+	c.Write(0x0000, 0x0A)
+
+	// Select RAM bank 3 with the motor on
+	c.Write(0x4000, 0x0B)
+	if !c.Rumbling() {
+		t.Errorf("Expected MBC5 to start rumbling")
+	}
+
+	c.Write(0xA000, 0xCC)
+
+	// Select RAM bank 3 with the motor off
+	c.Write(0x4000, 0x03)
+	if c.Rumbling() {
+		t.Errorf("Expected MBC5 to stop rumbling")
+	}
+
+	if c.Read(0xA000) != 0xCC {
+		t.Errorf("Expected MBC5 rumble bit to not affect RAM bank selection, got %#02X", c.Read(0xA000))
+	}
+}
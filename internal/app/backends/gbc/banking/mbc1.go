@@ -1,6 +1,9 @@
 package banking
 
-import "github.com/omstrumpf/goemu/internal/app/log"
+import (
+	"github.com/omstrumpf/goemu/internal/app/backends/gbc/state"
+	"github.com/omstrumpf/goemu/internal/app/log"
+)
 
 // MBC1 is the first banked memory controller for gameboy
 type MBC1 struct {
@@ -118,3 +121,21 @@ func (mbc1 *MBC1) LoadRamSave(data []byte) {
 
 	copy(mbc1.ram[:], data)
 }
+
+// SaveState writes the banking registers and RAM to the save state
+func (mbc1 *MBC1) SaveState(w *state.Writer) {
+	w.Bytes(mbc1.ram)
+	w.U16(mbc1.romBank)
+	w.U16(mbc1.ramBank)
+	w.Bool(mbc1.ramEnable)
+	w.Bool(mbc1.romRAMModeSelect)
+}
+
+// LoadState restores the banking registers and RAM from the save state
+func (mbc1 *MBC1) LoadState(r *state.Reader) {
+	r.Bytes(mbc1.ram)
+	mbc1.romBank = r.U16()
+	mbc1.ramBank = r.U16()
+	mbc1.ramEnable = r.Bool()
+	mbc1.romRAMModeSelect = r.Bool()
+}
@@ -1,6 +1,9 @@
 package banking
 
-import "github.com/omstrumpf/goemu/internal/app/log"
+import (
+	"github.com/omstrumpf/goemu/internal/app/backends/gbc/state"
+	"github.com/omstrumpf/goemu/internal/app/log"
+)
 
 // MBC2 is the second banked memory controller for gameboy
 type MBC2 struct {
@@ -122,3 +125,19 @@ func (mbc2 *MBC2) LoadRamSave(data []byte) {
 
 	copy(mbc2.ram[:], data)
 }
+
+// SaveState writes the banking registers and RAM to the save state
+func (mbc2 *MBC2) SaveState(w *state.Writer) {
+	w.Bytes(mbc2.ram[:])
+	w.U8(mbc2.romBank)
+	w.U8(mbc2.ramBank)
+	w.Bool(mbc2.ramEnable)
+}
+
+// LoadState restores the banking registers and RAM from the save state
+func (mbc2 *MBC2) LoadState(r *state.Reader) {
+	r.Bytes(mbc2.ram[:])
+	mbc2.romBank = r.U8()
+	mbc2.ramBank = r.U8()
+	mbc2.ramEnable = r.Bool()
+}
@@ -2,6 +2,7 @@ package banking
 
 import (
 	"github.com/omstrumpf/goemu/internal/app/backends/gbc/constants"
+	"github.com/omstrumpf/goemu/internal/app/backends/gbc/state"
 	"github.com/omstrumpf/goemu/internal/app/log"
 )
 
@@ -113,6 +114,20 @@ func (rtc *rtc) latch(val byte) {
 	}
 }
 
+func (rtc *rtc) saveState(w *state.Writer) {
+	w.Bytes(rtc.latched[:])
+	w.Bytes(rtc.live[:])
+	w.Bool(rtc.latchState)
+	w.Int(rtc.clocksSinceLastSecond)
+}
+
+func (rtc *rtc) loadState(r *state.Reader) {
+	r.Bytes(rtc.latched[:])
+	r.Bytes(rtc.live[:])
+	rtc.latchState = r.Bool()
+	rtc.clocksSinceLastSecond = r.Int()
+}
+
 // MBC3 is the second banked memory controller for gameboy
 type MBC3 struct {
 	// 2MB ROM, in 64 banks of 16KB.
@@ -255,3 +270,23 @@ func (mbc3 *MBC3) LoadRamSave(data []byte) {
 
 	copy(mbc3.ram[:], data[5:])
 }
+
+// SaveState writes the banking registers, RAM, and RTC to the save state
+func (mbc3 *MBC3) SaveState(w *state.Writer) {
+	w.Bytes(mbc3.ram[:])
+	w.U16(mbc3.romBank)
+	w.U16(mbc3.ramTimBank)
+	w.Bool(mbc3.ramTimEnable)
+	w.Bool(mbc3.romRAMModeSelect)
+	mbc3.rtc.saveState(w)
+}
+
+// LoadState restores the banking registers, RAM, and RTC from the save state
+func (mbc3 *MBC3) LoadState(r *state.Reader) {
+	r.Bytes(mbc3.ram[:])
+	mbc3.romBank = r.U16()
+	mbc3.ramTimBank = r.U16()
+	mbc3.ramTimEnable = r.Bool()
+	mbc3.romRAMModeSelect = r.Bool()
+	mbc3.rtc.loadState(r)
+}
@@ -0,0 +1,156 @@
+package banking
+
+import (
+	"github.com/omstrumpf/goemu/internal/app/backends/gbc/state"
+	"github.com/omstrumpf/goemu/internal/app/log"
+)
+
+// MBC5 is the banked memory controller used by most later cartridges.
+// It supports up to 8MB of ROM in 512 banks, and 128KB of RAM in 16 banks.
+type MBC5 struct {
+	rom []byte
+	ram []byte
+
+	romBank uint16 // 9 bits
+	ramBank uint8  // 4 bits, or 3 bits on rumble cartridges
+
+	ramEnable bool
+
+	hasRumble bool // Bit 3 of the RAM bank register drives the rumble motor
+	rumble    bool
+}
+
+// NewMBC5 constructs a valid MBC5 struct with the given rom and ram sizes.
+// If hasRumble is set, the cartridge has a rumble motor.
+func NewMBC5(data []byte, romSize uint32, ramSize uint32, hasRumble bool) *MBC5 {
+	mbc5 := &MBC5{
+		rom:       make([]byte, romSize),
+		ram:       make([]byte, ramSize),
+		romBank:   1,
+		ramBank:   0,
+		ramEnable: false,
+		hasRumble: hasRumble,
+	}
+
+	if len(data) > len(mbc5.rom) {
+		log.Warningf("MBC5 controller loading oversized ROM. Data will be truncated.")
+	}
+
+	copy(mbc5.rom[:], data)
+
+	return mbc5
+}
+
+// RunForClocks is unused on the MBC5
+func (mbc5 *MBC5) RunForClocks(clocks int) {}
+
+// Rumbling returns true if the rumble motor is currently running
+func (mbc5 *MBC5) Rumbling() bool {
+	return mbc5.rumble
+}
+
+func (mbc5 *MBC5) Read(addr uint16) byte {
+	if addr < 0x4000 {
+		// Fixed ROM bank 0
+		return mbc5.rom[addr]
+	} else if addr < 0x8000 {
+		// Variable ROM bank
+		bankOffset := 0x4000 * uint32(mbc5.romBank)
+		romOffset := int(uint32(addr-0x4000) + bankOffset)
+		if romOffset < len(mbc5.rom) {
+			return mbc5.rom[romOffset]
+		}
+		log.Debugf("MBC5 encountered ROM read out of range: %#04x", addr)
+		return 0xFF
+	} else if addr >= 0xA000 && addr < 0xC000 {
+		if mbc5.ramEnable {
+			bankOffset := 0x2000 * uint32(mbc5.ramBank)
+			ramOffset := int(uint32(addr-0xA000) + bankOffset)
+			if ramOffset < len(mbc5.ram) {
+				return mbc5.ram[ramOffset]
+			}
+			log.Debugf("MBC5 encountered RAM read out of range: %#04x", addr)
+			return 0xFF
+		}
+		log.Debugf("MBC5 encountered RAM read with RAM disabled: %#04x", addr)
+		return 0xFF
+	} else {
+		log.Errorf("MBC5 encountered read out of range: %#04x", addr)
+		return 0xFF
+	}
+}
+
+func (mbc5 *MBC5) Write(addr uint16, val byte) {
+	if addr < 0x2000 {
+		// RAM enable. Unlike earlier controllers, only 0x0A enables RAM.
+		mbc5.ramEnable = (val&0x0F == 0x0A)
+		log.Tracef("MBC5 setting RAM enable: %t", mbc5.ramEnable)
+	} else if addr < 0x3000 {
+		// ROM bank select, low 8 bits. Bank 0 is selectable on the MBC5.
+		mbc5.romBank = (mbc5.romBank & 0x100) | uint16(val)
+		log.Tracef("MBC5 switching ROM bank to %d", mbc5.romBank)
+	} else if addr < 0x4000 {
+		// ROM bank select, bit 8
+		mbc5.romBank = (uint16(val&1) << 8) | (mbc5.romBank & 0xFF)
+		log.Tracef("MBC5 switching ROM bank to %d", mbc5.romBank)
+	} else if addr < 0x6000 {
+		// RAM bank select
+		if mbc5.hasRumble {
+			rumble := (val&0x08 != 0)
+			if rumble != mbc5.rumble {
+				log.Debugf("MBC5 setting rumble: %t", rumble)
+			}
+			mbc5.rumble = rumble
+			mbc5.ramBank = val & 0x07
+		} else {
+			mbc5.ramBank = val & 0x0F
+		}
+		log.Tracef("MBC5 switching RAM bank to %d", mbc5.ramBank)
+	} else if addr < 0x8000 {
+		// Unused
+	} else if addr >= 0xA000 && addr < 0xC000 {
+		if mbc5.ramEnable {
+			bankOffset := 0x2000 * uint32(mbc5.ramBank)
+			ramOffset := int(uint32(addr-0xA000) + bankOffset)
+			if ramOffset < len(mbc5.ram) {
+				mbc5.ram[ramOffset] = val
+			} else {
+				log.Debugf("MBC5 encountered RAM write out of range: %#04x = %#02x", addr, val)
+			}
+		} else {
+			log.Debugf("MBC5 encountered RAM write with RAM disabled: %#04x = %#02x", addr, val)
+		}
+	} else {
+		log.Errorf("MBC5 encountered write out of range: %#04x = %#02x", addr, val)
+	}
+}
+
+func (mbc5 *MBC5) GetRamSave() []byte {
+	return mbc5.ram[:]
+}
+
+func (mbc5 *MBC5) LoadRamSave(data []byte) {
+	if len(data) > len(mbc5.ram) {
+		log.Warningf("MBC5 controller loading oversized RAM save. Data will be truncated.")
+	}
+
+	copy(mbc5.ram[:], data)
+}
+
+// SaveState writes the banking registers, RAM, and rumble motor state to the save state
+func (mbc5 *MBC5) SaveState(w *state.Writer) {
+	w.Bytes(mbc5.ram)
+	w.U16(mbc5.romBank)
+	w.U8(mbc5.ramBank)
+	w.Bool(mbc5.ramEnable)
+	w.Bool(mbc5.rumble)
+}
+
+// LoadState restores the banking registers, RAM, and rumble motor state from the save state
+func (mbc5 *MBC5) LoadState(r *state.Reader) {
+	r.Bytes(mbc5.ram)
+	mbc5.romBank = r.U16()
+	mbc5.ramBank = r.U8()
+	mbc5.ramEnable = r.Bool()
+	mbc5.rumble = r.Bool()
+}
@@ -1,6 +1,9 @@
 package banking
 
-import "github.com/omstrumpf/goemu/internal/app/log"
+import (
+	"github.com/omstrumpf/goemu/internal/app/backends/gbc/state"
+	"github.com/omstrumpf/goemu/internal/app/log"
+)
 
 // ROM is a basic cartridge memory controller with a fixed 32K ROM, and no RAM
 type ROM struct {
@@ -45,3 +48,9 @@ func (rom *ROM) LoadRamSave(data []byte) {
 		log.Warningf("RAM controller cannot load RAM save file.")
 	}
 }
+
+// SaveState is unused on the ROM controller, which has no mutable state
+func (rom *ROM) SaveState(w *state.Writer) {}
+
+// LoadState is unused on the ROM controller, which has no mutable state
+func (rom *ROM) LoadState(r *state.Reader) {}
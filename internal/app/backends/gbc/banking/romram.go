@@ -1,6 +1,9 @@
 package banking
 
-import "github.com/omstrumpf/goemu/internal/app/log"
+import (
+	"github.com/omstrumpf/goemu/internal/app/backends/gbc/state"
+	"github.com/omstrumpf/goemu/internal/app/log"
+)
 
 // ROMRAM is a basic cartridge memory controller with a fixed 32K ROM, and 8K RAM
 type ROMRAM struct {
@@ -56,3 +59,13 @@ func (romram *ROMRAM) LoadRamSave(data []byte) {
 
 	copy(romram.ram[:], data)
 }
+
+// SaveState writes the cartridge RAM to the save state
+func (romram *ROMRAM) SaveState(w *state.Writer) {
+	w.Bytes(romram.ram[:])
+}
+
+// LoadState restores the cartridge RAM from the save state
+func (romram *ROMRAM) LoadState(r *state.Reader) {
+	r.Bytes(romram.ram[:])
+}
@@ -1,6 +1,10 @@
 package bios
 
-import "github.com/omstrumpf/goemu/internal/app/backends/gbc/memory"
+import (
+	"fmt"
+
+	"github.com/omstrumpf/goemu/internal/app/backends/gbc/memory"
+)
 
 // BIOS contains the Gameboy BIOS
 var BIOS = memory.NewSimpleWithData(
@@ -23,3 +27,22 @@ var BIOS = memory.NewSimpleWithData(
 		0xF5, 0x06, 0x19, 0x78, 0x86, 0x23, 0x05, 0x20, 0xFB, 0x86, 0x20, 0xFE, 0x3E, 0x01, 0xE0, 0x50,
 	},
 )
+
+const (
+	// DMGLength is the size of the DMG, MGB, and SGB boot ROMs, mapped at 0x0000-0x00FF
+	DMGLength = 0x100
+
+	// CGBLength is the size of the CGB boot ROM, mapped at 0x0000-0x00FF and 0x0200-0x08FF.
+	// The cartridge header remains visible at 0x0100-0x01FF.
+	CGBLength = 0x900
+)
+
+// Validate checks that the boot ROM image has a supported size
+func Validate(data []byte) error {
+	switch len(data) {
+	case DMGLength, CGBLength:
+		return nil
+	}
+
+	return fmt.Errorf("unsupported boot ROM size %#x, expected %#x or %#x", len(data), DMGLength, CGBLength)
+}
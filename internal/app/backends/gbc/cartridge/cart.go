@@ -7,6 +7,9 @@ import (
 	"github.com/omstrumpf/goemu/internal/app/log"
 )
 
+// minROMSize is the size of the smallest cartridge ROM, with two 16KB banks
+const minROMSize = 0x8000
+
 // CART represents a gameboy game cartridge.
 type CART struct {
 	title        [16]byte
@@ -21,20 +24,33 @@ type CART struct {
 	BankController banking.Controller
 }
 
-// NewCart creates a valid CART struct from the given rom data
+// NewCart creates a valid CART struct from the given rom data.
+// The header is validated, and any issues are logged. Truncated ROMs are padded with zeros to at least two banks,
+// and to the size declared in the header, so that the bank controller never reads past the end.
 func NewCart(rom []byte) *CART {
 	c := new(CART)
 
-	// Cartridge mode
-	switch rom[0x0143] {
-	case 0x80:
-		c.mode = DMG | CGB // Cartridge supports both DMG and CGB mode
-	case 0xC0:
-		c.mode = CGB // Cartridge supports only CGB mode
-	default:
-		c.mode = DMG // Cartridge supports only DMB mode
+	for _, issue := range Validate(rom) {
+		log.Warningf("Cartridge header %v", issue)
+	}
+
+	size := minROMSize
+	if len(rom) >= HeaderEnd {
+		if h, err := ParseHeader(rom); err == nil && h.KnownROM && int(h.ROMSize) > size {
+			size = int(h.ROMSize)
+		}
+	}
+	if len(rom) < size {
+		padded := make([]byte, size)
+		copy(padded, rom)
+		rom = padded
 	}
 
+	h, _ := ParseHeader(rom)
+
+	// Cartridge mode
+	c.mode = h.Mode
+
 	// Title and manufacturer code
 	if c.mode&CGB == 0 {
 		copy(c.title[:], rom[0x0134:0x0144])
@@ -45,38 +61,16 @@ func NewCart(rom []byte) *CART {
 	copy(c.licenseeCode[:], rom[0x0144:0x0146])
 
 	// SGB capabilities
-	switch rom[0x0146] {
-	case 0x03:
-		c.sgbFlag = true
-	default:
-		c.sgbFlag = false
-	}
+	c.sgbFlag = h.SGB
 
-	// Cartridge ROM size
-	switch rom[0x0148] {
-	case 0x00, 0x01, 0x02, 0x03, 0x04, 0x05, 0x06, 0x07:
-		c.romSize = 0x10000 << rom[0x0148]
-	case 0x52:
-		c.romSize = 0x10000 * 72
-	case 0x53:
-		c.romSize = 0x10000 * 80
-	case 0x54:
-		c.romSize = 0x10000 * 96
+	// Cartridge ROM and RAM size
+	c.romSize = h.ROMSize
+	c.ramSize = h.RAMSize
+	if !h.KnownROM {
+		c.romSize = uint32(len(rom))
 	}
-
-	// Cartridge RAM size
-	switch rom[0x0149] {
-	case 0x01:
-		c.ramSize = 0x800 // 2KB
-	case 0x02:
-		c.ramSize = 0x4000 // 8KB
-	case 0x03:
-		c.ramSize = 0x10000 // 32KB
-	default:
-		log.Warningf("Unsupported cartridge RAM size (%#02x). Defaulting to 0.", rom[0x0148])
-		fallthrough
-	case 0x00:
-		c.ramSize = 0
+	if c.romSize < minROMSize {
+		c.romSize = minROMSize
 	}
 
 	// Memory bank controller
@@ -115,6 +109,24 @@ func NewCart(rom []byte) *CART {
 	case 0x13:
 		c.cartType = MBC3RAMBAT
 		c.BankController = banking.NewMBC3(rom)
+	case 0x19:
+		c.cartType = MBC5
+		c.BankController = banking.NewMBC5(rom, c.romSize, 0, false)
+	case 0x1A:
+		c.cartType = MBC5RAM
+		c.BankController = banking.NewMBC5(rom, c.romSize, c.ramSize, false)
+	case 0x1B:
+		c.cartType = MBC5RAMBAT
+		c.BankController = banking.NewMBC5(rom, c.romSize, c.ramSize, false)
+	case 0x1C:
+		c.cartType = MBC5RUMBLE
+		c.BankController = banking.NewMBC5(rom, c.romSize, 0, true)
+	case 0x1D:
+		c.cartType = MBC5RUMBLERAM
+		c.BankController = banking.NewMBC5(rom, c.romSize, c.ramSize, true)
+	case 0x1E:
+		c.cartType = MBC5RUMBLERAMBAT
+		c.BankController = banking.NewMBC5(rom, c.romSize, c.ramSize, true)
 	default:
 		log.Warningf("Unsupported cartridge controller type (%#02x). Defaulting to simple ROM controller.", rom[0x0147])
 		fallthrough
@@ -146,6 +158,11 @@ func (c *CART) DebugString() string {
 	)
 }
 
+// Mode returns the modes supported by the cartridge
+func (c *CART) Mode() Mode {
+	return c.mode
+}
+
 // Title returns the cartridge title
 func (c *CART) Title() string {
 	return string(c.title[:])
@@ -15,6 +15,8 @@ func (m Mode) String() string {
 		return "DMG"
 	case CGB:
 		return "CGB"
+	case DMG | CGB:
+		return "DMG+CGB"
 	default:
 		return "UNKNOWN"
 	}
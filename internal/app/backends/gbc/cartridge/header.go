@@ -0,0 +1,368 @@
+package cartridge
+
+import (
+	"errors"
+	"fmt"
+)
+
+const (
+	// HeaderEnd is the address immediately following the cartridge header. ROMs shorter than this have no valid header.
+	HeaderEnd = 0x0150
+)
+
+// logo is the Nintendo logo bitmap, which the boot ROM compares against 0x0104-0x0133
+var logo = [48]byte{
+	0xCE, 0xED, 0x66, 0x66, 0xCC, 0x0D, 0x00, 0x0B, 0x03, 0x73, 0x00, 0x83, 0x00, 0x0C, 0x00, 0x0D,
+	0x00, 0x08, 0x11, 0x1F, 0x88, 0x89, 0x00, 0x0E, 0xDC, 0xCC, 0x6E, 0xE6, 0xDD, 0xDD, 0xD9, 0x99,
+	0xBB, 0xBB, 0x67, 0x63, 0x6E, 0x0E, 0xEC, 0xCC, 0xDD, 0xDC, 0x99, 0x9F, 0xBB, 0xB9, 0x33, 0x3E,
+}
+
+// ErrROMTooShort is returned when the ROM is too short to contain a cartridge header
+var ErrROMTooShort = errors.New("ROM is too short to contain a cartridge header")
+
+// Header is the parsed cartridge header, located at 0x0100-0x014F of the ROM
+type Header struct {
+	Title        string
+	Manufacturer string // Only present on CGB cartridges
+
+	CGBFlag byte
+	Mode    Mode
+
+	NewLicenseeCode string // Only used when OldLicenseeCode is 0x33
+	OldLicenseeCode byte
+
+	SGBFlag byte
+	SGB     bool
+
+	CartTypeCode byte
+	CartType     CartType
+	KnownType    bool
+
+	ROMSizeCode byte
+	ROMSize     uint32
+	KnownROM    bool
+
+	RAMSizeCode byte
+	RAMSize     uint32
+	KnownRAM    bool
+
+	Version byte
+
+	HeaderChecksum         byte // Stored at 0x014D
+	ComputedHeaderChecksum byte
+
+	GlobalChecksum         uint16 // Stored at 0x014E-0x014F
+	ComputedGlobalChecksum uint16
+
+	LogoValid bool
+
+	ROMLength int // Length of the ROM data the header was parsed from
+}
+
+// FixChecksums writes the header and global checksums computed from the rom data to its header.
+// Roms too short to hold a header are left unchanged.
+func FixChecksums(rom []byte) {
+	if len(rom) < HeaderEnd {
+		return
+	}
+
+	rom[0x014D] = headerChecksum(rom)
+
+	global := globalChecksum(rom)
+	rom[0x014E] = byte(global >> 8)
+	rom[0x014F] = byte(global)
+}
+
+// headerChecksum computes the checksum of the header bytes 0x0134-0x014C, as verified by the boot ROM
+func headerChecksum(rom []byte) byte {
+	var sum byte
+	for _, b := range rom[0x0134:0x014D] {
+		sum = sum - b - 1
+	}
+	return sum
+}
+
+// globalChecksum computes the sum of every rom byte, except the global checksum itself
+func globalChecksum(rom []byte) uint16 {
+	var sum uint16
+	for i, b := range rom {
+		if i != 0x014E && i != 0x014F {
+			sum += uint16(b)
+		}
+	}
+	return sum
+}
+
+// ParseHeader parses the cartridge header from the given rom data
+func ParseHeader(rom []byte) (*Header, error) {
+	if len(rom) < HeaderEnd {
+		return nil, ErrROMTooShort
+	}
+
+	h := new(Header)
+
+	h.ROMLength = len(rom)
+
+	// Cartridge mode
+	h.CGBFlag = rom[0x0143]
+	switch h.CGBFlag {
+	case 0x80:
+		h.Mode = DMG | CGB // Cartridge supports both DMG and CGB mode
+	case 0xC0:
+		h.Mode = CGB // Cartridge supports only CGB mode
+	default:
+		h.Mode = DMG // Cartridge supports only DMB mode
+	}
+
+	// Title and manufacturer code
+	if h.Mode&CGB == 0 {
+		h.Title = trimTitle(rom[0x0134:0x0144])
+	} else {
+		h.Title = trimTitle(rom[0x0134:0x013F])
+		h.Manufacturer = trimTitle(rom[0x013F:0x0143])
+	}
+
+	// Licensee
+	h.NewLicenseeCode = string(rom[0x0144:0x0146])
+	h.OldLicenseeCode = rom[0x014B]
+
+	// SGB capabilities
+	h.SGBFlag = rom[0x0146]
+	h.SGB = (h.SGBFlag == 0x03)
+
+	// Cartridge type, ROM and RAM sizes
+	h.CartTypeCode = rom[0x0147]
+	h.CartType, h.KnownType = cartTypeFromCode(h.CartTypeCode)
+
+	h.ROMSizeCode = rom[0x0148]
+	h.ROMSize, h.KnownROM = romSizeFromCode(h.ROMSizeCode)
+
+	h.RAMSizeCode = rom[0x0149]
+	h.RAMSize, h.KnownRAM = ramSizeFromCode(h.RAMSizeCode)
+
+	h.Version = rom[0x014C]
+
+	// Checksums
+	h.HeaderChecksum = rom[0x014D]
+	h.ComputedHeaderChecksum = headerChecksum(rom)
+
+	h.GlobalChecksum = uint16(rom[0x014E])<<8 | uint16(rom[0x014F])
+	h.ComputedGlobalChecksum = globalChecksum(rom)
+
+	h.LogoValid = (string(rom[0x0104:0x0134]) == string(logo[:]))
+
+	return h, nil
+}
+
+// Licensee returns the name of the cartridge's licensee, decoded from the old or new licensee code
+func (h *Header) Licensee() string {
+	if h.OldLicenseeCode == 0x33 {
+		if name, ok := newLicensees[h.NewLicenseeCode]; ok {
+			return name
+		}
+		return "Unknown"
+	}
+
+	if name, ok := oldLicensees[h.OldLicenseeCode]; ok {
+		return name
+	}
+	return "Unknown"
+}
+
+// Severity is the severity of a cartridge validation issue
+type Severity int
+
+// Severities
+const (
+	// SeverityWarning issues are tolerated by real hardware, but may indicate a bad dump
+	SeverityWarning Severity = iota
+	// SeverityError issues would prevent the cartridge from booting on real hardware
+	SeverityError
+)
+
+func (s Severity) String() string {
+	switch s {
+	case SeverityWarning:
+		return "warning"
+	case SeverityError:
+		return "error"
+	default:
+		return "unknown"
+	}
+}
+
+// Issue is a problem found while validating a cartridge
+type Issue struct {
+	Severity Severity
+	Message  string
+}
+
+func (i Issue) Error() string {
+	return fmt.Sprintf("%s: %s", i.Severity, i.Message)
+}
+
+// Validate checks the rom data for a well formed cartridge header, and returns any issues found
+func Validate(rom []byte) []Issue {
+	h, err := ParseHeader(rom)
+	if err != nil {
+		return []Issue{{SeverityError, fmt.Sprintf("%v (%#x bytes)", err, len(rom))}}
+	}
+
+	return h.Validate()
+}
+
+// Validate checks the parsed header for consistency, and returns any issues found
+func (h *Header) Validate() []Issue {
+	var issues []Issue
+
+	addIssue := func(severity Severity, format string, args ...interface{}) {
+		issues = append(issues, Issue{severity, fmt.Sprintf(format, args...)})
+	}
+
+	if !h.LogoValid {
+		addIssue(SeverityError, "Nintendo logo does not match")
+	}
+
+	if h.HeaderChecksum != h.ComputedHeaderChecksum {
+		addIssue(SeverityError, "header checksum mismatch: header has %#02x, computed %#02x", h.HeaderChecksum, h.ComputedHeaderChecksum)
+	}
+
+	if h.GlobalChecksum != h.ComputedGlobalChecksum {
+		addIssue(SeverityWarning, "global checksum mismatch: header has %#04x, computed %#04x", h.GlobalChecksum, h.ComputedGlobalChecksum)
+	}
+
+	if !h.KnownType {
+		addIssue(SeverityWarning, "unknown cartridge type %#02x", h.CartTypeCode)
+	}
+
+	if !h.KnownROM {
+		addIssue(SeverityWarning, "unknown ROM size %#02x", h.ROMSizeCode)
+	} else if uint32(h.ROMLength) != h.ROMSize {
+		addIssue(SeverityWarning, "ROM length %#x does not match header ROM size %#x", h.ROMLength, h.ROMSize)
+	}
+
+	if !h.KnownRAM {
+		addIssue(SeverityWarning, "unknown RAM size %#02x", h.RAMSizeCode)
+	}
+
+	return issues
+}
+
+// HasErrors returns true if any of the issues are errors
+func HasErrors(issues []Issue) bool {
+	for _, issue := range issues {
+		if issue.Severity == SeverityError {
+			return true
+		}
+	}
+	return false
+}
+
+// trimTitle returns the title bytes as a string, up to the first null byte
+func trimTitle(b []byte) string {
+	for i, c := range b {
+		if c == 0 {
+			return string(b[:i])
+		}
+	}
+	return string(b)
+}
+
+// romSizeFromCode decodes the ROM size header byte at 0x0148
+func romSizeFromCode(code byte) (uint32, bool) {
+	switch code {
+	case 0x00, 0x01, 0x02, 0x03, 0x04, 0x05, 0x06, 0x07, 0x08:
+		return 0x8000 << code, true
+	case 0x52:
+		return 0x4000 * 72, true
+	case 0x53:
+		return 0x4000 * 80, true
+	case 0x54:
+		return 0x4000 * 96, true
+	}
+	return 0, false
+}
+
+// ramSizeFromCode decodes the RAM size header byte at 0x0149
+func ramSizeFromCode(code byte) (uint32, bool) {
+	switch code {
+	case 0x00:
+		return 0, true
+	case 0x01:
+		return 0x800, true // 2KB
+	case 0x02:
+		return 0x2000, true // 8KB
+	case 0x03:
+		return 0x8000, true // 32KB
+	case 0x04:
+		return 0x20000, true // 128KB
+	case 0x05:
+		return 0x10000, true // 64KB
+	}
+	return 0, false
+}
+
+// cartTypeFromCode decodes the cartridge type header byte at 0x0147
+func cartTypeFromCode(code byte) (CartType, bool) {
+	switch code {
+	case 0x00:
+		return ROM, true
+	case 0x01:
+		return MBC1, true
+	case 0x02:
+		return MBC1RAM, true
+	case 0x03:
+		return MBC1RAMBAT, true
+	case 0x05:
+		return MBC2, true
+	case 0x06:
+		return MBC2BAT, true
+	case 0x08:
+		return ROMRAM, true
+	case 0x09:
+		return ROMRAMBAT, true
+	case 0x0B:
+		return MMM01, true
+	case 0x0C:
+		return MM01RAM, true
+	case 0x0D:
+		return MM01RAMBAT, true
+	case 0x0F:
+		return MBC3TIMBAT, true
+	case 0x10:
+		return MBC3TIMRAMBAT, true
+	case 0x11:
+		return MBC3, true
+	case 0x12:
+		return MBC3RAM, true
+	case 0x13:
+		return MBC3RAMBAT, true
+	case 0x15:
+		return MBC4, true
+	case 0x16:
+		return MBC4RAM, true
+	case 0x17:
+		return MBC4RAMBAT, true
+	case 0x19:
+		return MBC5, true
+	case 0x1A:
+		return MBC5RAM, true
+	case 0x1B:
+		return MBC5RAMBAT, true
+	case 0x1C:
+		return MBC5RUMBLE, true
+	case 0x1D:
+		return MBC5RUMBLERAM, true
+	case 0x1E:
+		return MBC5RUMBLERAMBAT, true
+	case 0xFC:
+		return POCKETCAM, true
+	case 0xFD:
+		return BANDAITAMA5, true
+	case 0xFE:
+		return HUC3, true
+	case 0xFF:
+		return HUC1RAMBAT, true
+	}
+	return ROM, false
+}
@@ -0,0 +1,120 @@
+package cartridge
+
+import "testing"
+
+// validROM builds a 32KB ROM with a well formed header
+func validROM() []byte {
+	rom := make([]byte, 0x8000)
+
+	copy(rom[0x0104:], logo[:])
+	copy(rom[0x0134:], "HEADERTEST")
+	rom[0x0143] = 0x80 // DMG and CGB
+	rom[0x0146] = 0x03 // SGB
+	rom[0x0147] = 0x1B // MBC5+RAM+BAT
+	rom[0x0148] = 0x00 // 32KB
+	rom[0x0149] = 0x03 // 32KB
+	rom[0x014B] = 0x01 // Nintendo
+
+	FixChecksums(rom)
+
+	return rom
+}
+
+func TestParseHeader(t *testing.T) {
+	h, err := ParseHeader(validROM())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if h.Title != "HEADERTEST" {
+		t.Errorf("Expected title HEADERTEST, got %q", h.Title)
+	}
+	if h.Mode != DMG|CGB {
+		t.Errorf("Expected DMG+CGB mode, got %v", h.Mode)
+	}
+	if !h.SGB {
+		t.Errorf("Expected SGB support")
+	}
+	if h.CartType != MBC5RAMBAT {
+		t.Errorf("Expected MBC5+RAM+BAT, got %v", h.CartType)
+	}
+	if h.ROMSize != 0x8000 || h.RAMSize != 0x8000 {
+		t.Errorf("Expected 32KB ROM and RAM, got %#x and %#x", h.ROMSize, h.RAMSize)
+	}
+	if h.Licensee() != "Nintendo" {
+		t.Errorf("Expected licensee Nintendo, got %q", h.Licensee())
+	}
+
+	if issues := h.Validate(); len(issues) != 0 {
+		t.Errorf("Expected no validation issues, got %v", issues)
+	}
+}
+
+func TestNewLicensee(t *testing.T) {
+	rom := validROM()
+	rom[0x014B] = 0x33
+	copy(rom[0x0144:], "A4")
+
+	h, _ := ParseHeader(rom)
+	if h.Licensee() != "Konami (Yu-Gi-Oh!)" {
+		t.Errorf("Expected new licensee code to be used, got %q", h.Licensee())
+	}
+}
+
+func TestValidate(t *testing.T) {
+	if issues := Validate(make([]byte, 0x100)); len(issues) != 1 || !HasErrors(issues) {
+		t.Errorf("Expected a single error for a short ROM, got %v", issues)
+	}
+
+	rom := validROM()
+	rom[0x0200] = 0xFF // Corrupts only the global checksum
+	issues := Validate(rom)
+	if len(issues) != 1 || HasErrors(issues) {
+		t.Errorf("Expected a single warning for a global checksum mismatch, got %v", issues)
+	}
+
+	rom = validROM()
+	rom[0x0134] = 'X' // Corrupts both checksums
+	rom[0x0104] = 0x00
+	issues = Validate(rom)
+	if len(issues) != 3 || !HasErrors(issues) {
+		t.Errorf("Expected logo, header checksum, and global checksum issues, got %v", issues)
+	}
+}
+
+func TestNewCartShortROM(t *testing.T) {
+	c := NewCart([]byte{0x00, 0x01})
+
+	if c.Read(0x0001) != 0x01 || c.Read(0x0143) != 0x00 {
+		t.Errorf("Expected NewCart to pad short ROMs")
+	}
+}
+
+func TestNewCartTruncatedROM(t *testing.T) {
+	// Truncated dumps are padded to two banks when the size code is unknown, and to the declared size otherwise
+	for _, tc := range []struct {
+		cartType, sizeCode byte
+		size               uint32
+	}{
+		{0x01, 0x77, 0x8000},   // MBC1, unknown size
+		{0x13, 0x77, 0x8000},   // MBC3, unknown size
+		{0x13, 0x02, 0x20000},  // MBC3, 128KB
+		{0x19, 0x05, 0x100000}, // MBC5, 1MB
+	} {
+		rom := make([]byte, 0x200)
+		rom[0x0147] = tc.cartType
+		rom[0x0148] = tc.sizeCode
+
+		c := NewCart(rom)
+		if c.romSize != tc.size {
+			t.Errorf("Type %#02x, size code %#02x: expected a ROM size of %#x, got %#x", tc.cartType, tc.sizeCode, tc.size, c.romSize)
+		}
+
+		// Reads past the end of the dump, from every bank, don't panic
+		c.Read(0x3000)
+		for bank := byte(1); bank < 0x40; bank++ {
+			c.Write(0x2000, bank)
+			c.Read(0x7FFF)
+		}
+	}
+}
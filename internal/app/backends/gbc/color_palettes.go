@@ -0,0 +1,70 @@
+package gbc
+
+import (
+	"image/color"
+
+	"github.com/omstrumpf/goemu/internal/app/backends/gbc/state"
+)
+
+// colorPalettes is CGB palette memory, holding 8 palettes of 4 colors each.
+// Colors are 15-bit little-endian values, with 5 bits each of red, green, and blue.
+type colorPalettes struct {
+	data [64]byte
+
+	index     byte // Index into data for the next data access
+	increment bool // Increment the index after each data write
+}
+
+// readIndex returns the value of the index register (BCPS/OCPS)
+func (p *colorPalettes) readIndex() byte {
+	ret := 0x40 | p.index
+	if p.increment {
+		ret |= 0x80
+	}
+	return ret
+}
+
+// writeIndex sets the index register (BCPS/OCPS)
+func (p *colorPalettes) writeIndex(val byte) {
+	p.index = val & 0x3F
+	p.increment = (val&0x80 != 0)
+}
+
+// readData returns the byte of palette memory selected by the index register (BCPD/OCPD)
+func (p *colorPalettes) readData() byte {
+	return p.data[p.index]
+}
+
+// writeData writes the byte of palette memory selected by the index register (BCPD/OCPD)
+func (p *colorPalettes) writeData(val byte) {
+	p.data[p.index] = val
+	if p.increment {
+		p.index = (p.index + 1) & 0x3F
+	}
+}
+
+// color returns the RGBA color for the 2 bit value in the given palette (0-7)
+func (p *colorPalettes) color(palette byte, val byte) color.RGBA {
+	i := (palette&0x07)<<3 | (val&0x03)<<1
+	return rgb15ToRGBA(uint16(p.data[i]) | uint16(p.data[i+1])<<8)
+}
+
+func (p *colorPalettes) SaveState(w *state.Writer) {
+	w.Bytes(p.data[:])
+	w.U8(p.readIndex())
+}
+
+func (p *colorPalettes) LoadState(r *state.Reader) {
+	r.Bytes(p.data[:])
+	p.writeIndex(r.U8())
+}
+
+// Maps a 15-bit CGB color to RGBA, scaling each 5 bit component to 8 bits
+func rgb15ToRGBA(c uint16) color.RGBA {
+	scale := func(v uint16) uint8 {
+		v &= 0x1F
+		return uint8(v<<3 | v>>2)
+	}
+
+	return color.RGBA{scale(c), scale(c >> 5), scale(c >> 10), 0xFF}
+}
@@ -2,9 +2,13 @@ package gbc
 
 import (
 	"github.com/omstrumpf/goemu/internal/app/backends/gbc/interrupts"
+	"github.com/omstrumpf/goemu/internal/app/backends/gbc/state"
 	"github.com/omstrumpf/goemu/internal/app/log"
 )
 
+// speedSwitchClocks is the number of clocks the CPU is paused for during a CGB speed switch
+const speedSwitchClocks = 2050
+
 // CPU represents the central processing unit. Stores the state and instruction map.
 type CPU struct {
 	AF Register // Accumulator and flags
@@ -48,10 +52,15 @@ func NewCPU(mmu *MMU) *CPU {
 func (cpu *CPU) ProcessNextInstruction() int {
 	cpu.instructionClock = 0
 
-	if cpu.halt || cpu.stop {
+	if cpu.stop {
+		// Only a button press wakes the CPU from STOP, regardless of the enabled interrupts
+		if cpu.mmu.Read(0xFF0F)&(1<<interrupts.JoypadBit) != 0 {
+			cpu.stop = false
+		}
+		cpu.instructionClock++
+	} else if cpu.halt {
 		if cpu.mmu.Read(0xFFFF)&cpu.mmu.Read(0xFF0F)&0x1F != 0 {
 			cpu.halt = false
-			cpu.stop = false // TODO are halt and stop really treated the same?
 		}
 		cpu.instructionClock++
 	} else {
@@ -81,6 +90,33 @@ func (cpu *CPU) IsStopped() bool {
 	return cpu.stop
 }
 
+// SaveState writes the CPU registers and flags to the save state
+func (cpu *CPU) SaveState(w *state.Writer) {
+	for _, reg := range cpu.registers() {
+		w.U16(reg.HiLo())
+	}
+
+	w.Bool(cpu.halt)
+	w.Bool(cpu.stop)
+	w.Bool(cpu.ime)
+}
+
+// LoadState restores the CPU registers and flags from the save state
+func (cpu *CPU) LoadState(r *state.Reader) {
+	for _, reg := range cpu.registers() {
+		reg.Set(r.U16())
+	}
+
+	cpu.halt = r.Bool()
+	cpu.stop = r.Bool()
+	cpu.ime = r.Bool()
+}
+
+// registers returns the CPU registers in a fixed order, for serialization
+func (cpu *CPU) registers() []*Register {
+	return []*Register{&cpu.AF, &cpu.BC, &cpu.DE, &cpu.HL, &cpu.SP, &cpu.PC}
+}
+
 // Interrupts
 func (cpu *CPU) handleInterrupts() {
 	if !cpu.ime {
@@ -0,0 +1,476 @@
+package gbc
+
+import (
+	"fmt"
+	"image/color"
+
+	"github.com/omstrumpf/goemu/internal/app/backends/gbc/state"
+)
+
+const (
+	// dotsPerClock is the number of pixel clocks (dots) in each clock cycle
+	dotsPerClock = 4
+
+	// fifoFetchDots is the number of dots taken to fetch a row of tile data, for either the background or a sprite
+	fifoFetchDots = 6
+
+	// fifoMaxSprites is the number of sprites that can be drawn on a single line
+	fifoMaxSprites = 10
+)
+
+// fifoPixel is a pixel waiting in a pixel FIFO. Colors are looked up when the pixel is drawn,
+// so that palette writes take effect mid-line.
+type fifoPixel struct {
+	val      byte // 2 bit color value
+	palette  byte // CGB palette number, or DMG sprite palette (0 or 1)
+	priority bool // Background: CGB BG-to-OAM priority attribute. Sprites: OBJ-to-BG priority flag.
+	oamIndex byte // Sprites: the index of the sprite in OAM
+}
+
+// pixelQueue is a fixed size queue of pixels
+type pixelQueue struct {
+	pixels [16]fifoPixel
+	head   int
+	length int
+}
+
+func (q *pixelQueue) push(p fifoPixel) {
+	q.pixels[(q.head+q.length)%len(q.pixels)] = p
+	q.length++
+}
+
+func (q *pixelQueue) pop() fifoPixel {
+	p := q.pixels[q.head]
+	q.head = (q.head + 1) % len(q.pixels)
+	q.length--
+	return p
+}
+
+// at returns the ith pixel from the front of the queue
+func (q *pixelQueue) at(i int) *fifoPixel {
+	return &q.pixels[(q.head+i)%len(q.pixels)]
+}
+
+func (q *pixelQueue) clear() {
+	q.head = 0
+	q.length = 0
+}
+
+// pixelFIFO renders a line one dot at a time during mode 3, the way the hardware does.
+// A background fetcher fills the background FIFO with a row of 8 pixels whenever it empties, and sprites are
+// mixed into the sprite FIFO as the line reaches them, stalling the background. This makes the length of mode 3
+// depend on the scroll, the window, and the sprites on the line, and lets mid-line register writes take effect.
+type pixelFIFO struct {
+	ppu *PPU
+
+	x       int // Pixels drawn on the current line
+	discard int // Background pixels still to be discarded before drawing, for fine scrolling
+	delay   int // Dots remaining in the initial fetch, which is thrown away
+	done    bool
+
+	bg      pixelQueue
+	sprites pixelQueue
+
+	// Background fetcher
+	fetchStep  int    // Dot in the current fetch. The fetcher waits to push once all the data is fetched.
+	fetchX     uint16 // Tile column, relative to the scroll position or window start
+	inWindow   bool   // Fetching from the window map
+	tileAddr   uint16
+	attributes byte
+	tileLo     byte
+	tileHi     byte
+
+	// Sprites on the current line, as OAM indices in OAM order
+	lineSprites  [fifoMaxSprites]byte
+	spriteCount  int
+	spriteDone   [fifoMaxSprites]bool
+	spriteFetch  int // Dots remaining in the active sprite fetch
+	spriteActive int // The sprite being fetched, as an index into lineSprites
+}
+
+// startLine resets the FIFO at the start of mode 3, and selects the sprites on the line
+func (f *pixelFIFO) startLine() {
+	ppu := f.ppu
+
+	f.x = 0
+	f.discard = int(ppu.bgScrollX & 0x7)
+	f.delay = fifoFetchDots
+	f.done = false
+
+	f.bg.clear()
+	f.sprites.clear()
+
+	f.fetchStep = 0
+	f.fetchX = 0
+	f.inWindow = false
+
+	f.spriteFetch = 0
+	f.spriteCount = 0
+
+	height := byte(8)
+	if ppu.spriteSize {
+		height = 16
+	}
+
+	// The first 10 sprites in OAM that cover the line
+	for i := range ppu.oam.sprites {
+		s := &ppu.oam.sprites[i]
+		if y := int(ppu.line) + 16; y >= int(s.yPos) && y < int(s.yPos)+int(height) {
+			f.lineSprites[f.spriteCount] = byte(i)
+			f.spriteDone[f.spriteCount] = (s.xPos == 0 || s.xPos >= 168) // Offscreen
+			f.spriteCount++
+
+			if f.spriteCount == fifoMaxSprites {
+				break
+			}
+		}
+	}
+}
+
+// RunForDots runs the FIFO for the given number of dots, and returns true once the line is complete
+func (f *pixelFIFO) RunForDots(dots int) bool {
+	for d := 0; d < dots && !f.done; d++ {
+		f.tick()
+	}
+	return f.done
+}
+
+// tick runs the FIFO for a single dot
+func (f *pixelFIFO) tick() {
+	ppu := f.ppu
+
+	if f.delay > 0 {
+		f.delay--
+		return
+	}
+
+	// Background output is stalled while a sprite is fetched
+	if f.spriteFetch > 0 {
+		f.spriteFetch--
+		if f.spriteFetch == 0 {
+			f.loadSprite(f.spriteActive)
+		}
+		return
+	}
+
+	if ppu.spriteEnable {
+		if i := f.nextSprite(); i >= 0 {
+			if f.bg.length == 0 {
+				// The sprite fetch waits for the background fetcher to fill the FIFO
+				f.fetchBackground()
+				return
+			}
+
+			f.spriteDone[i] = true
+			f.spriteActive = i
+			f.spriteFetch = fifoFetchDots
+			return
+		}
+	}
+
+	f.fetchBackground()
+
+	if !f.inWindow {
+		if screenX, discard, ok := ppu.windowStart(); ok && f.x >= screenX {
+			f.inWindow = true
+			f.bg.clear()
+			f.fetchStep = 0
+			f.fetchX = 0
+
+			// The window starts partially offscreen when WX is less than 7
+			f.discard = discard
+			return
+		}
+	}
+
+	if f.bg.length == 0 {
+		return
+	}
+
+	f.drawPixel()
+}
+
+// nextSprite returns the next sprite to fetch at the current pixel, or -1 if there is none.
+// Sprites are fetched from left to right, and in OAM order at the same position.
+func (f *pixelFIFO) nextSprite() int {
+	next := -1
+	for i := 0; i < f.spriteCount; i++ {
+		if f.spriteDone[i] {
+			continue
+		}
+
+		s := &f.ppu.oam.sprites[f.lineSprites[i]]
+		if int(s.xPos)-8 > f.x {
+			continue
+		}
+
+		if next < 0 || s.xPos < f.ppu.oam.sprites[f.lineSprites[next]].xPos {
+			next = i
+		}
+	}
+
+	return next
+}
+
+// fetchBackground runs the background fetcher for a single dot
+func (f *pixelFIFO) fetchBackground() {
+	ppu := f.ppu
+
+	switch f.fetchStep {
+	case 0: // Tile number
+		var mapAddr, mapX, mapY uint16
+		var tileY byte
+
+		if f.inWindow {
+			mapAddr = 0x9800
+			if ppu.windowMap {
+				mapAddr = 0x9C00
+			}
+
+			row := ppu.windowLine
+			mapX = f.fetchX & 31
+			mapY = uint16(row >> 3)
+			tileY = row & 0x7
+		} else {
+			mapAddr = 0x9800
+			if ppu.bgMap {
+				mapAddr = 0x9C00
+			}
+
+			row := ppu.line + ppu.bgScrollY
+			mapX = (uint16(ppu.bgScrollX>>3) + f.fetchX) & 31
+			mapY = uint16(row >> 3)
+			tileY = row & 0x7
+		}
+
+		f.attributes = ppu.getTileAttributes(mapAddr, mapX, mapY)
+		if f.attributes&0x40 != 0 { // Vertical flip
+			tileY = 7 - tileY
+		}
+
+		f.tileAddr = ppu.getTileAddress(mapAddr, mapX, mapY) + uint16(tileY)*2
+	case 2: // Tile data low
+		f.tileLo = ppu.mmu.ReadVRAM((f.attributes>>3)&1, f.tileAddr)
+	case 4: // Tile data high
+		f.tileHi = ppu.mmu.ReadVRAM((f.attributes>>3)&1, f.tileAddr+1)
+	case fifoFetchDots: // Push, once the FIFO is empty
+		if f.bg.length > 0 {
+			return
+		}
+
+		for x := byte(0); x < 8; x++ {
+			f.bg.push(fifoPixel{
+				val:      tileRowVal(f.tileLo, f.tileHi, x, f.attributes&0x20 != 0),
+				palette:  f.attributes & 0x07,
+				priority: f.attributes&0x80 != 0,
+			})
+		}
+
+		f.fetchX++
+		f.fetchStep = 0
+		return
+	}
+
+	f.fetchStep++
+}
+
+// loadSprite fetches a row of the given sprite, and mixes it into the sprite FIFO
+func (f *pixelFIFO) loadSprite(i int) {
+	ppu := f.ppu
+
+	oamIndex := f.lineSprites[i]
+	s := &ppu.oam.sprites[oamIndex]
+
+	height := byte(8)
+	tileNum := s.tileNum
+	if ppu.spriteSize {
+		height = 16
+		tileNum &^= 1
+	}
+
+	row := ppu.line + 16 - s.yPos
+	if s.yFlip {
+		row = height - 1 - row
+	}
+
+	var bank byte
+	if ppu.cgb && s.tileBank {
+		bank = 1
+	}
+
+	addr := 0x8000 + uint16(tileNum)<<4 + uint16(row)*2
+	lo := ppu.mmu.ReadVRAM(bank, addr)
+	hi := ppu.mmu.ReadVRAM(bank, addr+1)
+
+	palette := s.paletteNum
+	if !ppu.cgb {
+		palette = 0
+		if s.paletteFlag {
+			palette = 1
+		}
+	}
+
+	// Sprites partially off the left edge of the screen lose their leftmost pixels
+	first := byte(0)
+	if s.xPos < 8 {
+		first = 8 - s.xPos
+	}
+
+	for x := first; x < 8; x++ {
+		p := fifoPixel{
+			val:      tileRowVal(lo, hi, x, s.xFlip),
+			palette:  palette,
+			priority: s.priority,
+			oamIndex: oamIndex,
+		}
+
+		j := int(x - first)
+		if j >= f.sprites.length {
+			f.sprites.push(p)
+			continue
+		}
+
+		// Sprites already in the FIFO keep their pixels, unless transparent.
+		// In CGB mode, a sprite earlier in OAM takes priority instead.
+		existing := f.sprites.at(j)
+		if existing.val == 0 || (ppu.cgb && p.val != 0 && p.oamIndex < existing.oamIndex) {
+			*existing = p
+		}
+	}
+}
+
+// drawPixel pops a pixel from the FIFOs, mixes the background and sprite pixels, and draws it
+func (f *pixelFIFO) drawPixel() {
+	ppu := f.ppu
+
+	bg := f.bg.pop()
+	if f.discard > 0 {
+		f.discard--
+		return
+	}
+
+	var pixel color.RGBA
+	switch {
+	case ppu.cgb:
+		pixel = ppu.bgColorPalette.color(bg.palette, bg.val)
+	case ppu.bgEnable:
+		pixel = ppu.bgColor(bg.val)
+	default:
+		// The background and window are blank when disabled
+		bg.val = 0
+		pixel = ppu.palette.BG[0]
+	}
+
+	if f.sprites.length > 0 {
+		sp := f.sprites.pop()
+		if sp.val != 0 && ppu.spriteEnable && ppu.spriteVisible(sp.priority, bg.val, bg.priority) {
+			switch {
+			case ppu.cgb:
+				pixel = ppu.spriteColorPalette.color(sp.palette, sp.val)
+			default:
+				pixel = ppu.spriteColor(sp.palette == 1, sp.val)
+			}
+		}
+	}
+
+	ppu.writePixel(pixel, f.x, int(ppu.line))
+
+	f.x++
+	if f.x == ScreenWidth {
+		f.done = true
+		ppu.finishWindowLine(f.inWindow)
+	}
+}
+
+// tileRowVal returns the 2 bit value of pixel x in a row of tile data
+func tileRowVal(lo byte, hi byte, x byte, xFlip bool) byte {
+	bit := 7 - x
+	if xFlip {
+		bit = x
+	}
+
+	return (lo>>bit)&1 | ((hi>>bit)&1)<<1
+}
+
+func (f *pixelFIFO) SaveState(w *state.Writer) {
+	w.Int(f.x)
+	w.Int(f.discard)
+	w.Int(f.delay)
+	w.Bool(f.done)
+
+	for _, q := range []*pixelQueue{&f.bg, &f.sprites} {
+		w.Int(q.length)
+		for i := 0; i < q.length; i++ {
+			p := q.at(i)
+			w.U8(p.val)
+			w.U8(p.palette)
+			w.Bool(p.priority)
+			w.U8(p.oamIndex)
+		}
+	}
+
+	w.Int(f.fetchStep)
+	w.U16(f.fetchX)
+	w.Bool(f.inWindow)
+	w.U16(f.tileAddr)
+	w.U8(f.attributes)
+	w.U8(f.tileLo)
+	w.U8(f.tileHi)
+
+	w.Bytes(f.lineSprites[:])
+	w.Int(f.spriteCount)
+	for _, done := range f.spriteDone {
+		w.Bool(done)
+	}
+	w.Int(f.spriteFetch)
+	w.Int(f.spriteActive)
+}
+
+func (f *pixelFIFO) LoadState(r *state.Reader) {
+	f.x = r.Int()
+	f.discard = r.Int()
+	f.delay = r.Int()
+	f.done = r.Bool()
+
+	for _, q := range []*pixelQueue{&f.bg, &f.sprites} {
+		q.clear()
+		length := r.Int()
+		if length < 0 || length > len(q.pixels) {
+			r.Fail(fmt.Errorf("invalid pixel FIFO length %d", length))
+			return
+		}
+		for i := 0; i < length; i++ {
+			q.push(fifoPixel{val: r.U8(), palette: r.U8(), priority: r.Bool(), oamIndex: r.U8()})
+		}
+	}
+
+	f.fetchStep = r.Int()
+	f.fetchX = r.U16()
+	f.inWindow = r.Bool()
+	f.tileAddr = r.U16()
+	f.attributes = r.U8()
+	f.tileLo = r.U8()
+	f.tileHi = r.U8()
+
+	r.Bytes(f.lineSprites[:])
+	f.spriteCount = r.Int()
+	for i := range f.spriteDone {
+		f.spriteDone[i] = r.Bool()
+	}
+	f.spriteFetch = r.Int()
+	f.spriteActive = r.Int()
+
+	if f.spriteCount < 0 || f.spriteCount > fifoMaxSprites {
+		r.Fail(fmt.Errorf("invalid pixel FIFO sprite count %d", f.spriteCount))
+		return
+	}
+	for _, oamIndex := range f.lineSprites[:f.spriteCount] {
+		if int(oamIndex) >= len(f.ppu.oam.sprites) {
+			r.Fail(fmt.Errorf("invalid pixel FIFO sprite OAM index %d", oamIndex))
+			return
+		}
+	}
+	if f.spriteActive < 0 || f.spriteActive >= fifoMaxSprites {
+		r.Fail(fmt.Errorf("invalid pixel FIFO active sprite %d", f.spriteActive))
+	}
+}
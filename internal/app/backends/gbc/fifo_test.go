@@ -0,0 +1,231 @@
+package gbc
+
+import (
+	"image/color"
+	"testing"
+
+	"github.com/omstrumpf/goemu/internal/app/backends/gbc/state"
+)
+
+// newFIFOTestPPU constructs a PPU with a busy scene: a background of varied tiles,
+// a window, and overlapping sprites with flips and priorities
+func newFIFOTestPPU() (*MMU, *PPU) {
+	mmu := NewMMU(nil)
+	ppu := NewPPU(mmu)
+	mmu.ppu = ppu
+
+	mmu.Write(0xFF40, 0xF3) // LCD, window map 1, window, tileset 1, sprites, BG
+	mmu.Write(0xFF47, 0xE4)
+	mmu.Write(0xFF48, 0xD2)
+	mmu.Write(0xFF49, 0x1B)
+
+	// Tiles with distinct pixels in every column
+	for tile := uint16(0); tile < 8; tile++ {
+		for row := uint16(0); row < 8; row++ {
+			mmu.Write(0x8000+tile*16+row*2, byte(0x35*(tile+1)+row*0x11))
+			mmu.Write(0x8000+tile*16+row*2+1, byte(0x5A*(tile+3)^row*0x07))
+		}
+	}
+	for i := uint16(0); i < 0x400; i++ {
+		mmu.Write(0x9800+i, byte(i*3%8))
+		mmu.Write(0x9C00+i, byte(i*5%8))
+	}
+
+	sprites := []struct{ y, x, tile, flags byte }{
+		{16, 8, 1, 0x00},
+		{18, 12, 2, 0x20},
+		{20, 12, 3, 0x80}, // Behind the background, overlapping the sprite above
+		{16, 3, 4, 0x50},  // Partially offscreen
+		{24, 100, 5, 0x60},
+		{22, 160, 6, 0x10},
+	}
+	for i, s := range sprites {
+		ppu.oam.Write(uint16(i*4), s.y)
+		ppu.oam.Write(uint16(i*4+1), s.x)
+		ppu.oam.Write(uint16(i*4+2), s.tile)
+		ppu.oam.Write(uint16(i*4+3), s.flags)
+	}
+
+	return mmu, ppu
+}
+
+// renderFIFOLine renders the current line with the FIFO renderer, and returns the length of mode 3 in dots
+func renderFIFOLine(ppu *PPU) int {
+	ppu.fifo.startLine()
+
+	dots := 1
+	for !ppu.fifo.RunForDots(1) {
+		dots++
+	}
+	return dots
+}
+
+func TestFIFOMatchesScanline(t *testing.T) {
+	_, ppu := newFIFOTestPPU()
+	testFIFOMatchesScanline(t, ppu)
+}
+
+func TestFIFOMatchesScanlineCGB(t *testing.T) {
+	mmu, ppu := newFIFOTestPPU()
+	mmu.cgb = true
+	ppu.cgb = true
+
+	for i := 0; i < 64; i++ {
+		ppu.bgColorPalette.data[i] = byte(i * 37)
+		ppu.spriteColorPalette.data[i] = byte(i * 91)
+	}
+
+	// Map attributes with every palette, bank, flip, and priority combination
+	mmu.Write(0xFF4F, 0x01)
+	for i := uint16(0); i < 0x10; i++ {
+		mmu.Write(0x8000+i, 0xA5)
+	}
+	for i := uint16(0); i < 0x800; i++ {
+		mmu.Write(0x9800+i, byte(i*7)&0xEF)
+	}
+	mmu.Write(0xFF4F, 0x00)
+
+	for i := uint16(0); i < 6; i++ {
+		ppu.oam.Write(i*4+3, ppu.oam.Read(i*4+3)|byte(i*3)&0x0F)
+	}
+
+	testFIFOMatchesScanline(t, ppu)
+}
+
+func testFIFOMatchesScanline(t *testing.T, ppu *PPU) {
+	for _, scroll := range []struct{ x, y, wx, wy byte }{{0, 0, 167, 0}, {5, 3, 87, 20}, {13, 250, 7, 24}, {250, 100, 40, 0}} {
+		ppu.bgScrollX = scroll.x
+		ppu.bgScrollY = scroll.y
+		ppu.wScrollXm7 = scroll.wx
+		ppu.wScrollY = scroll.wy
+		ppu.resetWindow()
+
+		var expected [ScreenWidth]color.RGBA
+		for line := byte(0); line < 32; line++ {
+			ppu.line = line
+
+			// Both renderers draw the same line, so they start from the same window line counter
+			windowLine, windowY := ppu.windowLine, ppu.windowY
+			ppu.renderLine()
+			copy(expected[:], ppu.framebuffer[int(line)*ScreenWidth:])
+
+			ppu.windowLine, ppu.windowY = windowLine, windowY
+			renderFIFOLine(ppu)
+			for x := 0; x < ScreenWidth; x++ {
+				if got := ppu.framebuffer[int(line)*ScreenWidth+x]; got != expected[x] {
+					t.Fatalf("Scroll %v: expected pixel (%d, %d) to be %v, got %v", scroll, x, line, expected[x], got)
+				}
+			}
+		}
+	}
+}
+
+func TestFIFOMode3Length(t *testing.T) {
+	mmu, ppu := newFIFOTestPPU()
+	mmu.Write(0xFF40, 0x91) // No window or sprites
+
+	ppu.line = 40
+	if dots := renderFIFOLine(ppu); dots != 172 {
+		t.Errorf("Expected mode 3 to last 172 dots, got %d", dots)
+	}
+
+	ppu.bgScrollX = 5
+	if dots := renderFIFOLine(ppu); dots != 177 {
+		t.Errorf("Expected fine scroll to extend mode 3 to 177 dots, got %d", dots)
+	}
+	ppu.bgScrollX = 0
+
+	// Sprites stall the background
+	mmu.Write(0xFF40, 0x93)
+	ppu.line = 8
+	if dots := renderFIFOLine(ppu); dots <= 172 {
+		t.Errorf("Expected sprites to extend mode 3, got %d dots", dots)
+	}
+
+	// The window restarts the background fetcher
+	mmu.Write(0xFF40, 0xB1)
+	ppu.line = 40
+	ppu.wScrollXm7 = 87
+	ppu.wScrollY = 40
+	if dots := renderFIFOLine(ppu); dots <= 172 {
+		t.Errorf("Expected the window to extend mode 3, got %d dots", dots)
+	}
+}
+
+func TestFIFOMidLineWrites(t *testing.T) {
+	mmu, ppu := newFIFOTestPPU()
+	mmu.Write(0xFF40, 0x91) // No window or sprites
+	ppu.renderer = RendererFIFO
+
+	// Solid color 3 background
+	for i := uint16(0); i < 16; i++ {
+		mmu.Write(0x8000+i, 0xFF)
+	}
+	for i := uint16(0); i < 0x400; i++ {
+		mmu.Write(0x9800+i, 0x00)
+	}
+
+	ppu.line = 0
+	ppu.mode = 2
+	ppu.timeInMode = 0
+	ppu.RunForClocks(21 + 20) // Partway through mode 3
+	mmu.Write(0xFF47, 0x00)   // Color 3 becomes white
+	ppu.RunForClocks(23)
+
+	if ppu.mode != 0 {
+		t.Fatalf("Expected mode 3 to be complete, in mode %d", ppu.mode)
+	}
+	if ppu.framebuffer[0] != ppu.palette.BG[3] {
+		t.Errorf("Expected the start of the line to use the old palette, got %v", ppu.framebuffer[0])
+	}
+	if ppu.framebuffer[ScreenWidth-1] != ppu.palette.BG[0] {
+		t.Errorf("Expected the end of the line to use the new palette, got %v", ppu.framebuffer[ScreenWidth-1])
+	}
+
+	// Mode 3 was 43 clocks, so HBLANK fills the rest of the line
+	if ppu.hblankTime != 50 {
+		t.Errorf("Expected HBLANK to last 50 clocks, got %d", ppu.hblankTime)
+	}
+
+	// A longer mode 3 gives a shorter HBLANK
+	mmu.Write(0xFF43, 0x07)
+	ppu.RunForClocks(50 + 21 + 45)
+	if ppu.mode != 0 || ppu.hblankTime != 48 {
+		t.Errorf("Expected HBLANK to shorten to 48 clocks, got mode %d with %d clocks", ppu.mode, ppu.hblankTime)
+	}
+}
+
+func TestFIFOLoadStateRejectsInvalid(t *testing.T) {
+	corruptions := map[string]func(f *pixelFIFO){
+		"queue length": func(f *pixelFIFO) { f.sprites.length = 17 },
+		"sprite count": func(f *pixelFIFO) { f.spriteCount = fifoMaxSprites + 1 },
+		"OAM index": func(f *pixelFIFO) {
+			f.spriteCount = 1
+			f.lineSprites[0] = 40
+		},
+		"active sprite": func(f *pixelFIFO) { f.spriteActive = -1 },
+	}
+
+	for name, corrupt := range corruptions {
+		_, ppu := newFIFOTestPPU()
+		ppu.line = 0
+		renderFIFOLine(ppu)
+
+		w := state.NewWriter()
+		ppu.fifo.SaveState(w)
+		r := state.NewReader(w.Data())
+		ppu.fifo.LoadState(r)
+		if err := r.Err(); err != nil {
+			t.Fatalf("Expected a valid FIFO state to load, got %v", err)
+		}
+
+		corrupt(&ppu.fifo)
+		w = state.NewWriter()
+		ppu.fifo.SaveState(w)
+		r = state.NewReader(w.Data())
+		ppu.fifo.LoadState(r)
+		if r.Err() == nil {
+			t.Errorf("Expected a FIFO state with an invalid %s to fail to load", name)
+		}
+	}
+}
@@ -1,6 +1,7 @@
 package gbc
 
 import (
+	"errors"
 	"fmt"
 	"image/color"
 	"os"
@@ -8,9 +9,12 @@ import (
 	"time"
 
 	"github.com/omstrumpf/goemu/internal/app/backends/gbc/audio"
+	"github.com/omstrumpf/goemu/internal/app/backends/gbc/banking"
+	"github.com/omstrumpf/goemu/internal/app/backends/gbc/bios"
 	"github.com/omstrumpf/goemu/internal/app/backends/gbc/cartridge"
 	"github.com/omstrumpf/goemu/internal/app/backends/gbc/constants"
 	"github.com/omstrumpf/goemu/internal/app/backends/gbc/interrupts"
+	"github.com/omstrumpf/goemu/internal/app/backends/gbc/state"
 	"github.com/omstrumpf/goemu/internal/app/console"
 	console_audio "github.com/omstrumpf/goemu/internal/app/console/audio"
 	"github.com/omstrumpf/goemu/internal/app/log"
@@ -31,23 +35,53 @@ const (
 
 	// ConsoleName is the name of this console
 	ConsoleName = "GameBoy"
+
+	// StateMagic identifies a GBC save state
+	StateMagic = "GOEMU-GBC-STATE"
+
+	// StateVersion is the current save state format version. Increment whenever the serialized layout changes.
+	StateVersion = 10
 )
 
+// Config holds the options for constructing a GBC
+type Config struct {
+	SkipLogo    bool     // Start at the cartridge entry point in the post-boot state, instead of running the boot ROM
+	SpeedFactor float64  // Initial emulation speed relative to real time, which the audio output is resampled for
+	Model       Model    // Hardware model. ModelAuto selects the model from the boot ROM, or else the cartridge header.
+	BootROM     []byte   // Boot ROM image. If nil, the built-in DMG boot ROM is used.
+	ForceDMG    bool     // Run CGB cartridges without CGB features, as on a DMG
+	Renderer    Renderer // How the PPU draws the screen
+	Palette     Palette  // Display colors for DMG games. Defaults to PaletteGray.
+
+	AudioSynthesis audio.Synthesis // How the APU turns channel output into samples
+	SampleRate     int             // Audio samples per second with band-limited synthesis. Defaults to audio.DefaultSampleRate.
+}
+
 // GBC is the toplevel struct containing all the gameboy systems
 type GBC struct {
-	mmu   *MMU
-	cpu   *CPU
-	cart  *cartridge.CART
-	ppu   *PPU
-	apu   *audio.APU
-	timer *Timer
+	mmu    *MMU
+	cpu    *CPU
+	cart   *cartridge.CART
+	ppu    *PPU
+	apu    *audio.APU
+	timer  *Timer
+	serial *Serial
+
+	model Model
+	cgb   bool // Running in CGB mode
+
+	palettes     []Palette // Display palettes to cycle through, starting with the configured palette
+	paletteIndex int
 
 	totalClocks uint64
 	extraClocks int // Extra clocks emulated in the last frame
+	halfClock   int // CPU clocks not yet passed to the normal speed components, in double speed mode
+
+	stateSize int // Size of the last save state, to preallocate the next one
 }
 
 // NewGBC constructs a valid GBC struct
-func NewGBC(skiplogo bool, speedfactor float64, rom []byte, ram []byte) *GBC {
+func NewGBC(config Config, rom []byte, ram []byte) *GBC {
 	gbc := new(GBC)
 
 	gbc.cart = cartridge.NewCart(rom)
@@ -60,13 +94,61 @@ func NewGBC(skiplogo bool, speedfactor float64, rom []byte, ram []byte) *GBC {
 
 	gbc.mmu = NewMMU(gbc.cart.BankController)
 	gbc.timer = NewTimer(gbc.mmu)
+	gbc.serial = NewSerial(gbc.mmu)
 	gbc.cpu = NewCPU(gbc.mmu)
 	gbc.ppu = NewPPU(gbc.mmu)
-	gbc.apu = audio.NewAPU(speedfactor)
+	gbc.apu = audio.NewAPU()
+	gbc.apu.SetSynthesis(config.AudioSynthesis, config.SampleRate)
+	gbc.apu.SetSpeedFactor(config.SpeedFactor)
+
+	gbc.ppu.renderer = config.Renderer
+
+	gbc.palettes = palettesFor(config.Palette, rom)
+	gbc.ppu.palette = gbc.palettes[0]
 
 	gbc.mmu.ppu = gbc.ppu
 	gbc.mmu.apu = gbc.apu
 	gbc.mmu.timer = gbc.timer
+	gbc.mmu.serial = gbc.serial
+
+	bootROM := config.BootROM
+	if err := bios.Validate(bootROM); len(bootROM) > 0 && err != nil {
+		log.Errorf("Ignoring boot ROM: %v", err)
+		bootROM = nil
+	}
+
+	cgbCart := gbc.cart.Mode()&cartridge.CGB != 0
+
+	gbc.model = config.Model
+	if gbc.model == ModelAuto {
+		switch {
+		case len(bootROM) == bios.CGBLength:
+			gbc.model = ModelCGB
+		case len(bootROM) == 0 && cgbCart && !config.ForceDMG:
+			gbc.model = ModelCGB
+		default:
+			gbc.model = ModelDMG
+		}
+	}
+
+	gbc.cgb = gbc.model == ModelCGB && cgbCart && !config.ForceDMG
+	gbc.mmu.cgb = gbc.cgb
+	gbc.ppu.cgb = gbc.cgb
+
+	if gbc.cart.Mode() == cartridge.CGB && !gbc.cgb {
+		log.Warningf("Cartridge requires CGB mode, but is running in DMG mode")
+	}
+
+	log.Debugf("Emulating model %s (CGB mode: %t)", gbc.model, gbc.cgb)
+
+	skiplogo := config.SkipLogo
+
+	if len(bootROM) > 0 {
+		gbc.mmu.SetBios(bootROM)
+	} else if gbc.model != ModelDMG && !skiplogo {
+		log.Warningf("No boot ROM provided for model %s. Skipping boot sequence.", gbc.model)
+		skiplogo = true
+	}
 
 	if skiplogo {
 		gbc.skipLogo()
@@ -75,49 +157,95 @@ func NewGBC(skiplogo bool, speedfactor float64, rom []byte, ram []byte) *GBC {
 	return gbc
 }
 
-// Set the gameboy to the correct post-boot state
+// palettesFor returns the display palettes to cycle through, starting with the configured palette.
+// The auto palette is resolved from the cartridge, and is cycled along with a custom palette and the presets.
+func palettesFor(configured Palette, rom []byte) []Palette {
+	switch configured.Name {
+	case "":
+		configured = PaletteGray
+	case PaletteAuto:
+		configured = autoPalette(rom)
+	}
+
+	palettes := []Palette{configured}
+	for _, p := range palettePresets {
+		if p.Name != configured.Name {
+			palettes = append(palettes, p)
+		}
+	}
+	if configured.Name != PaletteAuto {
+		palettes = append(palettes, autoPalette(rom))
+	}
+
+	return palettes
+}
+
+// Set the gameboy to the correct post-boot state for the model
 func (gbc *GBC) skipLogo() {
 	log.Debugf("Skipping logo boot sequence")
+
+	s := postBoot(gbc.model, gbc.cart.Read(0x014D), gbc.cart.Mode()&cartridge.CGB != 0)
+
 	gbc.cpu.PC.Set(0x0100)
-	gbc.cpu.AF.Set(0x01B0)
-	gbc.cpu.BC.Set(0x0013)
-	gbc.cpu.DE.Set(0x00D8)
-	gbc.cpu.HL.Set(0x014D)
+	gbc.cpu.AF.Set(s.af)
+	gbc.cpu.BC.Set(s.bc)
+	gbc.cpu.DE.Set(s.de)
+	gbc.cpu.HL.Set(s.hl)
 	gbc.cpu.SP.Set(0xFFFE)
-	gbc.mmu.Write(0xFF05, 0x00) // TIMA
-	gbc.mmu.Write(0xFF06, 0x00) // TMA
-	gbc.mmu.Write(0xFF07, 0x00) // TAC
-	gbc.mmu.Write(0xFF10, 0x80) // NR10
-	gbc.mmu.Write(0xFF11, 0xBF) // NR11
-	gbc.mmu.Write(0xFF12, 0xF3) // NR12
-	gbc.mmu.Write(0xFF14, 0xBF) // NR14
-	gbc.mmu.Write(0xFF16, 0x3F) // NR21
-	gbc.mmu.Write(0xFF17, 0x00) // NR22
-	gbc.mmu.Write(0xFF19, 0xBF) // NR24
-	gbc.mmu.Write(0xFF1A, 0x7F) // NR30
-	gbc.mmu.Write(0xFF1B, 0xFF) // NR31
-	gbc.mmu.Write(0xFF1C, 0x9F) // NR32
-	gbc.mmu.Write(0xFF1E, 0xBF) // NR33
-	gbc.mmu.Write(0xFF20, 0xFF) // NR41
-	gbc.mmu.Write(0xFF21, 0x00) // NR42
-	gbc.mmu.Write(0xFF22, 0x00) // NR43
-	gbc.mmu.Write(0xFF23, 0xBF) // NR30
-	gbc.mmu.Write(0xFF24, 0x77) // NR50
-	gbc.mmu.Write(0xFF25, 0xF3) // NR51
-	gbc.mmu.Write(0xFF26, 0xF1) // NR52
-	gbc.mmu.Write(0xFF40, 0x91) // LCDC
-	gbc.mmu.Write(0xFF42, 0x00) // SCY
-	gbc.mmu.Write(0xFF43, 0x00) // SCX
-	gbc.mmu.Write(0xFF45, 0x00) // LYC
-	gbc.mmu.Write(0xFF47, 0xFC) // BGP
-	gbc.mmu.Write(0xFF48, 0xFF) // OBP0
-	gbc.mmu.Write(0xFF49, 0xFF) // OBP1
-	gbc.mmu.Write(0xFF4A, 0x00) // WY
-	gbc.mmu.Write(0xFF4B, 0x00) // WX
-	gbc.mmu.Write(0xFFFF, 0x00) // IE
+	gbc.mmu.Write(0xFF05, 0x00)   // TIMA
+	gbc.mmu.Write(0xFF06, 0x00)   // TMA
+	gbc.mmu.Write(0xFF07, 0x00)   // TAC
+	gbc.mmu.Write(0xFF10, 0x80)   // NR10
+	gbc.mmu.Write(0xFF11, 0xBF)   // NR11
+	gbc.mmu.Write(0xFF12, 0xF3)   // NR12
+	gbc.mmu.Write(0xFF14, 0xBF)   // NR14
+	gbc.mmu.Write(0xFF16, 0x3F)   // NR21
+	gbc.mmu.Write(0xFF17, 0x00)   // NR22
+	gbc.mmu.Write(0xFF19, 0xBF)   // NR24
+	gbc.mmu.Write(0xFF1A, 0x7F)   // NR30
+	gbc.mmu.Write(0xFF1B, 0xFF)   // NR31
+	gbc.mmu.Write(0xFF1C, 0x9F)   // NR32
+	gbc.mmu.Write(0xFF1E, 0xBF)   // NR33
+	gbc.mmu.Write(0xFF20, 0xFF)   // NR41
+	gbc.mmu.Write(0xFF21, 0x00)   // NR42
+	gbc.mmu.Write(0xFF22, 0x00)   // NR43
+	gbc.mmu.Write(0xFF23, 0xBF)   // NR44
+	gbc.mmu.Write(0xFF24, 0x77)   // NR50
+	gbc.mmu.Write(0xFF25, 0xF3)   // NR51
+	gbc.mmu.Write(0xFF26, s.nr52) // NR52
+	gbc.mmu.Write(0xFF40, 0x91)   // LCDC
+	gbc.mmu.Write(0xFF42, 0x00)   // SCY
+	gbc.mmu.Write(0xFF43, 0x00)   // SCX
+	gbc.mmu.Write(0xFF45, 0x00)   // LYC
+	gbc.mmu.Write(0xFF47, 0xFC)   // BGP
+	gbc.mmu.Write(0xFF48, 0xFF)   // OBP0
+	gbc.mmu.Write(0xFF49, 0xFF)   // OBP1
+	gbc.mmu.Write(0xFF4A, 0x00)   // WY
+	gbc.mmu.Write(0xFF4B, 0x00)   // WX
+	gbc.mmu.Write(0xFFFF, 0x00)   // IE
+	if gbc.cgb {
+		// The CGB boot ROM initializes all background colors to white
+		gbc.mmu.Write(0xFF68, 0x80) // BCPS, auto-increment
+		for i := 0; i < 64; i++ {
+			gbc.mmu.Write(0xFF69, 0xFF) // BCPD
+		}
+	}
+	if s.setDiv {
+		gbc.timer.div = s.div // DIV
+	}
 	gbc.mmu.DisableBios()
 }
 
+// Model returns the hardware model being emulated
+func (gbc *GBC) Model() Model {
+	return gbc.model
+}
+
+// CGBMode returns whether the gameboy is running in CGB mode
+func (gbc *GBC) CGBMode() bool {
+	return gbc.cgb
+}
+
 // Tick runs the gameboy for a single frame-time
 func (gbc *GBC) Tick() {
 	clocks := gbc.extraClocks
@@ -128,17 +256,37 @@ func (gbc *GBC) Tick() {
 		}
 
 		c := gbc.cpu.ProcessNextInstruction()
-		clocks += c
-		gbc.totalClocks += uint64(c)
-		gbc.ppu.RunForClocks(c)
-		gbc.apu.RunForClocks(c)
+		c += gbc.mmu.hdma.TakeStall()
+
+		// The timer, serial port, and OAM DMA are clocked by the CPU
+		gbc.mmu.dma.RunForClocks(c)
 		gbc.timer.RunForClocks(c)
-		gbc.cart.BankController.RunForClocks(c)
+		gbc.serial.RunForClocks(c)
+
+		// Everything else runs at normal speed, regardless of the CPU speed
+		n := gbc.normalSpeedClocks(c)
+		clocks += n
+		gbc.totalClocks += uint64(n)
+		gbc.ppu.RunForClocks(n)
+		gbc.apu.RunForClocks(n)
+		gbc.cart.BankController.RunForClocks(n)
 	}
 
 	gbc.extraClocks = clocks - CyclesPerFrame
 }
 
+// normalSpeedClocks converts CPU clocks to normal speed clocks.
+// In double speed mode, every two CPU clocks are one normal speed clock.
+func (gbc *GBC) normalSpeedClocks(cpuClocks int) int {
+	if !gbc.mmu.doubleSpeed {
+		return cpuClocks
+	}
+
+	cpuClocks += gbc.halfClock
+	gbc.halfClock = cpuClocks & 1
+	return cpuClocks >> 1
+}
+
 // PressButton presses the given button
 func (gbc *GBC) PressButton(b console.Button) {
 	gbc.mmu.inputs.PressButton(b)
@@ -150,6 +298,32 @@ func (gbc *GBC) ReleaseButton(b console.Button) {
 	gbc.mmu.inputs.ReleaseButton(b)
 }
 
+// AttachSerial connects an endpoint to the gameboy's serial port. A nil endpoint disconnects the cable.
+func (gbc *GBC) AttachSerial(endpoint SerialEndpoint) {
+	gbc.serial.Attach(endpoint)
+}
+
+// NextPalette switches to the next display palette for DMG games, and returns its name
+func (gbc *GBC) NextPalette() string {
+	gbc.paletteIndex = (gbc.paletteIndex + 1) % len(gbc.palettes)
+	gbc.ppu.palette = gbc.palettes[gbc.paletteIndex]
+
+	log.Debugf("Switched to palette %s", gbc.ppu.palette.Name)
+
+	return gbc.ppu.palette.Name
+}
+
+// SetSpeedFactor sets the emulation speed relative to real time, which the audio output is resampled for
+// so that it plays at the same pitch. 0 or less is for emulation that is not paced to real time.
+func (gbc *GBC) SetSpeedFactor(speedFactor float64) {
+	gbc.apu.SetSpeedFactor(speedFactor)
+}
+
+// SpeedFactor returns the emulation speed the audio output is resampled for
+func (gbc *GBC) SpeedFactor() float64 {
+	return gbc.apu.SpeedFactor()
+}
+
 // IsStopped returns true if the gameboy is not running
 func (gbc *GBC) IsStopped() bool {
 	return gbc.cpu.IsStopped()
@@ -167,7 +341,7 @@ func (gbc *GBC) GetAudioChannel() *chan console_audio.ChanneledSample {
 
 // GetAudioBitrate returns the gameboy's audio bitrate
 func (gbc *GBC) GetAudioBitrate() int {
-	return audio.Bitrate
+	return gbc.apu.SampleRate()
 }
 
 // GetFrameTime returns the real-time duration of a single frame
@@ -195,11 +369,87 @@ func (gbc *GBC) GetGameName() string {
 	return strings.ToValidUTF8(gbc.cart.Title(), "")
 }
 
-// GetRAMSave
+// Rumbling returns true if the cartridge has a rumble motor, and it is currently running
+func (gbc *GBC) Rumbling() bool {
+	if r, ok := gbc.cart.BankController.(banking.Rumbler); ok {
+		return r.Rumbling()
+	}
+	return false
+}
+
+// GetRAMSave returns the cartridge RAM contents, for persisting battery-backed saves
 func (gbc *GBC) GetRAMSave() []byte {
 	return gbc.cart.BankController.GetRamSave()
 }
 
+// SaveState serializes the entire machine state
+func (gbc *GBC) SaveState() []byte {
+	w := state.NewWriterSize(gbc.stateSize)
+
+	w.String(StateMagic)
+	w.U16(StateVersion)
+	w.String(gbc.cart.Title())
+
+	w.U64(gbc.totalClocks)
+	w.Int(gbc.extraClocks)
+	w.Int(gbc.halfClock)
+
+	gbc.cpu.SaveState(w)
+	gbc.mmu.SaveState(w)
+	gbc.ppu.SaveState(w)
+	gbc.apu.SaveState(w)
+	gbc.timer.SaveState(w)
+	gbc.serial.SaveState(w)
+	gbc.cart.BankController.SaveState(w)
+
+	gbc.stateSize = len(w.Data())
+
+	return w.Data()
+}
+
+// LoadState restores the machine state from data produced by SaveState.
+// If the data is invalid, the machine state is left unchanged and an error is returned.
+func (gbc *GBC) LoadState(data []byte) error {
+	r := state.NewReader(data)
+
+	if magic := r.String(); magic != StateMagic {
+		if r.Err() != nil {
+			return r.Err()
+		}
+		return errors.New("data is not a GBC save state")
+	}
+	if version := r.U16(); version != StateVersion {
+		return fmt.Errorf("unsupported save state version %d (expected %d)", version, StateVersion)
+	}
+	if title := r.String(); title != gbc.cart.Title() {
+		return fmt.Errorf("save state is for a different game: %q", strings.TrimRight(title, "\x00"))
+	}
+
+	backup := gbc.SaveState()
+
+	gbc.totalClocks = r.U64()
+	gbc.extraClocks = r.Int()
+	gbc.halfClock = r.Int()
+
+	gbc.cpu.LoadState(r)
+	gbc.mmu.LoadState(r)
+	gbc.ppu.LoadState(r)
+	gbc.apu.LoadState(r)
+	gbc.timer.LoadState(r)
+	gbc.serial.LoadState(r)
+	gbc.cart.BankController.LoadState(r)
+
+	if err := r.Err(); err != nil {
+		log.Errorf("Failed to load save state, restoring previous state: %v", err)
+		if restoreErr := gbc.LoadState(backup); restoreErr != nil {
+			log.Errorf("Failed to restore previous state: %v", restoreErr)
+		}
+		return err
+	}
+
+	return nil
+}
+
 // traceString produces a string of the current GBC trace, for debugging
 func (gbc *GBC) traceString() string {
 	pc := gbc.cpu.PC.HiLo()
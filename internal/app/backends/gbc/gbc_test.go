@@ -0,0 +1,245 @@
+package gbc
+
+import (
+	"image/color"
+	"testing"
+
+	"github.com/omstrumpf/goemu/internal/app/backends/gbc/bios"
+	"github.com/omstrumpf/goemu/internal/app/backends/gbc/cartridge"
+	"github.com/omstrumpf/goemu/internal/app/console"
+)
+
+// finalizeHeader writes the logo and checksums to a test ROM, so that it passes header validation
+func finalizeHeader(rom []byte) []byte {
+	// The boot ROM holds a copy of the logo at 0xA8
+	for i := 0; i < 0x30; i++ {
+		rom[0x0104+i] = bios.BIOS.Read(uint16(0xA8 + i))
+	}
+
+	cartridge.FixChecksums(rom)
+
+	return rom
+}
+
+func testROM() []byte {
+	rom := make([]byte, 0x8000)
+	copy(rom[0x0134:], "TESTROM")
+
+	// Infinite loop of increments at the entry point
+	copy(rom[0x0100:], []byte{
+		0x3C,       // INC A
+		0x04,       // INC B
+		0x18, 0xFC, // JR -4
+	})
+
+	return finalizeHeader(rom)
+}
+
+func TestGBCSaveStateRoundTrip(t *testing.T) {
+	gbc := NewGBC(Config{SkipLogo: true, SpeedFactor: 1}, testROM(), nil)
+
+	gbc.Tick()
+	gbc.Tick()
+
+	saved := gbc.SaveState()
+
+	gbc.Tick()
+	expectedAF := gbc.cpu.AF.HiLo()
+	expectedBC := gbc.cpu.BC.HiLo()
+	expectedPC := gbc.cpu.PC.HiLo()
+	expectedClocks := gbc.totalClocks
+	expectedLine := gbc.ppu.line
+
+	if err := gbc.LoadState(saved); err != nil {
+		t.Fatalf("Expected save state to load, got %v", err)
+	}
+
+	gbc.Tick()
+
+	if gbc.cpu.AF.HiLo() != expectedAF || gbc.cpu.BC.HiLo() != expectedBC || gbc.cpu.PC.HiLo() != expectedPC {
+		t.Errorf("Expected registers to match after replaying from save state. AF: %#04x/%#04x, BC: %#04x/%#04x, PC: %#04x/%#04x",
+			gbc.cpu.AF.HiLo(), expectedAF, gbc.cpu.BC.HiLo(), expectedBC, gbc.cpu.PC.HiLo(), expectedPC)
+	}
+	if gbc.totalClocks != expectedClocks {
+		t.Errorf("Expected total clocks to be %d after replaying from save state, got %d", expectedClocks, gbc.totalClocks)
+	}
+	if gbc.ppu.line != expectedLine {
+		t.Errorf("Expected PPU line to be %d after replaying from save state, got %d", expectedLine, gbc.ppu.line)
+	}
+}
+
+func TestGBCLoadStateRejectsInvalid(t *testing.T) {
+	gbc := NewGBC(Config{SkipLogo: true, SpeedFactor: 1}, testROM(), nil)
+	gbc.Tick()
+
+	saved := gbc.SaveState()
+	pc := gbc.cpu.PC.HiLo()
+
+	if err := gbc.LoadState([]byte("garbage")); err == nil {
+		t.Errorf("Expected loading garbage data to fail")
+	}
+
+	if err := gbc.LoadState(saved[:len(saved)/2]); err == nil {
+		t.Errorf("Expected loading truncated data to fail")
+	}
+	if gbc.cpu.PC.HiLo() != pc {
+		t.Errorf("Expected failed load to leave machine state unchanged. PC: %#04x, expected %#04x", gbc.cpu.PC.HiLo(), pc)
+	}
+
+	other := testROM()
+	copy(other[0x0134:], "OTHERROM")
+	finalizeHeader(other)
+	if err := NewGBC(Config{SkipLogo: true, SpeedFactor: 1}, other, nil).LoadState(saved); err == nil {
+		t.Errorf("Expected loading a save state from another game to fail")
+	}
+}
+
+func TestGBCPostBootModels(t *testing.T) {
+	cases := []struct {
+		model          Model
+		af, bc, de, hl uint16
+	}{
+		{ModelDMG, 0x01B0, 0x0013, 0x00D8, 0x014D},
+		{ModelMGB, 0xFFB0, 0x0013, 0x00D8, 0x014D},
+		{ModelSGB, 0x0100, 0x0014, 0x0000, 0xC060},
+		{ModelCGB, 0x1180, 0x0000, 0x0008, 0x007C}, // DMG cartridge in compatibility mode
+	}
+
+	for _, c := range cases {
+		gbc := NewGBC(Config{SkipLogo: true, SpeedFactor: 1, Model: c.model}, testROM(), nil)
+
+		if gbc.Model() != c.model {
+			t.Errorf("Expected model %s, got %s", c.model, gbc.Model())
+		}
+
+		regs := [4]uint16{gbc.cpu.AF.HiLo(), gbc.cpu.BC.HiLo(), gbc.cpu.DE.HiLo(), gbc.cpu.HL.HiLo()}
+		if regs != [4]uint16{c.af, c.bc, c.de, c.hl} {
+			t.Errorf("%s: unexpected post-boot registers AF=%#04x BC=%#04x DE=%#04x HL=%#04x", c.model, regs[0], regs[1], regs[2], regs[3])
+		}
+	}
+
+	cgbROM := testROM()
+	cgbROM[0x0143] = 0x80
+	gbc := NewGBC(Config{SkipLogo: true, SpeedFactor: 1, Model: ModelCGB}, finalizeHeader(cgbROM), nil)
+	if gbc.cpu.DE.HiLo() != 0xFF56 || gbc.cpu.HL.HiLo() != 0x000D {
+		t.Errorf("Expected CGB mode post-boot registers, got DE=%#04x HL=%#04x", gbc.cpu.DE.HiLo(), gbc.cpu.HL.HiLo())
+	}
+}
+
+func TestGBCBootROM(t *testing.T) {
+	boot := make([]byte, bios.CGBLength)
+	for i := range boot {
+		boot[i] = 0xB0
+	}
+
+	gbc := NewGBC(Config{SpeedFactor: 1, BootROM: boot}, testROM(), nil)
+
+	if gbc.Model() != ModelCGB {
+		t.Errorf("Expected a CGB boot ROM to select the CGB model, got %s", gbc.Model())
+	}
+
+	if gbc.mmu.Read(0x0000) != 0xB0 || gbc.mmu.Read(0x08FF) != 0xB0 {
+		t.Errorf("Expected boot ROM to be mapped at 0x0000-0x00FF and 0x0200-0x08FF")
+	}
+	if gbc.mmu.Read(0x0100) != 0x3C || gbc.mmu.Read(0x0134) != 'T' {
+		t.Errorf("Expected cartridge header to be visible at 0x0100-0x01FF")
+	}
+	if gbc.mmu.Read(0x0900) != 0x00 {
+		t.Errorf("Expected cartridge ROM to be visible above 0x08FF")
+	}
+
+	gbc.mmu.Write(0xFF50, 0x01)
+	if gbc.mmu.Read(0x0000) != 0x00 || gbc.mmu.Read(0x0200) != 0x00 {
+		t.Errorf("Expected boot ROM to be unmapped after writing 0xFF50")
+	}
+
+	if NewGBC(Config{SpeedFactor: 1, BootROM: make([]byte, bios.DMGLength)}, testROM(), nil).Model() != ModelDMG {
+		t.Errorf("Expected a DMG boot ROM to select the DMG model")
+	}
+}
+
+func TestGBCCGBMode(t *testing.T) {
+	if gbc := NewGBC(Config{SkipLogo: true, SpeedFactor: 1}, testROM(), nil); gbc.Model() != ModelDMG || gbc.CGBMode() {
+		t.Errorf("Expected a DMG cartridge to run on a DMG, got %s (CGB mode: %t)", gbc.Model(), gbc.CGBMode())
+	}
+
+	cgbROM := testROM()
+	cgbROM[0x0143] = 0x80
+	finalizeHeader(cgbROM)
+
+	gbc := NewGBC(Config{SkipLogo: true, SpeedFactor: 1}, cgbROM, nil)
+	if gbc.Model() != ModelCGB || !gbc.CGBMode() {
+		t.Errorf("Expected a CGB cartridge to run in CGB mode, got %s (CGB mode: %t)", gbc.Model(), gbc.CGBMode())
+	}
+	if c := gbc.ppu.bgColorPalette.color(7, 3); c != (color.RGBA{255, 255, 255, 0xFF}) {
+		t.Errorf("Expected background colors to be initialized to white, got %v", c)
+	}
+
+	gbc = NewGBC(Config{SkipLogo: true, SpeedFactor: 1, ForceDMG: true}, cgbROM, nil)
+	if gbc.Model() != ModelDMG || gbc.CGBMode() {
+		t.Errorf("Expected ForceDMG to run in DMG mode, got %s (CGB mode: %t)", gbc.Model(), gbc.CGBMode())
+	}
+
+	gbc = NewGBC(Config{SkipLogo: true, SpeedFactor: 1, Model: ModelCGB, ForceDMG: true}, cgbROM, nil)
+	if gbc.Model() != ModelCGB || gbc.CGBMode() {
+		t.Errorf("Expected ForceDMG on a CGB to run in compatibility mode, got %s (CGB mode: %t)", gbc.Model(), gbc.CGBMode())
+	}
+}
+
+func TestGBCDoubleSpeed(t *testing.T) {
+	rom := testROM()
+	rom[0x0143] = 0x80
+	copy(rom[0x0100:], []byte{
+		0xC3, 0x50, 0x01, // JP 0x0150
+	})
+	copy(rom[0x0150:], []byte{
+		0x3E, 0x01, // LD A, 0x01
+		0xE0, 0x4D, // LDH (KEY1), A
+		0x10, 0x00, // STOP
+		0x18, 0xFE, // JR -2
+	})
+	gbc := NewGBC(Config{SkipLogo: true, SpeedFactor: 1}, finalizeHeader(rom), nil)
+
+	for i := 0; i < 4; i++ {
+		gbc.cpu.ProcessNextInstruction()
+	}
+
+	if !gbc.mmu.doubleSpeed || gbc.IsStopped() {
+		t.Fatalf("Expected STOP to switch to double speed without stopping the CPU")
+	}
+	if gbc.mmu.Read(0xFF4D) != 0xFE {
+		t.Errorf("Expected KEY1 to read 0xFE in double speed, got %#02x", gbc.mmu.Read(0xFF4D))
+	}
+
+	// The timer runs at twice the rate of everything else
+	totalClocks := gbc.totalClocks
+	timerClocks := gbc.timer.dividerCounter
+	for i := 0; i < 10; i++ {
+		gbc.Tick()
+	}
+	normal := int(gbc.totalClocks - totalClocks)
+	cpu := gbc.timer.dividerCounter - timerClocks
+	if cpu < 2*normal || cpu > 2*normal+1 {
+		t.Errorf("Expected %d normal speed clocks to be %d CPU clocks, got %d", normal, 2*normal, cpu)
+	}
+
+	// Without a prepared switch, STOP stops the CPU until a button is pressed
+	dmg := NewGBC(Config{SkipLogo: true, SpeedFactor: 1, ForceDMG: true}, finalizeHeader(rom), nil)
+	for i := 0; i < 4; i++ {
+		dmg.cpu.ProcessNextInstruction()
+	}
+	if dmg.mmu.doubleSpeed || !dmg.IsStopped() {
+		t.Fatalf("Expected STOP to stop the CPU outside of CGB mode")
+	}
+	dmg.mmu.Write(0xFFFF, 0x01) // Enabled interrupts do not wake the CPU
+	dmg.mmu.interrupts.Request(0)
+	dmg.cpu.ProcessNextInstruction()
+	if !dmg.IsStopped() {
+		t.Errorf("Expected VBlank not to wake the CPU from STOP")
+	}
+	dmg.PressButton(console.ButtonA)
+	dmg.cpu.ProcessNextInstruction()
+	if dmg.IsStopped() {
+		t.Errorf("Expected a button press to wake the CPU from STOP")
+	}
+}
@@ -0,0 +1,157 @@
+package gbc
+
+import (
+	"github.com/omstrumpf/goemu/internal/app/backends/gbc/state"
+	"github.com/omstrumpf/goemu/internal/app/log"
+)
+
+const (
+	// hdmaBlockSize is the number of bytes copied per HBlank, and the unit of transfer lengths
+	hdmaBlockSize = 0x10
+
+	// hdmaBlockClocks is the number of clocks the CPU is stalled for while a block is copied
+	hdmaBlockClocks = 8
+)
+
+// HDMA is the CGB VRAM DMA controller. It copies data from ROM or RAM into VRAM,
+// either all at once (general purpose DMA) or one block per HBlank (HBlank DMA).
+type HDMA struct {
+	mmu *MMU
+
+	src uint16 // HDMA1/HDMA2: source address
+	dst uint16 // HDMA3/HDMA4: destination address in VRAM
+
+	blocks byte // Blocks remaining in the active HBlank transfer, minus 1
+	active bool // An HBlank transfer is in progress
+
+	stall int // CPU clocks owed to transfers, not yet collected by the CPU
+}
+
+// NewHDMA constructs a valid HDMA struct
+func NewHDMA(mmu *MMU) *HDMA {
+	h := new(HDMA)
+
+	h.mmu = mmu
+	h.blocks = 0x7F
+
+	return h
+}
+
+// HBlank copies the next block of an active HBlank transfer. Called by the PPU on entering HBLANK.
+func (h *HDMA) HBlank() {
+	if !h.active {
+		return
+	}
+
+	h.copyBlock()
+
+	h.blocks--
+	if h.blocks == 0xFF || h.dst >= 0xA000 {
+		h.active = false
+		h.blocks = 0x7F
+	}
+}
+
+// TakeStall returns the number of CPU clocks spent on transfers since the last call
+func (h *HDMA) TakeStall() int {
+	stall := h.stall
+	h.stall = 0
+	return stall
+}
+
+// copyBlock copies a single block from the source to VRAM, and advances both addresses.
+// The transfer uses its own bus, so it is not blocked by an OAM DMA transfer.
+func (h *HDMA) copyBlock() {
+	for i := uint16(0); i < hdmaBlockSize && h.dst < 0xA000; i++ {
+		src, srcOffset := h.mmu.mmapLocation(h.src)
+		dst, dstOffset := h.mmu.mmapLocation(h.dst)
+		dst.Write(dstOffset, src.Read(srcOffset))
+		h.src++
+		h.dst++
+	}
+
+	// The transfer takes the same real time in double speed mode, which is twice as many CPU clocks
+	if h.mmu.doubleSpeed {
+		h.stall += 2 * hdmaBlockClocks
+	} else {
+		h.stall += hdmaBlockClocks
+	}
+}
+
+func (h *HDMA) Read(addr uint16) byte {
+	if !h.mmu.cgb {
+		return 0xFF
+	}
+
+	switch addr {
+	case 0xFF51, 0xFF52, 0xFF53, 0xFF54:
+		return 0xFF // Write-only
+	case 0xFF55:
+		if h.active {
+			return h.blocks
+		}
+		return 0x80 | h.blocks
+	}
+
+	log.Warningf("Encountered unexpected HDMA read: %#4x", addr)
+	return 0xFF
+}
+
+func (h *HDMA) Write(addr uint16, val byte) {
+	if !h.mmu.cgb {
+		return
+	}
+
+	switch addr {
+	case 0xFF51:
+		h.src = (h.src & 0x00FF) | uint16(val)<<8
+	case 0xFF52:
+		h.src = (h.src & 0xFF00) | uint16(val&0xF0)
+	case 0xFF53:
+		h.dst = 0x8000 | (h.dst & 0x00FF) | uint16(val&0x1F)<<8
+	case 0xFF54:
+		h.dst = 0x8000 | (h.dst & 0xFF00) | uint16(val&0xF0)
+	case 0xFF55:
+		if h.active && val&0x80 == 0 {
+			// Writing bit 7 clear during an HBlank transfer cancels it
+			log.Tracef("Cancelling HBlank DMA with %d blocks remaining", h.blocks+1)
+			h.active = false
+			return
+		}
+
+		h.blocks = val & 0x7F
+
+		if val&0x80 == 0 {
+			// General purpose DMA: the CPU is stalled until the entire transfer completes
+			log.Tracef("Performing general purpose DMA of %d blocks from %#04x to %#04x", h.blocks+1, h.src, h.dst)
+			for i := 0; i <= int(h.blocks); i++ {
+				h.copyBlock()
+			}
+			h.blocks = 0x7F
+			return
+		}
+
+		log.Tracef("Starting HBlank DMA of %d blocks from %#04x to %#04x", h.blocks+1, h.src, h.dst)
+		h.active = true
+	default:
+		log.Warningf("Encountered unexpected HDMA write: %#4x", addr)
+	}
+}
+
+// SaveState writes the HDMA registers and transfer progress to the save state
+func (h *HDMA) SaveState(w *state.Writer) {
+	w.U16(h.src)
+	w.U16(h.dst)
+	w.U8(h.blocks)
+	w.Bool(h.active)
+	w.Int(h.stall)
+}
+
+// LoadState restores the HDMA registers and transfer progress from the save state
+func (h *HDMA) LoadState(r *state.Reader) {
+	h.src = r.U16()
+	h.dst = r.U16()
+	h.blocks = r.U8()
+	h.active = r.Bool()
+	h.stall = r.Int()
+}
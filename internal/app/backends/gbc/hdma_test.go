@@ -0,0 +1,141 @@
+package gbc
+
+import (
+	"testing"
+)
+
+func newTestHDMA() (*MMU, *PPU) {
+	mmu := NewMMU(nil)
+	ppu := NewPPU(mmu)
+	mmu.ppu = ppu
+	mmu.cgb = true
+	ppu.cgb = true
+	ppu.lcdEnable = true
+
+	// Source data in WRAM
+	for i := uint16(0); i < 0x100; i++ {
+		mmu.Write(0xC000+i, byte(i+1))
+	}
+
+	mmu.Write(0xFF51, 0xC0)
+	mmu.Write(0xFF52, 0x00)
+	mmu.Write(0xFF53, 0x81)
+	mmu.Write(0xFF54, 0x00)
+
+	return mmu, ppu
+}
+
+func TestHDMAGeneralPurpose(t *testing.T) {
+	mmu, _ := newTestHDMA()
+
+	mmu.Write(0xFF55, 0x02) // 3 blocks
+
+	for i := uint16(0); i < 0x30; i++ {
+		if mmu.Read(0x8100+i) != byte(i+1) {
+			t.Fatalf("Expected VRAM %#04x to be %#02x, got %#02x", 0x8100+i, i+1, mmu.Read(0x8100+i))
+		}
+	}
+	if mmu.Read(0x8130) != 0x00 {
+		t.Errorf("Expected transfer to stop after 3 blocks")
+	}
+	if mmu.Read(0xFF55) != 0xFF {
+		t.Errorf("Expected HDMA5 to read 0xFF after the transfer, got %#02x", mmu.Read(0xFF55))
+	}
+	if stall := mmu.hdma.TakeStall(); stall != 3*hdmaBlockClocks {
+		t.Errorf("Expected CPU to stall for %d clocks, got %d", 3*hdmaBlockClocks, stall)
+	}
+	if mmu.hdma.TakeStall() != 0 {
+		t.Errorf("Expected stall to be collected only once")
+	}
+
+	// Twice the CPU clocks in double speed mode
+	mmu.doubleSpeed = true
+	mmu.Write(0xFF55, 0x00)
+	if stall := mmu.hdma.TakeStall(); stall != 2*hdmaBlockClocks {
+		t.Errorf("Expected CPU to stall for %d clocks in double speed, got %d", 2*hdmaBlockClocks, stall)
+	}
+}
+
+func TestHDMAHBlank(t *testing.T) {
+	mmu, ppu := newTestHDMA()
+
+	mmu.Write(0xFF55, 0x82) // 3 blocks, HBlank
+	if mmu.Read(0x8100) != 0x00 {
+		t.Errorf("Expected HBlank transfer not to copy before HBlank")
+	}
+	if mmu.Read(0xFF55) != 0x02 {
+		t.Errorf("Expected HDMA5 to read 0x02 during the transfer, got %#02x", mmu.Read(0xFF55))
+	}
+
+	// Run to the first HBlank
+	ppu.RunForClocks(21 + 43)
+	if mmu.Read(0x810F) != 0x10 || mmu.Read(0x8110) != 0x00 {
+		t.Errorf("Expected one block to be copied in the first HBlank")
+	}
+	if mmu.Read(0xFF55) != 0x01 {
+		t.Errorf("Expected HDMA5 to read 0x01 after one block, got %#02x", mmu.Read(0xFF55))
+	}
+
+	// Cancel after the second block
+	ppu.RunForClocks(50 + 21 + 43)
+	mmu.Write(0xFF55, 0x00)
+	if mmu.Read(0xFF55) != 0x80 {
+		t.Errorf("Expected HDMA5 to read 0x80 after cancelling, got %#02x", mmu.Read(0xFF55))
+	}
+
+	ppu.RunForClocks(50 + 21 + 43)
+	if mmu.Read(0x811F) != 0x20 || mmu.Read(0x8120) != 0x00 {
+		t.Errorf("Expected two blocks to be copied before cancelling")
+	}
+	if stall := mmu.hdma.TakeStall(); stall != 2*hdmaBlockClocks {
+		t.Errorf("Expected CPU to stall for %d clocks, got %d", 2*hdmaBlockClocks, stall)
+	}
+}
+
+func TestHDMADisabledOutsideCGB(t *testing.T) {
+	mmu, _ := newTestHDMA()
+	mmu.cgb = false
+
+	mmu.Write(0xFF55, 0x00)
+	if mmu.Read(0x8100) != 0x00 || mmu.Read(0xFF55) != 0xFF {
+		t.Errorf("Expected HDMA to be unavailable outside of CGB mode")
+	}
+}
+
+func TestHDMADuringOAMDMA(t *testing.T) {
+	mmu, ppu := newTestHDMA()
+
+	mmu.Write(0xFF46, 0xC0)
+	mmu.dma.RunForClocks(oamDMAStartDelay + 1)
+	if !mmu.dma.Blocking() {
+		t.Fatalf("Expected the OAM DMA transfer to be running")
+	}
+
+	// General purpose transfer while the CPU bus is blocked
+	mmu.Write(0xFF55, 0x00)
+	for i := uint16(0); i < 0x10; i++ {
+		if got := mmu.ReadVRAM(0, 0x8100+i); got != byte(i+1) {
+			t.Fatalf("Expected VRAM %#04x to be %#02x, got %#02x", 0x8100+i, i+1, got)
+		}
+	}
+
+	// HBlank transfer while the CPU bus is blocked
+	mmu.Write(0xFF55, 0x80)
+	ppu.RunForClocks(21 + 43)
+	if !mmu.dma.Blocking() {
+		t.Fatalf("Expected the OAM DMA transfer to still be running")
+	}
+	for i := uint16(0); i < 0x10; i++ {
+		if got := mmu.ReadVRAM(0, 0x8110+i); got != byte(i+0x11) {
+			t.Fatalf("Expected VRAM %#04x to be %#02x, got %#02x", 0x8110+i, i+0x11, got)
+		}
+	}
+
+	// The OAM DMA transfer is unaffected
+	mmu.dma.RunForClocks(oamDMALength)
+	for i := uint16(0); i < oamDMALength; i++ {
+		if got := mmu.ppu.oam.Read(i); got != byte(i+1) {
+			t.Fatalf("Expected OAM %#02x to be %#02x, got %#02x", i, i+1, got)
+		}
+	}
+}
@@ -1,6 +1,7 @@
 package gbc
 
 import (
+	"github.com/omstrumpf/goemu/internal/app/backends/gbc/state"
 	"github.com/omstrumpf/goemu/internal/app/console"
 	"github.com/omstrumpf/goemu/internal/app/log"
 )
@@ -83,3 +84,15 @@ func (imd *inputMemoryDevice) Write(addr uint16, val byte) {
 		imd.directional = false
 	}
 }
+
+func (imd *inputMemoryDevice) SaveState(w *state.Writer) {
+	w.U8(imd.standardButtons)
+	w.U8(imd.directionalButtons)
+	w.Bool(imd.directional)
+}
+
+func (imd *inputMemoryDevice) LoadState(r *state.Reader) {
+	imd.standardButtons = r.U8()
+	imd.directionalButtons = r.U8()
+	imd.directional = r.Bool()
+}
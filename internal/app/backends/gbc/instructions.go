@@ -973,9 +973,17 @@ func (cpu *CPU) PopulateInstructions() {
 			}
 		},
 		0x10: func() { // STOP
+			cpu.PC.Inc()
+			cpu.mmu.Write(0xFF04, 0x00) // DIV is reset
+
+			if cpu.mmu.switchSpeed() {
+				log.Tracef("CPU switching speed (double speed: %t)", cpu.mmu.doubleSpeed)
+				cpu.instructionClock += speedSwitchClocks
+				return
+			}
+
 			log.Tracef("CPU stopping (low power mode)")
 			cpu.stop = true
-			cpu.PC.Inc()
 		},
 		0xF3: func() { // DI
 			cpu.ime = false
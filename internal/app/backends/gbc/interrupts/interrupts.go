@@ -1,6 +1,9 @@
 package interrupts
 
-import "github.com/omstrumpf/goemu/internal/app/log"
+import (
+	"github.com/omstrumpf/goemu/internal/app/backends/gbc/state"
+	"github.com/omstrumpf/goemu/internal/app/log"
+)
 
 // InterruptDevice manages enabled/flagged interrupts
 type InterruptDevice struct {
@@ -58,3 +61,15 @@ func (id *InterruptDevice) Write(addr uint16, val byte) {
 
 	log.Warningf("Encountered unexpected interrupt location: %#4x", addr)
 }
+
+// SaveState writes the interrupt registers to the save state
+func (id *InterruptDevice) SaveState(w *state.Writer) {
+	w.U8(id.enable)
+	w.U8(id.flag)
+}
+
+// LoadState restores the interrupt registers from the save state
+func (id *InterruptDevice) LoadState(r *state.Reader) {
+	id.enable = r.U8()
+	id.flag = r.U8()
+}
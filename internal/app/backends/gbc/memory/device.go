@@ -1,5 +1,7 @@
 package memory
 
+import "github.com/omstrumpf/goemu/internal/app/backends/gbc/state"
+
 // Device is a device that supports a Read/Write memory interface
 type Device interface {
 	Read(uint16) byte
@@ -35,6 +37,16 @@ func (s *Simple) Write(addr uint16, val byte) {
 	s.buf[addr] = val
 }
 
+// SaveState writes the buffer contents to the save state
+func (s *Simple) SaveState(w *state.Writer) {
+	w.Bytes(s.buf)
+}
+
+// LoadState restores the buffer contents from the save state
+func (s *Simple) LoadState(r *state.Reader) {
+	r.Bytes(s.buf)
+}
+
 // Zero is a Device that always contains 0
 type Zero struct{}
 
@@ -6,12 +6,13 @@ import (
 	"github.com/omstrumpf/goemu/internal/app/backends/gbc/bios"
 	"github.com/omstrumpf/goemu/internal/app/backends/gbc/interrupts"
 	"github.com/omstrumpf/goemu/internal/app/backends/gbc/memory"
+	"github.com/omstrumpf/goemu/internal/app/backends/gbc/state"
 	"github.com/omstrumpf/goemu/internal/app/log"
 )
 
 const (
-	vramlen = 0x2000
-	wramlen = 0x2000
+	vramlen = 0x4000 // 2 banks of 8KB. Bank 1 is only accessible in CGB mode.
+	wramlen = 0x8000 // 8 banks of 4KB. Banks 2-7 are only accessible in CGB mode.
 	zramlen = 0x80
 
 	totalramlen = 0x10000
@@ -20,9 +21,9 @@ const (
 // MMU represents the memory management unit.
 type MMU struct {
 	bios memory.Device
-	vram memory.Device
-	wram memory.Device
-	zram memory.Device
+	vram *memory.Simple
+	wram *memory.Simple
+	zram *memory.Simple
 
 	bankController banking.Controller
 
@@ -33,10 +34,22 @@ type MMU struct {
 	high memory.Device
 
 	biosEnable bool
+	biosLength int
 
-	ppu   *PPU
-	apu   *audio.APU
-	timer *Timer
+	cgb      bool // CGB mode: enables VRAM and WRAM banking
+	vramBank byte // VBK: VRAM bank mapped at 0x8000-0x9FFF
+	wramBank byte // SVBK: WRAM bank mapped at 0xD000-0xDFFF. 0 selects bank 1.
+
+	doubleSpeed  bool // KEY1 bit 7: the CPU and timer are running at double speed
+	speedPrepare bool // KEY1 bit 0: switch speed on the next STOP instruction
+
+	hdma *HDMA
+	dma  *OAMDMA
+
+	ppu    *PPU
+	apu    *audio.APU
+	timer  *Timer
+	serial *Serial
 }
 
 // NewMMU constructs a valid MMU struct
@@ -44,6 +57,7 @@ func NewMMU(bankController banking.Controller) *MMU {
 	mmu := new(MMU)
 
 	mmu.bios = bios.BIOS
+	mmu.biosLength = bios.DMGLength
 	mmu.vram = memory.NewSimple(vramlen)
 	mmu.wram = memory.NewSimple(wramlen)
 	mmu.zram = memory.NewSimple(zramlen)
@@ -58,16 +72,114 @@ func NewMMU(bankController banking.Controller) *MMU {
 
 	mmu.biosEnable = true
 
+	mmu.hdma = NewHDMA(mmu)
+	mmu.dma = NewOAMDMA(mmu)
+
 	return mmu
 }
 
+// SetBios replaces the built-in BIOS with the given boot ROM image.
+// CGB boot ROMs are mapped around the cartridge header, at 0x0000-0x00FF and 0x0200-0x08FF.
+func (mmu *MMU) SetBios(data []byte) {
+	mmu.bios = memory.NewSimpleWithData(append([]byte(nil), data...))
+	mmu.biosLength = len(data)
+}
+
+// switchSpeed performs a prepared CGB speed switch, and returns whether a switch took place.
+// Called by the STOP instruction.
+func (mmu *MMU) switchSpeed() bool {
+	if !mmu.cgb || !mmu.speedPrepare {
+		return false
+	}
+
+	mmu.doubleSpeed = !mmu.doubleSpeed
+	mmu.speedPrepare = false
+
+	return true
+}
+
 // DisableBios disables the BIOS map over the main ROM
 func (mmu *MMU) DisableBios() {
 	mmu.biosEnable = false
 }
 
+// SaveState writes the MMU's RAM devices and memory-mapped controllers to the save state.
+// The PPU, APU, and timer are not included, they are serialized separately.
+func (mmu *MMU) SaveState(w *state.Writer) {
+	mmu.vram.SaveState(w)
+	mmu.wram.SaveState(w)
+	mmu.zram.SaveState(w)
+
+	mmu.inputs.SaveState(w)
+	mmu.interrupts.SaveState(w)
+
+	w.Bool(mmu.biosEnable)
+
+	w.U8(mmu.vramBank)
+	w.U8(mmu.wramBank)
+
+	w.Bool(mmu.doubleSpeed)
+	w.Bool(mmu.speedPrepare)
+	mmu.hdma.SaveState(w)
+	mmu.dma.SaveState(w)
+}
+
+// LoadState restores the MMU's RAM devices and memory-mapped controllers from the save state
+func (mmu *MMU) LoadState(r *state.Reader) {
+	mmu.vram.LoadState(r)
+	mmu.wram.LoadState(r)
+	mmu.zram.LoadState(r)
+
+	mmu.inputs.LoadState(r)
+	mmu.interrupts.LoadState(r)
+
+	mmu.biosEnable = r.Bool()
+
+	mmu.vramBank = r.U8()
+	mmu.wramBank = r.U8()
+
+	mmu.doubleSpeed = r.Bool()
+	mmu.speedPrepare = r.Bool()
+	mmu.hdma.LoadState(r)
+	mmu.dma.LoadState(r)
+}
+
 // Read returns the 8-bit value from the address
 func (mmu *MMU) Read(addr uint16) byte {
+	// During OAM DMA, only HRAM and I/O are accessible
+	if mmu.dma.Blocking() && addr < 0xFF00 {
+		if addr >= 0xFE00 {
+			return 0xFF // OAM
+		}
+		return mmu.dma.BusValue()
+	}
+
+	// Traps for MMU registers
+	switch addr {
+	case 0xFF4D: // KEY1
+		if mmu.cgb {
+			ret := byte(0x7E)
+			if mmu.doubleSpeed {
+				ret |= 0x80
+			}
+			if mmu.speedPrepare {
+				ret |= 0x01
+			}
+			return ret
+		}
+		return 0xFF
+	case 0xFF4F: // VBK
+		if mmu.cgb {
+			return 0xFE | mmu.vramBank
+		}
+		return 0xFF
+	case 0xFF70: // SVBK
+		if mmu.cgb {
+			return 0xF8 | mmu.wramBank
+		}
+		return 0xFF
+	}
+
 	device, offset := mmu.mmapLocation(addr)
 	result := device.Read(offset)
 	return result
@@ -75,32 +187,46 @@ func (mmu *MMU) Read(addr uint16) byte {
 
 // Write writes the 8-bit value to the address
 func (mmu *MMU) Write(addr uint16, val byte) {
-	// Traps for MMU on-write functionality
-	if addr == 0XFF46 { // DMA
-		log.Tracef("Performing DMA")
-		src := uint16(val) << 8
-		if src > 0xF100 {
-			src = 0xF100
-		}
-
-		// TODO technically should be waiting 160us for the transfer to complete.
-		// Access to memory should be restricted until it is done (except for HRAM).
-
-		for i := uint16(0); i < 0xA0; i++ {
-			mmu.ppu.oam.Write(i, mmu.Read(src+i))
-		}
+	// During OAM DMA, only HRAM and I/O are accessible
+	if mmu.dma.Blocking() && addr < 0xFF00 {
+		log.Tracef("Ignoring write to %#04x during OAM DMA", addr)
 		return
 	}
+
+	// Traps for MMU on-write functionality
 	if addr == 0xFF50 { // Disable BIOS memory overlay
 		log.Tracef("Disabling BIOS memory overlay")
 		mmu.DisableBios()
 		return
 	}
+	if addr == 0xFF4D { // KEY1
+		if mmu.cgb {
+			mmu.speedPrepare = (val&0x01 != 0)
+		}
+		return
+	}
+	if addr == 0xFF4F { // VBK
+		if mmu.cgb {
+			mmu.vramBank = val & 0x01
+		}
+		return
+	}
+	if addr == 0xFF70 { // SVBK
+		if mmu.cgb {
+			mmu.wramBank = val & 0x07
+		}
+		return
+	}
 
 	device, offset := mmu.mmapLocation(addr)
 	device.Write(offset, val)
 }
 
+// ReadVRAM reads from the given VRAM bank, regardless of the bank currently mapped by VBK
+func (mmu *MMU) ReadVRAM(bank byte, addr uint16) byte {
+	return mmu.vram.Read((addr & 0x1FFF) | uint16(bank&1)<<13)
+}
+
 // Read16 returns the 16-bit value from the address
 func (mmu *MMU) Read16(addr uint16) uint16 {
 	return uint16(mmu.Read(addr)) + (uint16(mmu.Read(addr+1)) << 8)
@@ -112,11 +238,26 @@ func (mmu *MMU) Write16(addr uint16, val uint16) {
 	mmu.Write(addr+1, byte(val>>8))
 }
 
+// wramOffset returns the offset into WRAM for an address in WRAM or its shadow
+func (mmu *MMU) wramOffset(addr uint16) uint16 {
+	if addr&0x1000 == 0 {
+		// Fixed bank 0
+		return addr & 0x0FFF
+	}
+
+	bank := uint16(mmu.wramBank)
+	if bank == 0 {
+		bank = 1
+	}
+
+	return (addr & 0x0FFF) | bank<<12
+}
+
 func (mmu *MMU) mmapLocation(addr uint16) (md memory.Device, offset uint16) {
 	switch addr & 0xF000 {
 	// BIOS is mapped over ROM on startup
 	case 0x0000:
-		if mmu.biosEnable && addr < 0x0100 {
+		if mmu.biosEnable && (addr < 0x0100 || (addr >= 0x0200 && int(addr) < mmu.biosLength)) {
 			return mmu.bios, addr
 		}
 		fallthrough
@@ -125,21 +266,18 @@ func (mmu *MMU) mmapLocation(addr uint16) (md memory.Device, offset uint16) {
 		return mmu.bankController, addr
 	// PPU VRAM
 	case 0x8000, 0x9000:
-		return mmu.vram, addr & 0x1FFF
+		return mmu.vram, (addr & 0x1FFF) | uint16(mmu.vramBank)<<13
 	// Cartridge RAM
 	case 0xA000, 0xB000:
 		return mmu.bankController, addr
-	// Working RAM
-	case 0xC000, 0xD000:
-		return mmu.wram, addr & 0x1FFF
-	// WRAM Shadow
-	case 0xE000:
-		return mmu.wram, addr & 0x1FFF
+	// Working RAM, and its shadow
+	case 0xC000, 0xD000, 0xE000:
+		return mmu.wram, mmu.wramOffset(addr)
 	// Shadow, IO, and ZRAM
 	case 0xF000:
 		switch addr & 0x0F00 {
 		case 0x000, 0x100, 0x200, 0x300, 0x400, 0x500, 0x600, 0x700, 0x800, 0x900, 0xA00, 0xB00, 0xC00, 0xD00:
-			return mmu.wram, addr & 0x1FFF
+			return mmu.wram, mmu.wramOffset(addr)
 		// PPU OAM
 		case 0xE00:
 			if addr < 0xFEA0 {
@@ -160,6 +298,8 @@ func (mmu *MMU) mmapLocation(addr uint16) (md memory.Device, offset uint16) {
 				switch addr & 0x000F {
 				case 0x0:
 					return mmu.inputs, 0
+				case 0x1, 0x2:
+					return mmu.serial, addr
 				case 0x4, 0x5, 0x6, 0x7:
 					return mmu.timer, addr
 				default:
@@ -167,7 +307,16 @@ func (mmu *MMU) mmapLocation(addr uint16) (md memory.Device, offset uint16) {
 				}
 			case 0x10, 0x20, 0x30: // APU Control
 				return mmu.apu, addr
-			case 0x40, 0x50, 0x60, 0x70: // PPU Control
+			case 0x50: // CGB VRAM DMA
+				switch addr {
+				case 0xFF51, 0xFF52, 0xFF53, 0xFF54, 0xFF55:
+					return mmu.hdma, addr
+				}
+				return mmu.ppu, addr
+			case 0x40, 0x60, 0x70: // PPU Control
+				if addr == 0xFF46 {
+					return mmu.dma, addr
+				}
 				return mmu.ppu, addr
 			case 0x80, 0x90, 0xA0, 0xB0, 0xC0, 0xD0, 0xE0, 0xF0: // ZRAM
 				return mmu.zram, addr & 0x7F
@@ -39,3 +39,61 @@ func TestMMUReadWrite(t *testing.T) {
 		t.Errorf("Expected to read writte nvalue of 0x78, got %#2x", got)
 	}
 }
+
+func TestMMUCGBBanking(t *testing.T) {
+	mmu := NewMMU(nil)
+
+	// Banking registers are ignored outside of CGB mode
+	mmu.Write(0xFF4F, 0x01)
+	mmu.Write(0xFF70, 0x03)
+	if mmu.Read(0xFF4F) != 0xFF || mmu.Read(0xFF70) != 0xFF {
+		t.Errorf("Expected banking registers to read 0xFF outside of CGB mode")
+	}
+
+	mmu.cgb = true
+
+	mmu.Write(0x8000, 0x11)
+	mmu.Write(0xFF4F, 0x01)
+	if mmu.Read(0xFF4F) != 0xFF {
+		t.Errorf("Expected VBK to read 0xFF, got %#02x", mmu.Read(0xFF4F))
+	}
+	if mmu.Read(0x8000) != 0x00 {
+		t.Errorf("Expected VRAM bank 1 to be empty, got %#02x", mmu.Read(0x8000))
+	}
+	mmu.Write(0x8000, 0x22)
+	if mmu.ReadVRAM(0, 0x8000) != 0x11 || mmu.ReadVRAM(1, 0x8000) != 0x22 {
+		t.Errorf("Expected VRAM banks to be independent")
+	}
+	mmu.Write(0xFF4F, 0x00)
+	if mmu.Read(0x8000) != 0x11 {
+		t.Errorf("Expected VRAM bank 0 to be restored, got %#02x", mmu.Read(0x8000))
+	}
+
+	// Bank 0 selects bank 1
+	mmu.Write(0xD000, 0x01)
+	mmu.Write(0xFF70, 0x01)
+	if mmu.Read(0xD000) != 0x01 {
+		t.Errorf("Expected SVBK 0 to select WRAM bank 1")
+	}
+
+	for bank := byte(2); bank < 8; bank++ {
+		mmu.Write(0xFF70, bank)
+		mmu.Write(0xD000, bank)
+		mmu.Write(0xC000, 0xC0|bank)
+	}
+	for bank := byte(1); bank < 8; bank++ {
+		mmu.Write(0xFF70, bank)
+		if mmu.Read(0xD000) != bank {
+			t.Errorf("Expected WRAM bank %d to hold %d, got %d", bank, bank, mmu.Read(0xD000))
+		}
+		if mmu.Read(0xF000) != bank {
+			t.Errorf("Expected WRAM shadow to follow bank %d", bank)
+		}
+		if mmu.Read(0xC000) != 0xC7 {
+			t.Errorf("Expected WRAM bank 0 to be fixed, got %#02x", mmu.Read(0xC000))
+		}
+	}
+	if mmu.Read(0xFF70) != 0xFF {
+		t.Errorf("Expected SVBK to read 0xFF, got %#02x", mmu.Read(0xFF70))
+	}
+}
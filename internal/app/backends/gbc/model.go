@@ -0,0 +1,89 @@
+package gbc
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Model is a gameboy hardware model
+type Model int
+
+// Models
+const (
+	ModelAuto Model = iota // Selected from the boot ROM
+	ModelDMG               // Original gameboy
+	ModelMGB               // Gameboy pocket
+	ModelSGB               // Super gameboy
+	ModelCGB               // Gameboy color
+)
+
+func (m Model) String() string {
+	switch m {
+	case ModelAuto:
+		return "auto"
+	case ModelDMG:
+		return "DMG"
+	case ModelMGB:
+		return "MGB"
+	case ModelSGB:
+		return "SGB"
+	case ModelCGB:
+		return "CGB"
+	default:
+		return "UNKNOWN"
+	}
+}
+
+// ParseModel parses a model name, as returned by Model.String
+func ParseModel(name string) (Model, error) {
+	for _, m := range []Model{ModelAuto, ModelDMG, ModelMGB, ModelSGB, ModelCGB} {
+		if strings.EqualFold(name, m.String()) {
+			return m, nil
+		}
+	}
+
+	return ModelAuto, fmt.Errorf("unknown model %q", name)
+}
+
+// postBootState is the state left behind by a model's boot ROM
+type postBootState struct {
+	af, bc, de, hl uint16
+
+	nr52 byte
+
+	div    byte
+	setDiv bool // Whether the DIV value is deterministic on this model
+}
+
+// postBootStates maps each model to its post-boot state. The DMG and MGB flags depend on the header checksum,
+// and the CGB registers depend on whether the cartridge supports CGB mode. See postBoot.
+var postBootStates = map[Model]postBootState{
+	ModelDMG: {af: 0x01B0, bc: 0x0013, de: 0x00D8, hl: 0x014D, nr52: 0xF1, div: 0xAB, setDiv: true},
+	ModelMGB: {af: 0xFFB0, bc: 0x0013, de: 0x00D8, hl: 0x014D, nr52: 0xF1, div: 0xAB, setDiv: true},
+	ModelSGB: {af: 0x0100, bc: 0x0014, de: 0x0000, hl: 0xC060, nr52: 0xF0},
+	ModelCGB: {af: 0x1180, bc: 0x0000, de: 0xFF56, hl: 0x000D, nr52: 0xF1},
+}
+
+// postBoot returns the post-boot state for the model, running the given cartridge
+func postBoot(model Model, headerChecksum byte, cgbCart bool) postBootState {
+	s, ok := postBootStates[model]
+	if !ok {
+		s = postBootStates[ModelDMG]
+	}
+
+	switch model {
+	case ModelDMG, ModelMGB:
+		// The half carry and carry flags are left set unless the header checksum is zero
+		if headerChecksum == 0 {
+			s.af &= 0xFF80
+		}
+	case ModelCGB:
+		if !cgbCart {
+			// DMG compatibility mode
+			s.de = 0x0008
+			s.hl = 0x007C
+		}
+	}
+
+	return s
+}
@@ -0,0 +1,132 @@
+package gbc
+
+import (
+	"github.com/omstrumpf/goemu/internal/app/backends/gbc/state"
+	"github.com/omstrumpf/goemu/internal/app/log"
+)
+
+const (
+	// oamDMALength is the number of bytes copied by an OAM DMA transfer, one per clock
+	oamDMALength = 0xA0
+
+	// oamDMAStartDelay is the number of clocks between writing DMA and the first byte being copied
+	oamDMAStartDelay = 1
+)
+
+// OAMDMA is the OAM DMA controller. It copies 160 bytes into OAM, one byte per clock, alongside the CPU.
+// While a transfer is active, the CPU can only access HRAM and the I/O registers.
+type OAMDMA struct {
+	mmu *MMU
+
+	reg byte // DMA: the last value written, the source address high byte
+
+	active bool   // A transfer is in progress, and the bus is blocked
+	source uint16 // Source address of the active transfer
+	index  uint16 // Next byte to copy in the active transfer
+	value  byte   // Last byte copied, which the CPU sees on the blocked bus
+
+	startDelay  int    // Clocks until the pending transfer starts. Zero if none is pending.
+	startSource uint16 // Source address of the pending transfer
+}
+
+// NewOAMDMA constructs a valid OAMDMA struct
+func NewOAMDMA(mmu *MMU) *OAMDMA {
+	d := new(OAMDMA)
+
+	d.mmu = mmu
+	d.reg = 0xFF
+
+	return d
+}
+
+// RunForClocks runs the DMA controller for the given number of clock cycles
+func (d *OAMDMA) RunForClocks(clocks int) {
+	for c := 0; c < clocks; c++ {
+		// A restarted transfer keeps running until the new transfer starts
+		if d.active {
+			d.copyByte()
+		}
+
+		if d.startDelay > 0 {
+			d.startDelay--
+			if d.startDelay == 0 {
+				d.active = true
+				d.source = d.startSource
+				d.index = 0
+			}
+		}
+	}
+}
+
+// Blocking returns true if a transfer is in progress, and the CPU's access to memory is restricted
+func (d *OAMDMA) Blocking() bool {
+	return d.active
+}
+
+// BusValue returns the value the CPU reads from a blocked address
+func (d *OAMDMA) BusValue() byte {
+	return d.value
+}
+
+// copyByte copies the next byte of the active transfer into OAM
+func (d *OAMDMA) copyByte() {
+	addr := d.source + d.index
+
+	// Sources above WRAM read from the WRAM shadow
+	if addr >= 0xE000 {
+		addr -= 0x2000
+	}
+
+	device, offset := d.mmu.mmapLocation(addr)
+	d.value = device.Read(offset)
+	d.mmu.ppu.oam.Write(d.index, d.value)
+
+	d.index++
+	if d.index == oamDMALength {
+		d.active = false
+	}
+}
+
+func (d *OAMDMA) Read(addr uint16) byte {
+	if addr == 0xFF46 {
+		return d.reg
+	}
+
+	log.Warningf("Encountered unexpected OAM DMA read: %#4x", addr)
+	return 0xFF
+}
+
+func (d *OAMDMA) Write(addr uint16, val byte) {
+	if addr != 0xFF46 {
+		log.Warningf("Encountered unexpected OAM DMA write: %#4x", addr)
+		return
+	}
+
+	log.Tracef("Starting OAM DMA from %#04x", uint16(val)<<8)
+
+	d.reg = val
+	d.startSource = uint16(val) << 8
+	d.startDelay = oamDMAStartDelay
+}
+
+// SaveState writes the DMA register and transfer progress to the save state
+func (d *OAMDMA) SaveState(w *state.Writer) {
+	w.U8(d.reg)
+	w.Bool(d.active)
+	w.U16(d.source)
+	w.U16(d.index)
+	w.U8(d.value)
+	w.Int(d.startDelay)
+	w.U16(d.startSource)
+}
+
+// LoadState restores the DMA register and transfer progress from the save state
+func (d *OAMDMA) LoadState(r *state.Reader) {
+	d.reg = r.U8()
+	d.active = r.Bool()
+	d.source = r.U16()
+	d.index = r.U16()
+	d.value = r.U8()
+	d.startDelay = r.Int()
+	d.startSource = r.U16()
+}
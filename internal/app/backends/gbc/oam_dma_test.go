@@ -0,0 +1,109 @@
+package gbc
+
+import (
+	"testing"
+)
+
+func newTestOAMDMA() *MMU {
+	mmu := NewMMU(nil)
+	ppu := NewPPU(mmu)
+	mmu.ppu = ppu
+
+	for i := uint16(0); i < oamDMALength; i++ {
+		mmu.Write(0xC000+i, byte(i+1))
+		mmu.Write(0xD000+i, byte(0xA0-i))
+	}
+	mmu.Write(0xFF80, 0x42)
+
+	return mmu
+}
+
+func TestOAMDMATransfer(t *testing.T) {
+	mmu := newTestOAMDMA()
+
+	mmu.Write(0xFF46, 0xC0)
+	if mmu.Read(0xFF46) != 0xC0 {
+		t.Errorf("Expected DMA register to read back 0xC0, got %#02x", mmu.Read(0xFF46))
+	}
+
+	// Start delay: the bus is not yet blocked
+	if mmu.dma.Blocking() || mmu.Read(0xC005) != 0x06 {
+		t.Errorf("Expected memory to be accessible before the transfer starts")
+	}
+	mmu.dma.RunForClocks(oamDMAStartDelay)
+	if mmu.ppu.oam.Read(0) != 0x00 {
+		t.Errorf("Expected no bytes to be copied during the start delay")
+	}
+
+	mmu.dma.RunForClocks(10)
+	if !mmu.dma.Blocking() {
+		t.Fatalf("Expected the bus to be blocked during the transfer")
+	}
+	if mmu.ppu.oam.Read(9) != 0x0A || mmu.ppu.oam.Read(10) != 0x00 {
+		t.Errorf("Expected one byte to be copied per clock")
+	}
+
+	// Non-HRAM reads see the DMA bus, and OAM is inaccessible
+	if got := mmu.Read(0x0150); got != 0x0A {
+		t.Errorf("Expected ROM read to return the DMA bus value 0x0A, got %#02x", got)
+	}
+	if got := mmu.Read(0xC000); got != 0x0A {
+		t.Errorf("Expected WRAM read to return the DMA bus value 0x0A, got %#02x", got)
+	}
+	if got := mmu.Read(0xFE00); got != 0xFF {
+		t.Errorf("Expected OAM read to return 0xFF, got %#02x", got)
+	}
+	if got := mmu.Read(0xFF80); got != 0x42 {
+		t.Errorf("Expected HRAM to be accessible, got %#02x", got)
+	}
+	if got := mmu.Read(0xFF46); got != 0xC0 {
+		t.Errorf("Expected I/O registers to be accessible, got %#02x", got)
+	}
+
+	mmu.Write(0xC000, 0x99)
+	mmu.Write(0xFE00, 0x99)
+	mmu.Write(0xFF81, 0x24)
+
+	mmu.dma.RunForClocks(oamDMALength - 10)
+	if mmu.dma.Blocking() {
+		t.Fatalf("Expected the transfer to complete after %d clocks", oamDMALength)
+	}
+
+	for i := uint16(0); i < oamDMALength; i++ {
+		if mmu.ppu.oam.Read(i) != byte(i+1) {
+			t.Fatalf("Expected OAM byte %d to be %#02x, got %#02x", i, i+1, mmu.ppu.oam.Read(i))
+		}
+	}
+	if mmu.Read(0xC000) != 0x01 {
+		t.Errorf("Expected WRAM write during the transfer to be ignored")
+	}
+	if mmu.Read(0xFF81) != 0x24 {
+		t.Errorf("Expected HRAM write during the transfer to succeed")
+	}
+}
+
+func TestOAMDMARestart(t *testing.T) {
+	mmu := newTestOAMDMA()
+
+	mmu.Write(0xFF46, 0xC0)
+	mmu.dma.RunForClocks(oamDMAStartDelay + 5)
+
+	// The old transfer continues through the new transfer's start delay
+	mmu.Write(0xFF46, 0xD0)
+	mmu.dma.RunForClocks(oamDMAStartDelay)
+	if !mmu.dma.Blocking() || mmu.ppu.oam.Read(5) != 0x06 {
+		t.Errorf("Expected the old transfer to continue during the start delay")
+	}
+
+	mmu.dma.RunForClocks(oamDMALength)
+	if mmu.ppu.oam.Read(0) != 0xA0 || mmu.ppu.oam.Read(0x9F) != 0x01 {
+		t.Errorf("Expected the restarted transfer to copy from the new source")
+	}
+
+	// Sources above WRAM read from the WRAM shadow
+	mmu.Write(0xFF46, 0xF0)
+	mmu.dma.RunForClocks(oamDMAStartDelay + oamDMALength)
+	if mmu.ppu.oam.Read(0) != 0xA0 {
+		t.Errorf("Expected source 0xF000 to read from WRAM, got %#02x", mmu.ppu.oam.Read(0))
+	}
+}
@@ -0,0 +1,276 @@
+package gbc
+
+import (
+	"fmt"
+	"image/color"
+	"strconv"
+	"strings"
+
+	"github.com/omstrumpf/goemu/internal/app/backends/gbc/cartridge"
+)
+
+// Palette maps the four DMG shades, lightest first, to display colors.
+// The background and each sprite palette can have their own colors, as with the CGB's DMG compatibility palettes.
+type Palette struct {
+	Name string
+	BG   [4]color.RGBA
+	OBJ0 [4]color.RGBA
+	OBJ1 [4]color.RGBA
+}
+
+// PaletteAuto is the name of the palette selected per game from the CGB boot ROM's colorization table
+const PaletteAuto = "auto"
+
+// PaletteGray is the default palette, in shades of gray
+var PaletteGray = monoPalette("gray", 0xFFFFFF, 0xC0C0C0, 0x606060, 0x000000)
+
+// palettePresets are the built-in palettes, in the order the palette hotkey cycles through them
+var palettePresets = []Palette{
+	PaletteGray,
+	monoPalette("green", 0x9BBC0F, 0x8BAC0F, 0x306230, 0x0F380F), // DMG
+	monoPalette("pocket", 0xC4CFA1, 0x8B956D, 0x4D533C, 0x1F1F1F),
+	monoPalette("light", 0x00B581, 0x009A71, 0x00694A, 0x004F3B),
+}
+
+// ParsePalette parses a palette name, or a comma-separated list of hex colors, lightest first.
+// A list of 4 colors is used for the background and sprites. A list of 12 colors is split between BG, OBJ0, and OBJ1.
+// The auto palette is returned by name only, and is resolved from the cartridge when the GBC is constructed.
+func ParsePalette(s string) (Palette, error) {
+	if strings.EqualFold(s, PaletteAuto) {
+		return Palette{Name: PaletteAuto}, nil
+	}
+
+	for _, p := range palettePresets {
+		if strings.EqualFold(s, p.Name) {
+			return p, nil
+		}
+	}
+
+	fields := strings.Split(s, ",")
+	if len(fields) != 4 && len(fields) != 12 {
+		return Palette{}, fmt.Errorf("unknown palette: %s", s)
+	}
+
+	var colors []color.RGBA
+	for _, f := range fields {
+		f = strings.TrimPrefix(strings.TrimSpace(f), "#")
+		v, err := strconv.ParseUint(f, 16, 32)
+		if err != nil || len(f) != 6 {
+			return Palette{}, fmt.Errorf("invalid palette color: %s", f)
+		}
+		colors = append(colors, hexColor(uint32(v)))
+	}
+
+	p := Palette{Name: "custom"}
+	copy(p.BG[:], colors)
+	p.OBJ0, p.OBJ1 = p.BG, p.BG
+	if len(colors) == 12 {
+		copy(p.OBJ0[:], colors[4:8])
+		copy(p.OBJ1[:], colors[8:12])
+	}
+	return p, nil
+}
+
+// monoPalette constructs a palette with the same colors for the background and sprites
+func monoPalette(name string, c0, c1, c2, c3 uint32) Palette {
+	shades := [4]color.RGBA{hexColor(c0), hexColor(c1), hexColor(c2), hexColor(c3)}
+	return Palette{Name: name, BG: shades, OBJ0: shades, OBJ1: shades}
+}
+
+// hexColor converts a 24 bit RGB value to RGBA
+func hexColor(c uint32) color.RGBA {
+	return color.RGBA{byte(c >> 16), byte(c >> 8), byte(c), 0xFF}
+}
+
+// cgbPaletteColors are the colors of the CGB boot ROM's compatibility palettes, in BGR555, four per palette
+var cgbPaletteColors = [...]uint16{
+	0x7FFF, 0x32BF, 0x00D0, 0x0000, // 0
+	0x639F, 0x4279, 0x15B0, 0x04CB, // 1
+	0x7FFF, 0x6E31, 0x454A, 0x0000, // 2
+	0x7FFF, 0x1BEF, 0x0200, 0x0000, // 3
+	0x7FFF, 0x421F, 0x1CF2, 0x0000, // 4
+	0x7FFF, 0x5294, 0x294A, 0x0000, // 5
+	0x7FFF, 0x03FF, 0x012F, 0x0000, // 6
+	0x7FFF, 0x03EF, 0x01D6, 0x0000, // 7
+	0x7FFF, 0x42B5, 0x3DC8, 0x0000, // 8
+	0x7E74, 0x03FF, 0x0180, 0x0000, // 9
+	0x67FF, 0x77AC, 0x1A13, 0x2D6B, // 10
+	0x7ED6, 0x4BFF, 0x2175, 0x0000, // 11
+	0x53FF, 0x4A5F, 0x7E52, 0x0000, // 12
+	0x4FFF, 0x7ED2, 0x3A4C, 0x1CE0, // 13
+	0x03ED, 0x7FFF, 0x255F, 0x0000, // 14
+	0x036A, 0x021F, 0x03FF, 0x7FFF, // 15
+	0x7FFF, 0x01DF, 0x0112, 0x0000, // 16
+	0x231F, 0x035F, 0x00F2, 0x0009, // 17
+	0x7FFF, 0x03EA, 0x011F, 0x0000, // 18
+	0x299F, 0x001A, 0x000C, 0x0000, // 19
+	0x7FFF, 0x027F, 0x001F, 0x0000, // 20
+	0x7FFF, 0x03E0, 0x0206, 0x0120, // 21
+	0x7FFF, 0x7EEB, 0x001F, 0x7C00, // 22
+	0x7FFF, 0x3FFF, 0x7E00, 0x001F, // 23
+	0x7FFF, 0x03FF, 0x001F, 0x0000, // 24
+	0x03FF, 0x001F, 0x000C, 0x0000, // 25
+	0x7FFF, 0x033F, 0x0193, 0x0000, // 26
+	0x0000, 0x4200, 0x037F, 0x7FFF, // 27
+	0x7FFF, 0x7E8C, 0x7C00, 0x0000, // 28
+	0x7FFF, 0x1BEF, 0x6180, 0x0000, // 29
+}
+
+// cgbCombination selects the colors for the background and each sprite palette, as offsets into cgbPaletteColors.
+// Most start on a palette boundary, but a few start part way through a palette, as in the boot ROM.
+type cgbCombination struct {
+	obj0, obj1, bg int
+}
+
+// pal returns the offset of the nth palette in cgbPaletteColors
+func pal(n int) int {
+	return n * 4
+}
+
+// cgbCombinations are the boot ROM's palette combinations, indexed by cgbPaletteIndices
+var cgbCombinations = [...]cgbCombination{
+	{pal(4), pal(4), pal(29)},         // 0, default
+	{pal(18), pal(18), pal(18)},       // 1
+	{pal(20), pal(20), pal(20)},       // 2
+	{pal(24), pal(24), pal(24)},       // 3
+	{pal(9), pal(9), pal(9)},          // 4
+	{pal(0), pal(0), pal(0)},          // 5
+	{pal(27), pal(27), pal(27)},       // 6
+	{pal(5), pal(5), pal(5)},          // 7
+	{pal(12), pal(12), pal(12)},       // 8
+	{pal(26), pal(26), pal(26)},       // 9
+	{pal(16), pal(8), pal(8)},         // 10
+	{pal(4), pal(28), pal(28)},        // 11
+	{pal(4), pal(2), pal(2)},          // 12
+	{pal(3), pal(4), pal(4)},          // 13
+	{pal(4), pal(29), pal(29)},        // 14
+	{pal(28), pal(4), pal(28)},        // 15
+	{pal(2), pal(17), pal(2)},         // 16
+	{pal(16), pal(16), pal(8)},        // 17
+	{pal(4), pal(4), pal(7)},          // 18
+	{pal(4), pal(4), pal(18)},         // 19
+	{pal(4), pal(4), pal(20)},         // 20
+	{pal(19), pal(19), pal(9)},        // 21
+	{pal(4) - 1, pal(4) - 1, pal(11)}, // 22
+	{pal(17), pal(17), pal(2)},        // 23
+	{pal(4), pal(4), pal(2)},          // 24
+	{pal(4), pal(4), pal(3)},          // 25
+	{pal(28), pal(28), pal(0)},        // 26
+	{pal(3), pal(3), pal(0)},          // 27
+	{pal(0), pal(0), pal(1)},          // 28
+	{pal(18), pal(22), pal(18)},       // 29
+	{pal(20), pal(22), pal(20)},       // 30
+	{pal(24), pal(22), pal(24)},       // 31
+	{pal(16), pal(22), pal(8)},        // 32
+	{pal(17), pal(4), pal(13)},        // 33
+	{pal(28) - 1, pal(0), pal(14)},    // 34
+	{pal(28) - 1, pal(4), pal(15)},    // 35
+	{pal(19), pal(22), pal(9)},        // 36
+	{pal(16), pal(28), pal(10)},       // 37
+	{pal(4), pal(23), pal(28)},        // 38
+	{pal(17), pal(22), pal(2)},        // 39
+	{pal(4), pal(0), pal(2)},          // 40
+	{pal(4), pal(28), pal(3)},         // 41
+	{pal(28), pal(3), pal(0)},         // 42
+	{pal(3), pal(28), pal(4)},         // 43
+	{pal(21), pal(28), pal(4)},        // 44
+	{pal(3), pal(28), pal(0)},         // 45
+	{pal(25), pal(3), pal(28)},        // 46
+	{pal(0), pal(28), pal(8)},         // 47
+	{pal(4), pal(3), pal(28)},         // 48
+	{pal(28), pal(3), pal(6)},         // 49
+	{pal(4), pal(28), pal(29)},        // 50
+}
+
+// cgbTitleChecksums are the title checksums of the Nintendo games in the boot ROM's colorization table.
+// Checksums from cgbFirstDuplicate on are shared by several games, and are told apart by cgbFourthLetters.
+var cgbTitleChecksums = [...]byte{
+	0x00, 0x88, 0x16, 0x36, 0xD1, 0xDB, 0xF2, 0x3C, 0x8C, 0x92, 0x3D, 0x5C, 0x58, 0xC9, 0x3E, 0x70,
+	0x1D, 0x59, 0x69, 0x19, 0x35, 0xA8, 0x14, 0xAA, 0x75, 0x95, 0x99, 0x34, 0x6F, 0x15, 0xFF, 0x97,
+	0x4B, 0x90, 0x17, 0x10, 0x39, 0xF7, 0xF6, 0xA2, 0x49, 0x4E, 0x43, 0x68, 0xE0, 0x8B, 0xF0, 0xCE,
+	0x0C, 0x29, 0xE8, 0xB7, 0x86, 0x9A, 0x52, 0x01, 0x9D, 0x71, 0x9C, 0xBD, 0x5D, 0x6D, 0x67, 0x3F,
+	0x6B,
+	// Checksums shared by several games
+	0xB3, 0x46, 0x28, 0xA5, 0xC6, 0xD3, 0x27, 0x61, 0x18, 0x66, 0x6A, 0xBF, 0x0D, 0xF4,
+	0xB3, 0x46, 0x28, 0xA5, 0xC6, 0xD3, 0x27, 0x61, 0x18, 0x66, 0x6A, 0xBF, 0x0D, 0xF4,
+	0xB3,
+}
+
+// cgbFirstDuplicate is the index of the first checksum in cgbTitleChecksums that is shared by several games
+const cgbFirstDuplicate = 65
+
+// cgbFourthLetters are the fourth letters of the titles with shared checksums, from cgbFirstDuplicate on
+const cgbFourthLetters = "BEFAARBEKEK R-URAR INAILICE R"
+
+// cgbPaletteIndices are the palette combinations of the games in cgbTitleChecksums
+var cgbPaletteIndices = [len(cgbTitleChecksums)]byte{
+	0, 4, 5, 35, 34, 3, 31, 15, 10, 5, 19, 36, 7, 37, 30, 44,
+	21, 32, 31, 20, 5, 33, 13, 14, 5, 29, 5, 18, 9, 3, 2, 26,
+	25, 25, 41, 42, 26, 45, 42, 45, 36, 38, 26, 42, 30, 41, 34, 34,
+	5, 42, 6, 5, 33, 25, 42, 42, 40, 2, 16, 25, 42, 42, 5, 0,
+	39,
+	36, 22, 25, 6, 32, 12, 36, 11, 39, 18, 39, 24, 31, 50,
+	17, 46, 6, 27, 0, 47, 41, 41, 0, 0, 19, 34, 23, 18,
+	29,
+}
+
+// cgbDefaultPalette is used by the CGB boot ROM for games not in its colorization table
+var cgbDefaultPalette = cgbPalette(cgbCombinations[0])
+
+// cgbPalette returns the display palette for a palette combination
+func cgbPalette(c cgbCombination) Palette {
+	colors := func(offset int) (ret [4]color.RGBA) {
+		for i := range ret {
+			ret[i] = bgr555(cgbPaletteColors[offset+i])
+		}
+		return
+	}
+
+	return Palette{Name: PaletteAuto, BG: colors(c.bg), OBJ0: colors(c.obj0), OBJ1: colors(c.obj1)}
+}
+
+// bgr555 converts a 15 bit CGB color to RGBA
+func bgr555(c uint16) color.RGBA {
+	scale := func(v uint16) byte {
+		return byte((int(v&0x1F)*255 + 15) / 31)
+	}
+	return color.RGBA{scale(c), scale(c >> 5), scale(c >> 10), 0xFF}
+}
+
+// cgbColorization returns the palette the boot ROM's colorization table has for a 16 byte title.
+// The title checksum is looked up first, and the fourth letter only breaks ties between games sharing a checksum.
+func cgbColorization(title []byte) Palette {
+	var checksum byte
+	for _, b := range title {
+		checksum += b
+	}
+
+	for i, c := range cgbTitleChecksums {
+		if c != checksum {
+			continue
+		}
+		if i >= cgbFirstDuplicate && cgbFourthLetters[i-cgbFirstDuplicate] != title[3] {
+			continue
+		}
+
+		return cgbPalette(cgbCombinations[cgbPaletteIndices[i]])
+	}
+
+	return cgbDefaultPalette
+}
+
+// autoPalette returns the palette the CGB boot ROM selects for a DMG cartridge.
+// Only games licensed by Nintendo are colorized by title. Everything else gets the default palette.
+func autoPalette(rom []byte) Palette {
+	h, err := cartridge.ParseHeader(rom)
+	if err != nil {
+		return cgbDefaultPalette
+	}
+
+	nintendo := h.OldLicenseeCode == 0x01 || (h.OldLicenseeCode == 0x33 && h.NewLicenseeCode == "01")
+	if !nintendo {
+		return cgbDefaultPalette
+	}
+
+	return cgbColorization(rom[0x0134:0x0144])
+}
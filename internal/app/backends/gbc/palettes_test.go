@@ -0,0 +1,164 @@
+package gbc
+
+import (
+	"image/color"
+	"testing"
+)
+
+// titledROM returns a test ROM with the given title and old licensee code
+func titledROM(title string, licensee byte) []byte {
+	rom := testROM()
+	copy(rom[0x0134:0x0144], make([]byte, 16))
+	copy(rom[0x0134:], title)
+	rom[0x014B] = licensee
+	return finalizeHeader(rom)
+}
+
+func TestParsePalette(t *testing.T) {
+	for _, name := range []string{"gray", "green", "Pocket", "LIGHT"} {
+		p, err := ParsePalette(name)
+		if err != nil {
+			t.Errorf("Failed to parse palette %s: %v", name, err)
+		}
+		if p.BG[0] == p.BG[3] {
+			t.Errorf("Expected distinct shades in palette %s", name)
+		}
+	}
+
+	if p, err := ParsePalette("auto"); err != nil || p.Name != PaletteAuto {
+		t.Errorf("Expected the auto palette, got %v (%v)", p.Name, err)
+	}
+
+	p, err := ParsePalette("E0F8D0,#88C070,346856,081820")
+	if err != nil {
+		t.Fatalf("Failed to parse 4 color palette: %v", err)
+	}
+	if p.BG[1] != (color.RGBA{0x88, 0xC0, 0x70, 0xFF}) || p.OBJ0 != p.BG || p.OBJ1 != p.BG {
+		t.Errorf("Expected 4 colors to be used for the background and sprites, got %v", p)
+	}
+
+	p, err = ParsePalette("FFFFFF,AAAAAA,555555,000000,FFFFFF,FF0000,800000,000000,FFFFFF,0000FF,000080,000000")
+	if err != nil {
+		t.Fatalf("Failed to parse 12 color palette: %v", err)
+	}
+	if p.BG[1] != (color.RGBA{0xAA, 0xAA, 0xAA, 0xFF}) || p.OBJ0[1] != (color.RGBA{0xFF, 0, 0, 0xFF}) || p.OBJ1[1] != (color.RGBA{0, 0, 0xFF, 0xFF}) {
+		t.Errorf("Expected 12 colors to be split between BG, OBJ0, and OBJ1, got %v", p)
+	}
+
+	for _, invalid := range []string{"purple", "FFFFFF,000000", "FFFFFF,AAAAAA,555555,00000G", "FFF,AAA,555,000"} {
+		if _, err := ParsePalette(invalid); err == nil {
+			t.Errorf("Expected palette %s to be rejected", invalid)
+		}
+	}
+}
+
+// Shades of the CGB boot ROM's palettes used by the Pokemon games
+var (
+	cgbRed   = [4]color.RGBA{hexColor(0xFFFFFF), hexColor(0xFF8484), hexColor(0x943A3A), hexColor(0x000000)}
+	cgbGreen = [4]color.RGBA{hexColor(0xFFFFFF), hexColor(0x7BFF31), hexColor(0x008400), hexColor(0x000000)}
+	cgbBlue  = [4]color.RGBA{hexColor(0xFFFFFF), hexColor(0x63A5FF), hexColor(0x0000FF), hexColor(0x000000)}
+)
+
+func TestAutoPalette(t *testing.T) {
+	if p := autoPalette(titledROM("POKEMON BLUE", 0x01)); p.BG != cgbBlue || p.OBJ0 != cgbRed || p.OBJ1 != cgbBlue {
+		t.Errorf("Expected the blue colorization for POKEMON BLUE, got %v", p)
+	}
+	if p := autoPalette(titledROM("POKEMON RED", 0x01)); p.BG != cgbRed || p.OBJ0 != cgbGreen || p.OBJ1 != cgbRed {
+		t.Errorf("Expected the red colorization for POKEMON RED, got %v", p)
+	}
+
+	tetris := [4]color.RGBA{hexColor(0xFFFFFF), hexColor(0xFFFF00), hexColor(0xFF0000), hexColor(0x000000)}
+	if p := autoPalette(titledROM("TETRIS", 0x01)); p.BG != tetris || p.OBJ0 != tetris {
+		t.Errorf("Expected the yellow and red colorization for TETRIS, got %v", p)
+	}
+
+	// The default palette is white, green, blue, and black, with red sprites
+	if cgbDefaultPalette.BG[2] != hexColor(0x0063C5) || cgbDefaultPalette.OBJ0 != cgbRed {
+		t.Errorf("Unexpected default colorization %v", cgbDefaultPalette)
+	}
+
+	// Only Nintendo titles are colorized
+	if p := autoPalette(titledROM("POKEMON BLUE", 0x08)); p.BG != cgbDefaultPalette.BG {
+		t.Errorf("Expected the default palette for a title from another licensee, got %v", p)
+	}
+
+	if p := autoPalette(titledROM("TESTROM", 0x01)); p.BG != cgbDefaultPalette.BG {
+		t.Errorf("Expected the default palette for an unknown title, got %v", p)
+	}
+}
+
+func TestAutoPaletteFourthLetter(t *testing.T) {
+	if n := len(cgbTitleChecksums) - cgbFirstDuplicate; len(cgbFourthLetters) != n {
+		t.Fatalf("Expected a fourth letter for each of the %d shared checksums, got %d", n, len(cgbFourthLetters))
+	}
+
+	// Titles sharing a checksum are told apart by the fourth letter
+	if p := autoPalette(titledROM("DONKEYKONGLAND", 0x01)); p.BG == cgbDefaultPalette.BG {
+		t.Errorf("Expected DONKEYKONGLAND to be colorized")
+	}
+	if p := autoPalette(titledROM("DONEKYKONGLAND", 0x01)); p.BG != cgbDefaultPalette.BG {
+		t.Errorf("Expected a shared checksum with another fourth letter to get the default palette, got %v", p)
+	}
+	if golf, galaga := autoPalette(titledROM("GOLF", 0x01)), autoPalette(titledROM("GALAGA&GALAXIAN", 0x01)); golf == galaga {
+		t.Errorf("Expected GOLF and GALAGA&GALAXIAN to be told apart, got %v for both", golf)
+	}
+
+	// Unique checksums ignore the fourth letter
+	if p := autoPalette(titledROM("TETIRS", 0x01)); p != autoPalette(titledROM("TETRIS", 0x01)) {
+		t.Errorf("Expected a unique checksum to match regardless of the fourth letter, got %v", p)
+	}
+}
+
+func TestPPUPaletteRegisters(t *testing.T) {
+	mmu := NewMMU(nil)
+	ppu := NewPPU(mmu)
+	mmu.ppu = ppu
+
+	// Palette registers hold the written value
+	for addr, val := range map[uint16]byte{0xFF47: 0x1B, 0xFF48: 0xD2, 0xFF49: 0x6C} {
+		mmu.Write(addr, val)
+		if got := mmu.Read(addr); got != val {
+			t.Errorf("Expected palette register %#04x to read %#02x, got %#02x", addr, val, got)
+		}
+	}
+
+	// Shades are mapped to colors through the display palette
+	green, _ := ParsePalette("green")
+	ppu.palette = green
+	if got := ppu.bgColor(0); got != green.BG[3] {
+		t.Errorf("Expected BG value 0 to map to the darkest shade, got %v", got)
+	}
+	if got := ppu.spriteColor(false, 1); got != green.OBJ0[0] {
+		t.Errorf("Expected OBJ0 value 1 to map to the lightest shade, got %v", got)
+	}
+	if got := ppu.spriteColor(true, 1); got != green.OBJ1[3] {
+		t.Errorf("Expected OBJ1 value 1 to map to the darkest shade, got %v", got)
+	}
+}
+
+func TestGBCNextPalette(t *testing.T) {
+	gbc := NewGBC(Config{SpeedFactor: 1}, testROM(), nil)
+	if gbc.ppu.palette.Name != PaletteGray.Name {
+		t.Errorf("Expected the gray palette by default, got %s", gbc.ppu.palette.Name)
+	}
+
+	// The hotkey cycles through every palette, and back to the start
+	seen := map[string]bool{}
+	for i := 0; i < len(palettePresets)+1; i++ {
+		seen[gbc.NextPalette()] = true
+	}
+	if len(seen) != len(palettePresets)+1 || gbc.ppu.palette.Name != PaletteGray.Name {
+		t.Errorf("Expected to cycle through the presets and auto palette, got %v", seen)
+	}
+
+	custom, _ := ParsePalette("E0F8D0,88C070,346856,081820")
+	gbc = NewGBC(Config{SpeedFactor: 1, Palette: custom}, testROM(), nil)
+	if gbc.ppu.palette != custom {
+		t.Errorf("Expected the configured palette, got %s", gbc.ppu.palette.Name)
+	}
+
+	gbc = NewGBC(Config{SpeedFactor: 1, Palette: Palette{Name: PaletteAuto}}, titledROM("POKEMON RED", 0x01), nil)
+	if gbc.ppu.palette.BG != cgbRed {
+		t.Errorf("Expected the auto palette to be resolved from the cartridge, got %v", gbc.ppu.palette)
+	}
+}
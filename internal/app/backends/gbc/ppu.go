@@ -1,22 +1,64 @@
 package gbc
 
 import (
+	"fmt"
 	"image/color"
+	"strings"
 
 	"github.com/omstrumpf/goemu/internal/app/backends/gbc/interrupts"
+	"github.com/omstrumpf/goemu/internal/app/backends/gbc/state"
 	"github.com/omstrumpf/goemu/internal/app/log"
 )
 
+// Renderer selects how the PPU draws the screen
+type Renderer int
+
+// Renderers
+const (
+	RendererScanline Renderer = iota // Draws each line at once at the end of mode 3. Fast, but ignores mid-line register writes.
+	RendererFIFO                     // Draws each pixel in turn through the pixel FIFO, with variable mode 3 timing
+)
+
+func (r Renderer) String() string {
+	switch r {
+	case RendererScanline:
+		return "scanline"
+	case RendererFIFO:
+		return "fifo"
+	default:
+		return "UNKNOWN"
+	}
+}
+
+// ParseRenderer parses a renderer name, as returned by Renderer.String
+func ParseRenderer(name string) (Renderer, error) {
+	for _, r := range []Renderer{RendererScanline, RendererFIFO} {
+		if strings.EqualFold(name, r.String()) {
+			return r, nil
+		}
+	}
+
+	return RendererScanline, fmt.Errorf("unknown renderer %q", name)
+}
+
 // PPU represents the gameboy's graphics processing unit.
 type PPU struct {
 	mmu *MMU // Memory Management Unit
 
 	oam *oam // Object Attribute Memory (sprites)
 
-	framebuffer    []color.RGBA  // Frame Buffer
-	bgPalette      [4]color.RGBA // Background Color Palette
-	spritePalette0 [4]color.RGBA // Sprite Color Palette 0
-	spritePalette1 [4]color.RGBA // Sprite Color Palette 1
+	renderer Renderer  // How lines are drawn
+	fifo     pixelFIFO // Pixel FIFO, for the FIFO renderer
+
+	framebuffer []color.RGBA // Frame Buffer
+	palette     Palette      // Display colors for DMG shades
+	bgp         byte         // Background Palette register: shade for each color value
+	obp0        byte         // Sprite Palette 0 register
+	obp1        byte         // Sprite Palette 1 register
+
+	cgb                bool          // CGB mode: enables color palettes, BG map attributes, and VRAM bank 1
+	bgColorPalette     colorPalettes // CGB Background Color Palettes
+	spriteColorPalette colorPalettes // CGB Sprite Color Palettes
 
 	// Control Registers
 	lcdEnable    bool // Enables the entire screen
@@ -28,10 +70,14 @@ type PPU struct {
 	spriteEnable bool // Enables rendering sprites
 	bgEnable     bool // Enables rendering the background
 
-	mode        byte // Mode Number (0: HBLANK, 1: VBLANK, 2: OAM, 3: VRAM)
-	timeInMode  int  // Number of clock cycles spent in the current mode
-	line        byte // Line currently being processed
-	lineCompare byte // Target line for interrupt
+	mode       byte // Mode Number (0: HBLANK, 1: VBLANK, 2: OAM, 3: VRAM)
+	timeInMode int  // Number of clock cycles spent in the current mode
+	hblankTime int  // Length of HBLANK on the current line, the remainder after the variable length mode 3
+
+	lineZeroQuirk bool // On the first line after the LCD is enabled, which skips OAM mode
+	statLine      bool // State of the STAT interrupt line, the OR of all enabled STAT interrupt sources
+	line          byte // Line currently being processed
+	lineCompare   byte // Target line for interrupt
 
 	interrupt0   bool // Trigger an interrupt on entering mode 0
 	interrupt1   bool // Trigger an interrupt on entering mode 1
@@ -42,6 +88,10 @@ type PPU struct {
 	bgScrollY  byte // Background scroll Y
 	wScrollXm7 byte // Window scroll X, minus 7
 	wScrollY   byte // Window scroll Y
+
+	windowLine     byte // Internal window line counter: the window row drawn on the next line with the window
+	windowY        bool // LY has matched WY this frame, so the window can be drawn
+	windowFullLine bool // The window was triggered at WX=166 on the previous line, so it spans this entire line
 }
 
 // NewPPU constructs a valid PPU struct
@@ -53,47 +103,46 @@ func NewPPU(mmu *MMU) *PPU {
 	ppu.framebuffer = make([]color.RGBA, ScreenHeight*ScreenWidth)
 	ppu.clearScrean()
 
-	ppu.bgPalette = [4]color.RGBA{
-		{255, 255, 255, 0xFF},
-		{192, 192, 192, 0xFF},
-		{96, 96, 96, 0xFF},
-		{0, 0, 0, 0xFF},
-	}
+	ppu.palette = PaletteGray
+	ppu.bgp = 0xE4
 
 	ppu.mode = 2 // Start in OAM mode
+	ppu.hblankTime = 50
+
+	ppu.fifo.ppu = ppu
 
 	ppu.oam = new(oam)
 
 	return ppu
 }
 
-// RunForClocks runs the PPU for the given number of clock cycles
+// RunForClocks runs the PPU for the given number of clock cycles. The PPU is idle while the LCD is off.
 func (ppu *PPU) RunForClocks(clocks int) {
+	if !ppu.lcdEnable {
+		return
+	}
+
 	for c := 0; c < clocks; c++ {
 		ppu.timeInMode++
 
 		switch ppu.mode {
 		case 0: // HBLANK
-			if ppu.timeInMode == 50 {
+			if ppu.lineZeroQuirk {
+				// The first line after the LCD is enabled has no OAM mode. It starts in HBLANK, and goes straight to VRAM.
+				if ppu.timeInMode == 20 {
+					ppu.startMode3()
+				}
+			} else if ppu.timeInMode == ppu.hblankTime {
 				ppu.timeInMode = 0
 
 				ppu.line++
 
-				if ppu.interruptLYC && ppu.line == ppu.lineCompare {
-					ppu.mmu.interrupts.Request(interrupts.LCDBit)
-				}
-
 				if ppu.line == 144 {
 					ppu.mode = 1
+					ppu.resetWindow()
 					ppu.mmu.interrupts.Request(interrupts.VBlankBit)
-					if ppu.interrupt1 {
-						ppu.mmu.interrupts.Request(interrupts.LCDBit)
-					}
 				} else {
 					ppu.mode = 2
-					if ppu.interrupt2 {
-						ppu.mmu.interrupts.Request(interrupts.LCDBit)
-					}
 				}
 			}
 		case 1: // VBLANK
@@ -102,49 +151,156 @@ func (ppu *PPU) RunForClocks(clocks int) {
 
 				ppu.mode = 2
 				ppu.line = 0
-
-				if ppu.interrupt2 {
-					ppu.mmu.interrupts.Request(interrupts.LCDBit)
-				}
 			} else {
-				if ppu.interruptLYC && ppu.lineCompare == 143 {
-					ppu.mmu.interrupts.Request(interrupts.LCDBit)
-				}
-				ppu.line = byte(144 + ppu.timeInMode/144)
+				ppu.line = byte(144 + ppu.timeInMode/114)
 			}
 		case 2: // OAM
 			if ppu.timeInMode == 21 {
-				ppu.timeInMode = 0
-
-				ppu.mode = 3
+				ppu.startMode3()
 			}
 		case 3: // VRAM
-			if ppu.timeInMode == 43 {
+			if ppu.runMode3() {
+				ppu.hblankTime = 114 - 21 - ppu.timeInMode
 				ppu.timeInMode = 0
 
 				ppu.mode = 0
 
-				if ppu.interrupt0 {
-					ppu.mmu.interrupts.Request(interrupts.LCDBit)
+				if ppu.renderer == RendererScanline {
+					ppu.renderLine()
 				}
 
-				ppu.renderLine()
+				ppu.mmu.hdma.HBlank()
 			}
 		}
+
+		ppu.updateSTAT()
 	}
 }
 
-func (ppu *PPU) renderLine() {
-	if !ppu.lcdEnable {
-		ppu.clearScrean()
-		return
+// startMode3 enters VRAM mode
+func (ppu *PPU) startMode3() {
+	ppu.timeInMode = 0
+
+	ppu.mode = 3
+	ppu.lineZeroQuirk = false
+
+	if ppu.renderer == RendererFIFO {
+		ppu.fifo.startLine()
+	}
+}
+
+// ly returns the value of the LY register. On line 153, LY reads 153 for the first clock, and 0 for the rest of the line.
+func (ppu *PPU) ly() byte {
+	if ppu.line == 153 && ppu.timeInMode%114 >= 1 {
+		return 0
+	}
+
+	return ppu.line
+}
+
+// updateSTAT recomputes the STAT interrupt line, and requests an interrupt on its rising edge.
+// All enabled sources are OR'd together, so a source going high while another is already high does not interrupt.
+func (ppu *PPU) updateSTAT() {
+	line := (ppu.interruptLYC && ppu.ly() == ppu.lineCompare) ||
+		(ppu.interrupt0 && ppu.mode == 0) ||
+		(ppu.interrupt1 && ppu.mode == 1) ||
+		(ppu.interrupt2 && ppu.mode == 2)
+
+	if line && !ppu.statLine {
+		ppu.mmu.interrupts.Request(interrupts.LCDBit)
 	}
 
+	ppu.statLine = line
+}
+
+// turnOn starts the PPU at the beginning of line 0, when the LCD is enabled
+func (ppu *PPU) turnOn() {
+	ppu.line = 0
+	ppu.mode = 0
+	ppu.timeInMode = 0
+	ppu.lineZeroQuirk = true
+
+	ppu.updateSTAT()
+}
+
+// turnOff stops the PPU, and blanks the screen, when the LCD is disabled
+func (ppu *PPU) turnOff() {
+	ppu.line = 0
+	ppu.mode = 0
+	ppu.timeInMode = 0
+	ppu.lineZeroQuirk = false
+	ppu.statLine = false
+	ppu.resetWindow()
+
+	ppu.blankScreen()
+}
+
+// resetWindow resets the internal window line counter at the end of a frame
+func (ppu *PPU) resetWindow() {
+	ppu.windowLine = 0
+	ppu.windowY = false
+	ppu.windowFullLine = false
+}
+
+// windowStart returns the screen X coordinate where the window starts on the current line, and the number of
+// window pixels hidden off the left edge. Returns false if the window is not drawn on the current line.
+// The window can only be drawn once LY has matched WY during the frame, while the window was enabled.
+func (ppu *PPU) windowStart() (screenX int, discard int, ok bool) {
+	if !ppu.windowEnable {
+		return 0, 0, false
+	}
+
+	if ppu.line == ppu.wScrollY {
+		ppu.windowY = true
+	}
+
+	if !ppu.windowY {
+		return 0, 0, false
+	}
+
+	switch wx := ppu.wScrollXm7; {
+	case ppu.windowFullLine:
+		return 0, 0, true
+	case wx > 166:
+		return 0, 0, false
+	case wx == 0:
+		// The window start overlaps the fine scroll, so the pixels scrolled out shift the window right
+		return 0, int(7 - ppu.bgScrollX&0x7), true
+	case wx < 7:
+		return 0, int(7 - wx), true
+	default:
+		return int(wx) - 7, 0, true
+	}
+}
+
+// finishWindowLine advances the internal window line counter at the end of a line where the window was drawn
+func (ppu *PPU) finishWindowLine(drawn bool) {
+	// The window triggered at WX=166 covers all of the next line
+	ppu.windowFullLine = drawn && !ppu.windowFullLine && ppu.wScrollXm7 == 166
+
+	if drawn {
+		ppu.windowLine++
+	}
+}
+
+// runMode3 runs the renderer for a clock cycle in mode 3, and returns true once the line is complete.
+// Mode 3 lasts 43 clocks with the scanline renderer, and at least as long with the FIFO renderer.
+func (ppu *PPU) runMode3() bool {
+	if ppu.renderer == RendererFIFO {
+		return ppu.fifo.RunForDots(dotsPerClock)
+	}
+
+	return ppu.timeInMode == 43
+}
+
+func (ppu *PPU) renderLine() {
 	// BG/window color values on the current line, for sprite transparency
 	var lineColors [ScreenWidth]uint8
+	// BG/window tiles on the current line with the CGB BG-to-OAM priority attribute set
+	var linePriority [ScreenWidth]bool
 
-	// Draw the background if enabled
-	if ppu.bgEnable {
+	// Draw the background if enabled. In CGB mode the background is always drawn.
+	if ppu.bgEnable || ppu.cgb {
 		// Base VRAM address for the background map
 		var bgAddr uint16
 		if ppu.bgMap {
@@ -156,37 +312,17 @@ func (ppu *PPU) renderLine() {
 		// First tile to be drawn
 		mapY := uint16(ppu.line+ppu.bgScrollY) >> 3
 		mapX := uint16(ppu.bgScrollX >> 3)
-		tileAddr := ppu.getTileAddress(bgAddr, mapX, mapY)
 
 		// Coordinate in the tile to start drawing
 		tileX := ppu.bgScrollX & 0x7
 		tileY := (ppu.line + ppu.bgScrollY) & 0x7
 
-		// Coordinates in the framebuffer to draw
-		screenX := 0
-		screenY := int(ppu.line)
-
-		for screenX < ScreenWidth {
-			// Move to next tile if needed
-			if tileX == 8 {
-				tileX = 0
-				mapX = (mapX + 1) % 32
-				tileAddr = ppu.getTileAddress(bgAddr, mapX, mapY)
-			}
-
-			val := ppu.getTileVal(tileAddr, tileX, tileY)
-			pixel := ppu.bgPalette[val]
-
-			lineColors[screenX] = val
-			ppu.writePixel(pixel, screenX, screenY)
-
-			screenX++
-			tileX++
-		}
+		ppu.drawMapLine(bgAddr, mapX, mapY, tileX, tileY, 0, &lineColors, &linePriority)
 	}
 
 	// Draw the window if enabled
-	if ppu.windowEnable && ppu.line >= ppu.wScrollY {
+	screenX, discard, windowDrawn := ppu.windowStart()
+	if windowDrawn {
 		// Base VRAM address for the window map
 		var wAddr uint16
 		if ppu.windowMap {
@@ -195,53 +331,36 @@ func (ppu *PPU) renderLine() {
 			wAddr = 0x9800
 		}
 
-		// First tile to be drawn
-		mapY := uint16((ppu.line - ppu.wScrollY) >> 3)
+		// First tile to be drawn, from the internal window line counter
+		mapY := uint16(ppu.windowLine >> 3)
 		mapX := uint16(0) // Window always starts from the left
-		tileAddr := ppu.getTileAddress(wAddr, mapX, mapY)
 
 		// Coordinates in the tile to start drawing
-		var tileX, tileY byte
-		// Coordinates in the framebuffer to draw
-		var screenX, screenY int
-		if ppu.wScrollXm7 < 7 {
-			tileX = 7 - ppu.wScrollXm7
-			screenX = 0
-		} else {
-			tileX = 0
-			screenX = int(ppu.wScrollXm7) - 7
-		}
-		tileY = (ppu.line - ppu.wScrollY) & 0x7
-		screenY = int(ppu.line)
-
-		for screenX < ScreenWidth {
-			// Move to next tile if needed
-			if tileX == 8 {
-				tileX = 0
-				mapX = (mapX + 1) % 32
-				tileAddr = ppu.getTileAddress(wAddr, mapX, mapY)
-			}
-
-			val := ppu.getTileVal(tileAddr, tileX, tileY)
-			pixel := ppu.bgPalette[val]
-
-			lineColors[screenX] = val
-			ppu.writePixel(pixel, screenX, screenY)
+		tileX := byte(discard)
+		tileY := ppu.windowLine & 0x7
 
-			screenX++
-			tileX++
-		}
+		ppu.drawMapLine(wAddr, mapX, mapY, tileX, tileY, screenX, &lineColors, &linePriority)
 	}
+	ppu.finishWindowLine(windowDrawn)
 
 	// Draw sprites if enabled
 	if ppu.spriteEnable {
-		visibleSprites := ppu.oam.VisibleSpritesOnLine(ppu.line, ppu.spriteSize)
+		// The highest priority opaque sprite pixel in each column, which alone is compared against the BG
+		var lineSprites [ScreenWidth]*sprite
+		var lineSpriteVals [ScreenWidth]byte
+
+		visibleSprites := ppu.oam.VisibleSpritesOnLine(ppu.line, ppu.spriteSize, ppu.cgb)
 		for _, sprite := range visibleSprites {
-			tileAddr := 0x8000 + (uint16(sprite.tileNum) << 4)
+			// Tall sprites use an even/odd pair of tiles, ignoring bit 0 of the tile number
+			tileNum := sprite.tileNum
+			if ppu.spriteSize {
+				tileNum &^= 1
+			}
+			tileAddr := 0x8000 + (uint16(tileNum) << 4)
 
-			palette := ppu.spritePalette0
-			if sprite.paletteFlag {
-				palette = ppu.spritePalette1
+			var bank byte
+			if ppu.cgb && sprite.tileBank {
+				bank = 1
 			}
 
 			tileY := ppu.line - (sprite.yPos - 16)
@@ -254,7 +373,6 @@ func (ppu *PPU) renderLine() {
 			}
 
 			screenX := int(sprite.xPos) - 8
-			screenY := int(ppu.line)
 			for x := byte(0); x < 8; x++ {
 				if screenX >= 0 && screenX < 160 {
 
@@ -263,11 +381,10 @@ func (ppu *PPU) renderLine() {
 						tileX = 7 - tileX
 					}
 
-					val := ppu.getTileVal(tileAddr, tileX, tileY)
-
-					if val != 0 && (!sprite.priority || (lineColors[screenX] == 0)) {
-						pixel := palette[val]
-						ppu.writePixel(pixel, screenX, screenY)
+					// Sprites are in drawing order, so a later opaque pixel has priority
+					if val := ppu.getBankedTileVal(bank, tileAddr, tileX, tileY); val != 0 {
+						lineSprites[screenX] = sprite
+						lineSpriteVals[screenX] = val
 					}
 
 				}
@@ -275,21 +392,105 @@ func (ppu *PPU) renderLine() {
 			}
 
 		}
+
+		screenY := int(ppu.line)
+		for screenX, sprite := range lineSprites {
+			if sprite == nil || !ppu.spriteVisible(sprite.priority, lineColors[screenX], linePriority[screenX]) {
+				continue
+			}
+
+			val := lineSpriteVals[screenX]
+
+			var pixel color.RGBA
+			if ppu.cgb {
+				pixel = ppu.spriteColorPalette.color(sprite.paletteNum, val)
+			} else {
+				pixel = ppu.spriteColor(sprite.paletteFlag, val)
+			}
+			ppu.writePixel(pixel, screenX, screenY)
+		}
 	}
 
 }
 
+// drawMapLine draws a line of background or window tiles from the map at mapAddr, starting at the
+// given map and tile coordinates, from screenX to the right edge of the screen
+func (ppu *PPU) drawMapLine(mapAddr uint16, mapX uint16, mapY uint16, tileX byte, tileY byte, screenX int, lineColors *[ScreenWidth]uint8, linePriority *[ScreenWidth]bool) {
+	screenY := int(ppu.line)
+
+	tileAddr := ppu.getTileAddress(mapAddr, mapX, mapY)
+	attributes := ppu.getTileAttributes(mapAddr, mapX, mapY)
+
+	for screenX < ScreenWidth {
+		// Move to next tile if needed
+		if tileX == 8 {
+			tileX = 0
+			mapX = (mapX + 1) % 32
+			tileAddr = ppu.getTileAddress(mapAddr, mapX, mapY)
+			attributes = ppu.getTileAttributes(mapAddr, mapX, mapY)
+		}
+
+		var val byte
+		var pixel color.RGBA
+		if ppu.cgb {
+			x, y := tileX, tileY
+			if attributes&0x20 != 0 { // Horizontal flip
+				x = 7 - x
+			}
+			if attributes&0x40 != 0 { // Vertical flip
+				y = 7 - y
+			}
+
+			val = ppu.getBankedTileVal((attributes>>3)&1, tileAddr, x, y)
+			pixel = ppu.bgColorPalette.color(attributes&0x07, val)
+			linePriority[screenX] = (attributes&0x80 != 0)
+		} else {
+			val = ppu.getTileVal(tileAddr, tileX, tileY)
+			pixel = ppu.bgColor(val)
+		}
+
+		lineColors[screenX] = val
+		ppu.writePixel(pixel, screenX, screenY)
+
+		screenX++
+		tileX++
+	}
+}
+
+// spriteVisible returns whether a sprite pixel with the given OBJ-to-BG priority flag is drawn over a BG/window
+// pixel with the given color value and CGB BG-to-OAM priority attribute
+func (ppu *PPU) spriteVisible(spritePriority bool, bgVal byte, bgPriority bool) bool {
+	if bgVal == 0 {
+		return true
+	}
+
+	if ppu.cgb {
+		// In CGB mode, LCDC bit 0 is a master priority switch. When clear, sprites are always on top.
+		if !ppu.bgEnable {
+			return true
+		}
+		return !spritePriority && !bgPriority
+	}
+
+	return !spritePriority
+}
+
 // writePixel writes the given RGBA value into the framebuffer at coordinates (x, y)
 func (ppu *PPU) writePixel(val color.RGBA, x int, y int) {
 	ppu.framebuffer[(y*ScreenWidth)+x] = val
 }
 
-// getTileVal returns the 2 bit value for a tile pixel
+// getTileVal returns the 2 bit value for a tile pixel in VRAM bank 0
 func (ppu *PPU) getTileVal(tileAddr uint16, tileX byte, tileY byte) byte {
+	return ppu.getBankedTileVal(0, tileAddr, tileX, tileY)
+}
+
+// getBankedTileVal returns the 2 bit value for a tile pixel in the given VRAM bank
+func (ppu *PPU) getBankedTileVal(bank byte, tileAddr uint16, tileX byte, tileY byte) byte {
 	bit := byte(1 << (7 - tileX))
 
-	tileLo := ppu.mmu.Read(tileAddr + uint16(tileY*2))
-	tileHi := ppu.mmu.Read(tileAddr + uint16(tileY*2) + 1)
+	tileLo := ppu.mmu.ReadVRAM(bank, tileAddr+uint16(tileY*2))
+	tileHi := ppu.mmu.ReadVRAM(bank, tileAddr+uint16(tileY*2)+1)
 
 	val := byte(0)
 	if tileLo&bit > 0 {
@@ -311,14 +512,31 @@ func (ppu *PPU) getTileAddress(baseAddr uint16, x uint16, y uint16) uint16 {
 	mapAddr := baseAddr + (y << 5) + x
 
 	if ppu.tileSelect {
-		tileNum := uint16(ppu.mmu.Read(mapAddr))
+		tileNum := uint16(ppu.mmu.ReadVRAM(0, mapAddr))
 		return uint16(0x8000) + (tileNum << 4)
 	}
 
-	tileNum := int16(int8(ppu.mmu.Read(mapAddr)))
+	tileNum := int16(int8(ppu.mmu.ReadVRAM(0, mapAddr)))
 	return uint16(int32(0x9000) + int32(tileNum<<4))
 }
 
+// getTileAttributes returns the CGB attributes of the tile at the given 32x32 BG coordinates, in the given map.
+// Always 0 outside of CGB mode.
+func (ppu *PPU) getTileAttributes(baseAddr uint16, x uint16, y uint16) byte {
+	if !ppu.cgb {
+		return 0
+	}
+
+	return ppu.mmu.ReadVRAM(1, baseAddr+(y<<5)+x)
+}
+
+// blankScreen sets the framebuffer to the blank color of a disabled LCD
+func (ppu *PPU) blankScreen() {
+	for i := range ppu.framebuffer {
+		ppu.framebuffer[i] = ppu.palette.BG[0]
+	}
+}
+
 // clearScrean sets the framebuffer to all black
 func (ppu *PPU) clearScrean() {
 	for i := range ppu.framebuffer {
@@ -326,6 +544,80 @@ func (ppu *PPU) clearScrean() {
 	}
 }
 
+// SaveState writes the PPU registers, OAM, palettes, and framebuffer to the save state
+func (ppu *PPU) SaveState(w *state.Writer) {
+	ppu.oam.SaveState(w)
+
+	w.Raw(packColors(ppu.framebuffer))
+	w.U8(ppu.bgp)
+	w.U8(ppu.obp0)
+	w.U8(ppu.obp1)
+
+	ppu.bgColorPalette.SaveState(w)
+	ppu.spriteColorPalette.SaveState(w)
+
+	w.U8(ppu.Read(0xFF40)) // LCDC
+	w.U8(ppu.Read(0xFF41)) // STAT
+
+	w.U8(ppu.mode)
+	w.Int(ppu.timeInMode)
+	w.Int(ppu.hblankTime)
+	w.Bool(ppu.lineZeroQuirk)
+	w.Bool(ppu.statLine)
+	w.U8(ppu.line)
+	w.U8(ppu.lineCompare)
+
+	w.U8(ppu.bgScrollX)
+	w.U8(ppu.bgScrollY)
+	w.U8(ppu.wScrollXm7)
+	w.U8(ppu.wScrollY)
+
+	w.U8(ppu.windowLine)
+	w.Bool(ppu.windowY)
+	w.Bool(ppu.windowFullLine)
+
+	ppu.fifo.SaveState(w)
+}
+
+// LoadState restores the PPU registers, OAM, palettes, and framebuffer from the save state
+func (ppu *PPU) LoadState(r *state.Reader) {
+	ppu.oam.LoadState(r)
+
+	pixels := make([]byte, 4*len(ppu.framebuffer))
+	r.Raw(pixels)
+	if r.Err() == nil {
+		unpackColors(ppu.framebuffer, pixels)
+	}
+	ppu.bgp = r.U8()
+	ppu.obp0 = r.U8()
+	ppu.obp1 = r.U8()
+
+	ppu.bgColorPalette.LoadState(r)
+	ppu.spriteColorPalette.LoadState(r)
+
+	ppu.setLCDC(r.U8()) // LCDC
+	ppu.setSTAT(r.U8()) // STAT
+
+	ppu.mode = r.U8()
+	ppu.timeInMode = r.Int()
+	ppu.hblankTime = r.Int()
+	ppu.lineZeroQuirk = r.Bool()
+	ppu.statLine = r.Bool()
+	ppu.line = r.U8()
+	ppu.lineCompare = r.U8()
+
+	ppu.bgScrollX = r.U8()
+	ppu.bgScrollY = r.U8()
+	ppu.wScrollXm7 = r.U8()
+	ppu.wScrollY = r.U8()
+
+	ppu.windowLine = r.U8()
+	ppu.windowY = r.Bool()
+	ppu.windowFullLine = r.Bool()
+
+	ppu.fifo.LoadState(r)
+}
+
 func (ppu *PPU) Read(addr uint16) byte {
 	switch addr {
 	case 0xFF40:
@@ -358,7 +650,7 @@ func (ppu *PPU) Read(addr uint16) byte {
 	case 0xFF41:
 		var ret byte
 		ret = ppu.mode
-		if ppu.line == ppu.lineCompare {
+		if ppu.ly() == ppu.lineCompare {
 			ret |= 0x04
 		}
 		if ppu.interrupt0 {
@@ -379,34 +671,33 @@ func (ppu *PPU) Read(addr uint16) byte {
 	case 0xFF43:
 		return ppu.bgScrollX
 	case 0xFF44:
-		return ppu.line
+		return ppu.ly()
 	case 0xFF45:
 		return ppu.lineCompare
 	case 0xFF47:
-		var ret byte
-		ret |= (rgbaToI(ppu.bgPalette[0])) << 6
-		ret |= (rgbaToI(ppu.bgPalette[1])) << 4
-		ret |= (rgbaToI(ppu.bgPalette[2])) << 2
-		ret |= (rgbaToI(ppu.bgPalette[3]))
-		return ret
+		return ppu.bgp
 	case 0xFF48:
-		var ret byte
-		ret |= (rgbaToI(ppu.spritePalette0[0])) << 6
-		ret |= (rgbaToI(ppu.spritePalette0[1])) << 4
-		ret |= (rgbaToI(ppu.spritePalette0[2])) << 2
-		ret |= (rgbaToI(ppu.spritePalette0[3]))
-		return ret
+		return ppu.obp0
 	case 0xFF49:
-		var ret byte
-		ret |= (rgbaToI(ppu.spritePalette1[0])) << 6
-		ret |= (rgbaToI(ppu.spritePalette1[1])) << 4
-		ret |= (rgbaToI(ppu.spritePalette1[2])) << 2
-		ret |= (rgbaToI(ppu.spritePalette1[3]))
-		return ret
+		return ppu.obp1
 	case 0xFF4A:
 		return ppu.wScrollY
 	case 0xFF4B:
 		return ppu.wScrollXm7
+	case 0xFF68, 0xFF69, 0xFF6A, 0xFF6B:
+		if !ppu.cgb {
+			return 0xFF
+		}
+		switch addr {
+		case 0xFF68:
+			return ppu.bgColorPalette.readIndex()
+		case 0xFF69:
+			return ppu.bgColorPalette.readData()
+		case 0xFF6A:
+			return ppu.spriteColorPalette.readIndex()
+		case 0xFF6B:
+			return ppu.spriteColorPalette.readData()
+		}
 	}
 
 	log.Warningf("Encountered read with unknown PPU control address: %#04x", addr)
@@ -416,20 +707,22 @@ func (ppu *PPU) Read(addr uint16) byte {
 func (ppu *PPU) Write(addr uint16, val byte) {
 	switch addr {
 	case 0xFF40:
-		ppu.lcdEnable = (val&0x80 != 0)
-		ppu.windowMap = (val&0x40 != 0)
-		ppu.windowEnable = (val&0x20 != 0)
-		ppu.tileSelect = (val&0x10 != 0)
-		ppu.bgMap = (val&0x08 != 0)
-		ppu.spriteSize = (val&0x04 != 0)
-		ppu.spriteEnable = (val&0x02 != 0)
-		ppu.bgEnable = (val&0x01 != 0)
+		wasEnabled := ppu.lcdEnable
+		ppu.setLCDC(val)
+
+		if ppu.lcdEnable && !wasEnabled {
+			log.Tracef("LCD enabled")
+			ppu.turnOn()
+		} else if !ppu.lcdEnable && wasEnabled {
+			log.Tracef("LCD disabled")
+			ppu.turnOff()
+		}
 		return
 	case 0xFF41:
-		ppu.interrupt0 = (val&0x08 != 0)
-		ppu.interrupt1 = (val&0x10 != 0)
-		ppu.interrupt2 = (val&0x20 != 0)
-		ppu.interruptLYC = (val&0x40 != 0)
+		ppu.setSTAT(val)
+		if ppu.lcdEnable {
+			ppu.updateSTAT()
+		}
 		return
 	case 0xFF42:
 		ppu.bgScrollY = val
@@ -442,21 +735,18 @@ func (ppu *PPU) Write(addr uint16, val byte) {
 		return
 	case 0xFF45:
 		ppu.lineCompare = val
+		if ppu.lcdEnable {
+			ppu.updateSTAT()
+		}
 		return
 	case 0xFF47:
-		for i := uint8(0); i < 4; i++ {
-			ppu.bgPalette[i] = iToRGBA((val >> (i * 2)) & 3)
-		}
+		ppu.bgp = val
 		return
 	case 0xFF48:
-		for i := uint8(0); i < 4; i++ {
-			ppu.spritePalette0[i] = iToRGBA((val >> (i * 2)) & 3)
-		}
+		ppu.obp0 = val
 		return
 	case 0xFF49:
-		for i := uint8(0); i < 4; i++ {
-			ppu.spritePalette1[i] = iToRGBA((val >> (i * 2)) & 3)
-		}
+		ppu.obp1 = val
 		return
 	case 0xFF4A:
 		ppu.wScrollY = val
@@ -464,11 +754,46 @@ func (ppu *PPU) Write(addr uint16, val byte) {
 	case 0xFF4B:
 		ppu.wScrollXm7 = val
 		return
+	case 0xFF68, 0xFF69, 0xFF6A, 0xFF6B:
+		if !ppu.cgb {
+			return
+		}
+		switch addr {
+		case 0xFF68:
+			ppu.bgColorPalette.writeIndex(val)
+		case 0xFF69:
+			ppu.bgColorPalette.writeData(val)
+		case 0xFF6A:
+			ppu.spriteColorPalette.writeIndex(val)
+		case 0xFF6B:
+			ppu.spriteColorPalette.writeData(val)
+		}
+		return
 	}
 
 	log.Warningf("Encountered write with unknown PPU control address: %#4x", addr)
 }
 
+// setLCDC sets the LCD control flags, without turning the LCD on or off
+func (ppu *PPU) setLCDC(val byte) {
+	ppu.lcdEnable = (val&0x80 != 0)
+	ppu.windowMap = (val&0x40 != 0)
+	ppu.windowEnable = (val&0x20 != 0)
+	ppu.tileSelect = (val&0x10 != 0)
+	ppu.bgMap = (val&0x08 != 0)
+	ppu.spriteSize = (val&0x04 != 0)
+	ppu.spriteEnable = (val&0x02 != 0)
+	ppu.bgEnable = (val&0x01 != 0)
+}
+
+// setSTAT sets the STAT interrupt source flags, without updating the STAT interrupt line
+func (ppu *PPU) setSTAT(val byte) {
+	ppu.interrupt0 = (val&0x08 != 0)
+	ppu.interrupt1 = (val&0x10 != 0)
+	ppu.interrupt2 = (val&0x20 != 0)
+	ppu.interruptLYC = (val&0x40 != 0)
+}
+
 //// Helpers ////
 
 // Returns the min of two ints
@@ -479,34 +804,31 @@ func min(a int, b int) int {
 	return b
 }
 
-// Maps a palette byte value (0-3) to RGBA
-func iToRGBA(val byte) color.RGBA {
-	switch val {
-	case 0:
-		return color.RGBA{255, 255, 255, 0xFF}
-	case 1:
-		return color.RGBA{192, 192, 192, 0xFF}
-	case 2:
-		return color.RGBA{96, 96, 96, 0xFF}
-	case 3:
-		return color.RGBA{0, 0, 0, 0xFF}
+// packColors serializes RGBA colors as 4 bytes each
+func packColors(colors []color.RGBA) []byte {
+	b := make([]byte, 0, 4*len(colors))
+	for _, c := range colors {
+		b = append(b, c.R, c.G, c.B, c.A)
 	}
-
-	return color.RGBA{255, 255, 255, 0xFF}
+	return b
 }
 
-// Maps an RGBA color to palette byte value (0-3)
-func rgbaToI(c color.RGBA) byte {
-	switch c {
-	case color.RGBA{255, 255, 255, 0xFF}:
-		return 0
-	case color.RGBA{192, 192, 192, 0xFF}:
-		return 1
-	case color.RGBA{96, 96, 96, 0xFF}:
-		return 2
-	case color.RGBA{0, 0, 0, 0xFF}:
-		return 3
+// unpackColors deserializes RGBA colors packed by packColors into dst
+func unpackColors(dst []color.RGBA, b []byte) {
+	for i := range dst {
+		dst[i] = color.RGBA{b[i*4], b[i*4+1], b[i*4+2], b[i*4+3]}
 	}
+}
 
-	return 0
+// bgColor returns the display color of a 2 bit background value, through BGP
+func (ppu *PPU) bgColor(val byte) color.RGBA {
+	return ppu.palette.BG[(ppu.bgp>>(val*2))&3]
+}
+
+// spriteColor returns the display color of a 2 bit sprite value, through OBP1 if set, otherwise OBP0
+func (ppu *PPU) spriteColor(obp1 bool, val byte) color.RGBA {
+	if obp1 {
+		return ppu.palette.OBJ1[(ppu.obp1>>(val*2))&3]
+	}
+	return ppu.palette.OBJ0[(ppu.obp0>>(val*2))&3]
 }
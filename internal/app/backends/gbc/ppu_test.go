@@ -3,6 +3,8 @@ package gbc
 import (
 	"image/color"
 	"testing"
+
+	"github.com/omstrumpf/goemu/internal/app/backends/gbc/interrupts"
 )
 
 func TestPPUInit(t *testing.T) {
@@ -19,7 +21,7 @@ func TestPPUInit(t *testing.T) {
 		t.Error("FrameBuffer should initialize to black")
 	}
 
-	if ppu.bgPalette[0] != white || ppu.bgPalette[1] != lightGray || ppu.bgPalette[2] != darkGray || ppu.bgPalette[3] != black {
+	if ppu.bgColor(0) != white || ppu.bgColor(1) != lightGray || ppu.bgColor(2) != darkGray || ppu.bgColor(3) != black {
 		t.Error("Palette should initialize correctly")
 	}
 
@@ -309,17 +311,11 @@ func TestPPURenderLine(t *testing.T) {
 	ppu.line++
 	ppu.bgMap = false
 	ppu.tileSelect = false
-	ppu.bgPalette[3] = color.RGBA{255, 255, 255, 0xFF}
-	ppu.bgPalette[2] = color.RGBA{192, 192, 192, 0xFF}
-	ppu.bgPalette[1] = color.RGBA{96, 96, 96, 0xFF}
-	ppu.bgPalette[0] = color.RGBA{0, 0, 0, 0xFF}
+	mmu.Write(0xFF47, 0x1B)
 	ppu.renderLine()
 
 	// Swapping palette back
-	ppu.bgPalette[0] = color.RGBA{255, 255, 255, 0xFF}
-	ppu.bgPalette[1] = color.RGBA{192, 192, 192, 0xFF}
-	ppu.bgPalette[2] = color.RGBA{96, 96, 96, 0xFF}
-	ppu.bgPalette[3] = color.RGBA{0, 0, 0, 0xFF}
+	mmu.Write(0xFF47, 0xE4)
 
 	// Line 13: Window enabled but scrolled lower
 	ppu.line++
@@ -349,7 +345,7 @@ func TestPPURenderLine(t *testing.T) {
 	// Line 17: Window second tile
 	ppu.line++
 	ppu.wScrollXm7 = 7
-	ppu.wScrollY = 9
+	ppu.windowLine = 8
 	ppu.renderLine()
 
 	// Line 18: Other window map
@@ -490,7 +486,7 @@ func TestPPURenderLine(t *testing.T) {
 		i := 0
 		for i = 0; i < 160; i++ {
 			idx := (l * 160) + i
-			if ppu.framebuffer[idx] != iToRGBA(expected[idx]) {
+			if ppu.framebuffer[idx] != ppu.palette.BG[expected[idx]] {
 				good = false
 				break
 			}
@@ -508,6 +504,7 @@ func TestPPUTiming(t *testing.T) {
 	mmu := NewMMU(nil)
 	ppu := NewPPU(mmu)
 	mmu.ppu = ppu
+	ppu.lcdEnable = true
 
 	ppu.RunForClocks(0)
 	if ppu.mode != 2 {
@@ -570,15 +567,15 @@ func TestPPUTiming(t *testing.T) {
 		t.Errorf("Expected PPU to advance line to 1, got %d", ppu.line)
 	}
 
-	ppu.RunForClocks(16187)
+	ppu.RunForClocks(16301)
 	if ppu.mode != 0 {
 		t.Errorf("Expected PPU to be in mode 0, got %d", ppu.mode)
 	}
 	if ppu.timeInMode != 49 {
 		t.Errorf("Expected PPU to track time in mode correctly as 49, got %d", ppu.timeInMode)
 	}
-	if ppu.line != 142 {
-		t.Errorf("Expected PPU line to be 142, got %d", ppu.line)
+	if ppu.line != 143 {
+		t.Errorf("Expected PPU line to be 143, got %d", ppu.line)
 	}
 
 	ppu.RunForClocks(2)
@@ -620,6 +617,388 @@ func TestPPUControlDevice(t *testing.T) {
 	// TODO test control memory device read/write
 }
 
+// takeLCDInterrupt returns true if the STAT interrupt has been requested, and resets it
+func takeLCDInterrupt(mmu *MMU) bool {
+	requested := mmu.interrupts.Read(0xFF0F)&(1<<interrupts.LCDBit) != 0
+	mmu.interrupts.Reset(interrupts.LCDBit)
+	return requested
+}
+
+// runUntilLine runs the PPU until LY reads the given line
+func runUntilLine(ppu *PPU, line byte) {
+	for ppu.mmu.Read(0xFF44) != line {
+		ppu.RunForClocks(1)
+	}
+}
+
 func TestPPUInterrupts(t *testing.T) {
-	// TODO test get/set of interrupts, and correct firing
+	mmu := NewMMU(nil)
+	ppu := NewPPU(mmu)
+	mmu.ppu = ppu
+
+	// Enabling the LCD skips mode 2 on the first line, so no mode 2 interrupt is requested
+	mmu.Write(0xFF41, 0x20)
+	mmu.Write(0xFF40, 0x91)
+	if mmu.Read(0xFF44) != 0 || mmu.Read(0xFF41)&0x03 != 0 {
+		t.Errorf("Expected LY 0 and mode 0 after enabling the LCD, got LY %d and mode %d", mmu.Read(0xFF44), mmu.Read(0xFF41)&0x03)
+	}
+	ppu.RunForClocks(19)
+	if ppu.mode != 0 {
+		t.Errorf("Expected mode 0 to last 20 clocks after enabling the LCD, got mode %d after 19", ppu.mode)
+	}
+	ppu.RunForClocks(1)
+	if ppu.mode != 3 {
+		t.Errorf("Expected mode 3 to begin 20 clocks after enabling the LCD, got mode %d", ppu.mode)
+	}
+	if takeLCDInterrupt(mmu) {
+		t.Errorf("Expected no mode 2 interrupt on the first line after enabling the LCD")
+	}
+
+	// The next line starts normally, in mode 2
+	runUntilLine(ppu, 1)
+	if ppu.mode != 2 {
+		t.Errorf("Expected line 1 to start in mode 2, got mode %d", ppu.mode)
+	}
+	if !takeLCDInterrupt(mmu) {
+		t.Errorf("Expected mode 2 interrupt at the start of line 1")
+	}
+
+	// With LYC and mode 0 sources both enabled, the STAT line stays high through the line, so HBLANK does not interrupt
+	mmu.Write(0xFF41, 0x48)
+	mmu.Write(0xFF45, 3)
+	runUntilLine(ppu, 3)
+	if !takeLCDInterrupt(mmu) {
+		t.Errorf("Expected LYC interrupt at the start of line 3")
+	}
+	for ppu.mode != 0 {
+		ppu.RunForClocks(1)
+	}
+	if takeLCDInterrupt(mmu) {
+		t.Errorf("Expected no mode 0 interrupt on line 3, while the STAT line is held high by LYC")
+	}
+
+	// Without the LYC match, HBLANK interrupts again
+	runUntilLine(ppu, 4)
+	takeLCDInterrupt(mmu)
+	for ppu.mode != 0 {
+		ppu.RunForClocks(1)
+	}
+	if !takeLCDInterrupt(mmu) {
+		t.Errorf("Expected mode 0 interrupt on line 4")
+	}
+
+	// LY reads 0 for all but the first clock of line 153, and LYC=0 matches early
+	mmu.Write(0xFF41, 0x40)
+	mmu.Write(0xFF45, 0)
+	runUntilLine(ppu, 153)
+	takeLCDInterrupt(mmu)
+	ppu.RunForClocks(1)
+	if ppu.line != 153 || mmu.Read(0xFF44) != 0 {
+		t.Errorf("Expected LY to read 0 during line 153, got %d on line %d", mmu.Read(0xFF44), ppu.line)
+	}
+	if mmu.Read(0xFF41)&0x04 == 0 {
+		t.Errorf("Expected LYC=0 coincidence during line 153")
+	}
+	if !takeLCDInterrupt(mmu) {
+		t.Errorf("Expected LYC=0 interrupt during line 153")
+	}
+	for ppu.line != 0 {
+		ppu.RunForClocks(1)
+	}
+	if takeLCDInterrupt(mmu) {
+		t.Errorf("Expected no second LYC=0 interrupt at the start of line 0")
+	}
+
+	// Disabling the LCD resets LY and the mode, blanks the screen, and stops the PPU
+	runUntilLine(ppu, 10)
+	ppu.framebuffer[0] = color.RGBA{0, 0, 0, 0xFF}
+	mmu.Write(0xFF40, 0x11)
+	if mmu.Read(0xFF44) != 0 || mmu.Read(0xFF41)&0x03 != 0 {
+		t.Errorf("Expected LY 0 and mode 0 with the LCD disabled, got LY %d and mode %d", mmu.Read(0xFF44), mmu.Read(0xFF41)&0x03)
+	}
+	if ppu.framebuffer[0] != ppu.palette.BG[0] {
+		t.Errorf("Expected a blank screen with the LCD disabled, got %v", ppu.framebuffer[0])
+	}
+	ppu.RunForClocks(1000)
+	if mmu.Read(0xFF44) != 0 || ppu.timeInMode != 0 {
+		t.Errorf("Expected the PPU to stop with the LCD disabled, got LY %d after 1000 clocks", mmu.Read(0xFF44))
+	}
+	if takeLCDInterrupt(mmu) {
+		t.Errorf("Expected no STAT interrupts with the LCD disabled")
+	}
+}
+
+// newWindowTestPPU constructs a PPU with a black background, and a window map with a white first row of tiles
+// and black below. Tile 2 has only its leftmost column black.
+func newWindowTestPPU(renderer Renderer) (*MMU, *PPU) {
+	mmu := NewMMU(nil)
+	ppu := NewPPU(mmu)
+	mmu.ppu = ppu
+	ppu.renderer = renderer
+
+	for i := uint16(0); i < 16; i++ {
+		mmu.Write(0x8010+i, 0xFF)
+		mmu.Write(0x8020+i, 0x80)
+	}
+	for i := uint16(0); i < 0x400; i++ {
+		mmu.Write(0x9800+i, 0x01)
+		mmu.Write(0x9C00+i, 0x01)
+	}
+	for i := uint16(0); i < 32; i++ {
+		mmu.Write(0x9800+i, 0x00)
+	}
+
+	mmu.Write(0xFF47, 0xE4)
+	mmu.Write(0xFF4A, 0)
+	mmu.Write(0xFF4B, 7)
+	mmu.Write(0xFF40, 0xB9) // LCD, window, tileset 1, BG map 1, BG
+
+	return mmu, ppu
+}
+
+// runUntilHBlank runs the PPU until the current line has been drawn
+func runUntilHBlank(ppu *PPU) {
+	for ppu.mode != 3 {
+		ppu.RunForClocks(1)
+	}
+	for ppu.mode != 0 {
+		ppu.RunForClocks(1)
+	}
+}
+
+func TestPPUWindowLineCounter(t *testing.T) {
+	white := color.RGBA{255, 255, 255, 0xFF}
+	black := color.RGBA{0, 0, 0, 0xFF}
+
+	for _, renderer := range []Renderer{RendererScanline, RendererFIFO} {
+		mmu, ppu := newWindowTestPPU(renderer)
+
+		// The window covers lines 0-3, and is disabled for lines 4-9
+		runUntilLine(ppu, 4)
+		if ppu.windowLine != 4 {
+			t.Errorf("%v: expected window line counter 4 after 4 lines, got %d", renderer, ppu.windowLine)
+		}
+		mmu.Write(0xFF40, 0x99)
+		runUntilLine(ppu, 10)
+		if ppu.windowLine != 4 {
+			t.Errorf("%v: expected window line counter to stay at 4 while disabled, got %d", renderer, ppu.windowLine)
+		}
+
+		// Re-enabled, the window resumes from row 4 rather than row 10
+		mmu.Write(0xFF40, 0xB9)
+		runUntilLine(ppu, 15)
+		if ppu.windowLine != 9 {
+			t.Errorf("%v: expected window line counter 9 after 9 lines with the window, got %d", renderer, ppu.windowLine)
+		}
+		for line, expected := range map[int]color.RGBA{3: white, 6: black, 10: white, 13: white, 14: black} {
+			if got := ppu.framebuffer[line*ScreenWidth]; got != expected {
+				t.Errorf("%v: expected line %d to be %v, got %v", renderer, line, expected, got)
+			}
+		}
+
+		// The counter resets for the next frame
+		runUntilLine(ppu, 144)
+		if ppu.windowLine != 0 {
+			t.Errorf("%v: expected window line counter to reset in VBLANK, got %d", renderer, ppu.windowLine)
+		}
+
+		// The window is not drawn before LY matches WY, even if WY is moved above LY afterwards
+		mmu.Write(0xFF4A, 20)
+		runUntilLine(ppu, 10)
+		mmu.Write(0xFF4A, 5)
+		runUntilLine(ppu, 11)
+		runUntilHBlank(ppu)
+		if got := ppu.framebuffer[11*ScreenWidth]; got != black || ppu.windowLine != 0 {
+			t.Errorf("%v: expected no window on line 11 after WY was passed, got %v and counter %d", renderer, got, ppu.windowLine)
+		}
+	}
+}
+
+func TestPPUWindowEdgeCases(t *testing.T) {
+	white := color.RGBA{255, 255, 255, 0xFF}
+	black := color.RGBA{0, 0, 0, 0xFF}
+
+	for _, renderer := range []Renderer{RendererScanline, RendererFIFO} {
+		mmu, ppu := newWindowTestPPU(renderer)
+
+		// WX=166 shows a single window pixel, and the window spans all of the next line
+		mmu.Write(0xFF4B, 166)
+		runUntilHBlank(ppu)
+		if got := ppu.framebuffer[158]; got != black {
+			t.Errorf("%v: expected background at x=158 with WX=166, got %v", renderer, got)
+		}
+		if got := ppu.framebuffer[159]; got != white {
+			t.Errorf("%v: expected window at x=159 with WX=166, got %v", renderer, got)
+		}
+
+		mmu.Write(0xFF4B, 200)
+		runUntilLine(ppu, 1)
+		runUntilHBlank(ppu)
+		for x := 0; x < ScreenWidth; x++ {
+			if got := ppu.framebuffer[ScreenWidth+x]; got != white {
+				t.Fatalf("%v: expected window across line 1 after WX=166, got %v at x=%d", renderer, got, x)
+			}
+		}
+
+		runUntilLine(ppu, 2)
+		runUntilHBlank(ppu)
+		if got := ppu.framebuffer[2*ScreenWidth]; got != black || ppu.windowLine != 2 {
+			t.Errorf("%v: expected no window on line 2, got %v and counter %d", renderer, got, ppu.windowLine)
+		}
+
+		// WX=0 hides the first 7 window pixels, fewer as the background is finely scrolled
+		for i := uint16(0); i < 32; i++ {
+			mmu.Write(0x9800+i, 0x02)
+		}
+		for _, scx := range []byte{0, 3, 7} {
+			mmu.Write(0xFF4B, 0)
+			mmu.Write(0xFF43, scx)
+			ppu.resetWindow()
+			ppu.windowY = true
+			ppu.line = 3
+			if renderer == RendererFIFO {
+				renderFIFOLine(ppu)
+			} else {
+				ppu.renderLine()
+			}
+
+			// Window column 0 of each tile is black
+			discard := 7 - int(scx)
+			for x := 0; x < 16; x++ {
+				expected := white
+				if (x+discard)%8 == 0 {
+					expected = black
+				}
+				if got := ppu.framebuffer[3*ScreenWidth+x]; got != expected {
+					t.Errorf("%v: expected pixel %d to be %v with WX=0 and SCX=%d, got %v", renderer, x, expected, scx, got)
+				}
+			}
+		}
+	}
+}
+
+func TestPPUCGBPalettes(t *testing.T) {
+	mmu := NewMMU(nil)
+	ppu := NewPPU(mmu)
+	mmu.ppu = ppu
+
+	// Color palettes are inaccessible outside of CGB mode
+	mmu.Write(0xFF68, 0x80)
+	if mmu.Read(0xFF68) != 0xFF || mmu.Read(0xFF69) != 0xFF {
+		t.Errorf("Expected color palette registers to read 0xFF outside of CGB mode")
+	}
+
+	ppu.cgb = true
+
+	// Palette 1, color 2, with auto-increment
+	mmu.Write(0xFF68, 0x80|0x0C)
+	mmu.Write(0xFF69, 0x1F) // Red
+	mmu.Write(0xFF69, 0x00)
+	mmu.Write(0xFF69, 0xE0) // Green
+	mmu.Write(0xFF69, 0x03)
+
+	if mmu.Read(0xFF68) != 0xD0 {
+		t.Errorf("Expected BCPS to auto-increment to 0xD0, got %#02x", mmu.Read(0xFF68))
+	}
+	if c := ppu.bgColorPalette.color(1, 2); c != (color.RGBA{255, 0, 0, 0xFF}) {
+		t.Errorf("Expected red, got %v", c)
+	}
+	if c := ppu.bgColorPalette.color(1, 3); c != (color.RGBA{0, 255, 0, 0xFF}) {
+		t.Errorf("Expected green, got %v", c)
+	}
+
+	// Without auto-increment
+	mmu.Write(0xFF6A, 0x3F)
+	mmu.Write(0xFF6B, 0x7C) // Blue
+	mmu.Write(0xFF6B, 0x7C)
+	if mmu.Read(0xFF6A) != 0x7F || mmu.Read(0xFF6B) != 0x7C {
+		t.Errorf("Expected OCPS to stay at 0x3F, got %#02x", mmu.Read(0xFF6A))
+	}
+	if c := ppu.spriteColorPalette.color(7, 3); c != (color.RGBA{0, 0, 255, 0xFF}) {
+		t.Errorf("Expected blue, got %v", c)
+	}
+
+	if c := rgb15ToRGBA(0x4210); c != (color.RGBA{132, 132, 132, 0xFF}) {
+		t.Errorf("Expected 15-bit colors to scale to 8 bits, got %v", c)
+	}
+}
+
+func TestPPUCGBRenderLine(t *testing.T) {
+	mmu := NewMMU(nil)
+	ppu := NewPPU(mmu)
+	mmu.ppu = ppu
+	mmu.cgb = true
+	ppu.cgb = true
+
+	ppu.lcdEnable = true
+	ppu.tileSelect = true
+	ppu.spriteEnable = true
+
+	red := color.RGBA{255, 0, 0, 0xFF}
+	green := color.RGBA{0, 255, 0, 0xFF}
+	blue := color.RGBA{0, 0, 255, 0xFF}
+	white := color.RGBA{255, 255, 255, 0xFF}
+
+	// BG palette 0: white, red. BG palette 2: white, green. Sprite palette 3: _, blue.
+	setColor := func(index uint16, data byte, c uint16) {
+		mmu.Write(index, 0x80|data)
+		mmu.Write(index+1, byte(c))
+		mmu.Write(index+1, byte(c>>8))
+	}
+	setColor(0xFF68, 0x00, 0x7FFF)
+	setColor(0xFF68, 0x02, 0x001F)
+	setColor(0xFF68, 0x10, 0x7FFF)
+	setColor(0xFF68, 0x12, 0x03E0)
+	setColor(0xFF6A, 0x1A, 0x7C00)
+
+	// Tile 1, bank 0: left half color 1
+	// Tile 1, bank 1: right half color 1
+	for i := uint16(0); i < 8; i++ {
+		mmu.Write16(0x8010+i*2, 0x00F0)
+	}
+	mmu.Write(0xFF4F, 0x01)
+	for i := uint16(0); i < 8; i++ {
+		mmu.Write16(0x8010+i*2, 0x000F)
+	}
+
+	// Map attributes: tile 0 uses bank 0, palette 0. Tile 1 uses bank 1, palette 2.
+	// Tile 2 uses bank 0, horizontally flipped, with BG priority.
+	mmu.Write(0x9800, 0x00)
+	mmu.Write(0x9801, 0x0A)
+	mmu.Write(0x9802, 0xA0)
+	mmu.Write(0xFF4F, 0x00)
+	mmu.Write(0x9800, 0x01)
+	mmu.Write(0x9801, 0x01)
+	mmu.Write(0x9802, 0x01)
+
+	// A solid sprite over tile 2, using palette 3 and tile bank 0
+	for i := uint16(0); i < 8; i++ {
+		mmu.Write16(0x8020+i*2, 0x00FF)
+	}
+	ppu.oam.Write(0, 16)
+	ppu.oam.Write(1, 8+16)
+	ppu.oam.Write(2, 0x02)
+	ppu.oam.Write(3, 0x03)
+
+	ppu.line = 0
+	ppu.bgEnable = true
+	ppu.renderLine()
+
+	expect := func(x int, c color.RGBA, msg string) {
+		if got := ppu.framebuffer[x]; got != c {
+			t.Errorf("%s: expected pixel %d to be %v, got %v", msg, x, c, got)
+		}
+	}
+	expect(0, red, "bank 0 tile")
+	expect(4, white, "bank 0 tile")
+	expect(8, white, "bank 1 tile")
+	expect(12, green, "bank 1 tile")
+	expect(16, blue, "sprite over color 0")
+	expect(20, red, "BG priority attribute")
+
+	// LCDC bit 0 clear gives sprites priority, but still draws the background
+	ppu.bgEnable = false
+	ppu.renderLine()
+	expect(0, red, "background with LCDC bit 0 clear")
+	expect(20, blue, "sprite with LCDC bit 0 clear")
 }
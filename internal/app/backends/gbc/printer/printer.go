@@ -0,0 +1,326 @@
+package printer
+
+import (
+	"fmt"
+	"image"
+	"image/color"
+	"image/png"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/omstrumpf/goemu/internal/app/log"
+)
+
+const (
+	// Width is the width of a printout in pixels
+	Width = 160
+
+	tilesPerRow  = Width / 8
+	bytesPerTile = 16
+
+	// bufferLength is the capacity of the printer's image buffer: 9 bands of 2 tile rows each
+	bufferLength = 9 * 2 * tilesPerRow * bytesPerTile
+
+	// busyPolls is the number of status inquiries the printer reports busy for after printing
+	busyPolls = 4
+)
+
+// Commands
+const (
+	commandInit   = 0x01
+	commandPrint  = 0x02
+	commandData   = 0x04
+	commandBreak  = 0x08
+	commandStatus = 0x0F
+)
+
+// Status bits
+const (
+	statusChecksumError   = 1 << 0
+	statusBusy            = 1 << 1
+	statusImageFull       = 1 << 2
+	statusUnprocessedData = 1 << 3
+)
+
+// Packet parsing states
+const (
+	stateMagic1 = iota
+	stateMagic2
+	stateCommand
+	stateCompression
+	stateLengthLo
+	stateLengthHi
+	stateData
+	stateChecksumLo
+	stateChecksumHi
+	stateKeepalive
+	stateStatus
+)
+
+// shades are the gray levels of the four printable shades, from white to black
+var shades = [4]uint8{0xFF, 0xAA, 0x55, 0x00}
+
+// Printer emulates the Game Boy Printer. It attaches to the serial port as a SerialEndpoint,
+// and writes each printout to a PNG file in the output directory.
+type Printer struct {
+	dir     string                // Directory to write printouts to
+	printed func(filename string) // Called with the name of each printout written. May be nil.
+
+	state       int
+	command     byte
+	compression byte
+	length      uint16
+	data        []byte
+	checksum    uint16
+	sum         uint16
+
+	status    byte
+	busyPolls int
+
+	buffer []byte // Decompressed tile data, waiting to be printed
+	page   []byte // Shade values of the current printout, one byte per pixel
+
+	printouts int // Number of printouts written
+}
+
+// NewPrinter constructs a valid Printer struct, writing printouts to the given directory.
+// If printed is non-nil, it is called with the filename of each printout once it has been written.
+func NewPrinter(dir string, printed func(filename string)) *Printer {
+	return &Printer{dir: dir, printed: printed}
+}
+
+// Transfer receives a byte from the gameboy, and returns the printer's response
+func (p *Printer) Transfer(out byte) byte {
+	switch p.state {
+	case stateMagic1:
+		if out == 0x88 {
+			p.state = stateMagic2
+		}
+	case stateMagic2:
+		if out == 0x33 {
+			p.state = stateCommand
+		} else {
+			p.state = stateMagic1
+		}
+	case stateCommand:
+		p.command = out
+		p.sum = uint16(out)
+		p.state = stateCompression
+	case stateCompression:
+		p.compression = out
+		p.sum += uint16(out)
+		p.state = stateLengthLo
+	case stateLengthLo:
+		p.length = uint16(out)
+		p.sum += uint16(out)
+		p.state = stateLengthHi
+	case stateLengthHi:
+		p.length |= uint16(out) << 8
+		p.sum += uint16(out)
+		p.data = p.data[:0]
+		if p.length > 0 {
+			p.state = stateData
+		} else {
+			p.state = stateChecksumLo
+		}
+	case stateData:
+		p.data = append(p.data, out)
+		p.sum += uint16(out)
+		if len(p.data) == int(p.length) {
+			p.state = stateChecksumLo
+		}
+	case stateChecksumLo:
+		p.checksum = uint16(out)
+		p.state = stateChecksumHi
+	case stateChecksumHi:
+		p.checksum |= uint16(out) << 8
+		p.state = stateKeepalive
+	case stateKeepalive:
+		p.processPacket()
+		p.state = stateStatus
+		return 0x81 // Device ID
+	case stateStatus:
+		p.state = stateMagic1
+		return p.status
+	}
+
+	return 0x00
+}
+
+// Close writes any printout that has not yet been fed out of the printer
+func (p *Printer) Close() error {
+	return p.feed()
+}
+
+func (p *Printer) processPacket() {
+	if p.checksum != p.sum {
+		log.Warningf("Printer received packet with bad checksum: %#04x (expected %#04x)", p.checksum, p.sum)
+		p.status |= statusChecksumError
+		return
+	}
+	p.status &^= statusChecksumError
+
+	switch p.command {
+	case commandInit:
+		log.Debugf("Printer initialized")
+		p.buffer = p.buffer[:0]
+		p.status = 0
+		p.busyPolls = 0
+	case commandData:
+		data := p.data
+		if p.compression != 0 {
+			data = decompress(data)
+		}
+
+		if len(p.buffer)+len(data) > bufferLength {
+			log.Warningf("Printer buffer overflowed. Data will be truncated.")
+			data = data[:bufferLength-len(p.buffer)]
+		}
+
+		p.buffer = append(p.buffer, data...)
+		p.status |= statusUnprocessedData
+		if len(p.buffer) == bufferLength {
+			p.status |= statusImageFull
+		}
+	case commandPrint:
+		if len(p.data) < 4 {
+			log.Warningf("Printer received malformed print command")
+			return
+		}
+
+		p.print(p.data[1], p.data[2])
+
+		p.status &^= statusUnprocessedData
+		p.status |= statusBusy | statusImageFull
+		p.busyPolls = busyPolls
+	case commandBreak:
+		p.buffer = p.buffer[:0]
+		p.status = 0
+		p.busyPolls = 0
+	case commandStatus:
+		if p.busyPolls > 0 {
+			p.busyPolls--
+			if p.busyPolls == 0 {
+				p.status &^= statusBusy | statusImageFull
+			}
+		}
+	default:
+		log.Warningf("Printer received unknown command: %#02x", p.command)
+	}
+}
+
+// print renders the buffered tile data onto the current printout with the given palette.
+// The upper nibble of margins is the feed before printing, and the lower nibble the feed after.
+func (p *Printer) print(margins byte, palette byte) {
+	if palette == 0 {
+		// Treated as the default palette by the printer
+		palette = 0xE4
+	}
+
+	if margins>>4 != 0 {
+		if err := p.feed(); err != nil {
+			log.Errorf("Failed to write printout: %v", err)
+		}
+	}
+
+	rows := len(p.buffer) / (tilesPerRow * bytesPerTile)
+
+	for y := 0; y < rows*8; y++ {
+		for x := 0; x < Width; x++ {
+			tile := (y/8)*tilesPerRow + x/8
+			addr := tile*bytesPerTile + (y%8)*2
+
+			bit := byte(1 << (7 - uint(x%8)))
+			val := byte(0)
+			if p.buffer[addr]&bit != 0 {
+				val++
+			}
+			if p.buffer[addr+1]&bit != 0 {
+				val += 2
+			}
+
+			p.page = append(p.page, (palette>>(val*2))&3)
+		}
+	}
+
+	log.Debugf("Printer printed %d rows", rows*8)
+
+	p.buffer = p.buffer[:0]
+
+	if margins&0x0F != 0 {
+		if err := p.feed(); err != nil {
+			log.Errorf("Failed to write printout: %v", err)
+		}
+	}
+}
+
+// feed ejects the current printout, writing it to a PNG file
+func (p *Printer) feed() error {
+	if len(p.page) == 0 {
+		return nil
+	}
+
+	height := len(p.page) / Width
+
+	img := image.NewGray(image.Rect(0, 0, Width, height))
+	for i, shade := range p.page {
+		img.SetGray(i%Width, i/Width, color.Gray{Y: shades[shade]})
+	}
+
+	p.page = p.page[:0]
+	p.printouts++
+
+	filename := filepath.Join(p.dir, fmt.Sprintf("print-%s-%03d.png", time.Now().Format("20060102-150405"), p.printouts))
+
+	f, err := os.Create(filename)
+	if err != nil {
+		return err
+	}
+
+	if err := png.Encode(f, img); err != nil {
+		f.Close()
+		return err
+	}
+
+	if err := f.Close(); err != nil {
+		return err
+	}
+
+	if p.printed != nil {
+		p.printed(filename)
+	}
+
+	return nil
+}
+
+// decompress expands run-length encoded printer data.
+// A control byte with the high bit set repeats the following byte (n&0x7F)+2 times,
+// otherwise the following n+1 bytes are copied literally.
+func decompress(data []byte) []byte {
+	var ret []byte
+
+	for i := 0; i < len(data); {
+		control := data[i]
+		i++
+
+		if control&0x80 != 0 {
+			if i >= len(data) {
+				break
+			}
+			for n := 0; n < int(control&0x7F)+2; n++ {
+				ret = append(ret, data[i])
+			}
+			i++
+		} else {
+			n := int(control) + 1
+			if i+n > len(data) {
+				n = len(data) - i
+			}
+			ret = append(ret, data[i:i+n]...)
+			i += n
+		}
+	}
+
+	return ret
+}
@@ -0,0 +1,170 @@
+package printer
+
+import (
+	"image/png"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// sendPacket transfers a complete packet to the printer, and returns the device ID and status bytes
+func sendPacket(p *Printer, command byte, compression byte, data []byte, corrupt bool) (byte, byte) {
+	packet := []byte{command, compression, byte(len(data)), byte(len(data) >> 8)}
+	packet = append(packet, data...)
+
+	var sum uint16
+	for _, b := range packet {
+		sum += uint16(b)
+	}
+	if corrupt {
+		sum++
+	}
+
+	p.Transfer(0x88)
+	p.Transfer(0x33)
+	for _, b := range packet {
+		p.Transfer(b)
+	}
+	p.Transfer(byte(sum))
+	p.Transfer(byte(sum >> 8))
+
+	return p.Transfer(0x00), p.Transfer(0x00)
+}
+
+// tileRow builds one row of 20 tiles, where every pixel of tile i has color i%4
+func tileRow() []byte {
+	var data []byte
+	for tile := 0; tile < tilesPerRow; tile++ {
+		lo, hi := byte(0x00), byte(0x00)
+		if tile&1 != 0 {
+			lo = 0xFF
+		}
+		if tile&2 != 0 {
+			hi = 0xFF
+		}
+		for line := 0; line < 8; line++ {
+			data = append(data, lo, hi)
+		}
+	}
+	return data
+}
+
+func readPrintouts(t *testing.T, dir string) []string {
+	files, err := filepath.Glob(filepath.Join(dir, "*.png"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return files
+}
+
+func TestPrinterProtocol(t *testing.T) {
+	dir, err := ioutil.TempDir("", "printer")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	var printed []string
+	p := NewPrinter(dir, func(filename string) {
+		printed = append(printed, filename)
+	})
+
+	if id, status := sendPacket(p, commandInit, 0, nil, false); id != 0x81 || status != 0x00 {
+		t.Errorf("Init: got id %#02x status %#02x", id, status)
+	}
+
+	if _, status := sendPacket(p, commandData, 0, tileRow(), false); status != statusUnprocessedData {
+		t.Errorf("Data: got status %#02x", status)
+	}
+
+	if _, status := sendPacket(p, commandData, 0, tileRow(), true); status&statusChecksumError == 0 {
+		t.Errorf("Corrupt data: got status %#02x, expected checksum error", status)
+	}
+
+	// Palette 0x1B inverts the shades
+	if _, status := sendPacket(p, commandPrint, 0, []byte{0x01, 0x00, 0x1B, 0x40}, false); status&statusBusy == 0 {
+		t.Errorf("Print: got status %#02x, expected busy", status)
+	}
+
+	for i := 0; i < busyPolls; i++ {
+		sendPacket(p, commandStatus, 0, nil, false)
+	}
+	if _, status := sendPacket(p, commandStatus, 0, nil, false); status != 0x00 {
+		t.Errorf("Status after printing: got %#02x", status)
+	}
+
+	// No paper feed yet, so the printout is still in the printer
+	if files := readPrintouts(t, dir); len(files) != 0 {
+		t.Fatalf("Expected no printouts before paper feed, got %v", files)
+	}
+
+	sendPacket(p, commandData, 0, tileRow(), false)
+	sendPacket(p, commandPrint, 0, []byte{0x01, 0x01, 0xE4, 0x40}, false)
+
+	files := readPrintouts(t, dir)
+	if len(files) != 1 {
+		t.Fatalf("Expected 1 printout, got %v", files)
+	}
+	if len(printed) != 1 || printed[0] != files[0] {
+		t.Errorf("Expected the printout %s to be reported, got %v", files[0], printed)
+	}
+
+	f, err := os.Open(files[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	img, err := png.Decode(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if img.Bounds().Dx() != Width || img.Bounds().Dy() != 16 {
+		t.Fatalf("Expected %dx16 printout, got %v", Width, img.Bounds())
+	}
+
+	for tile := 0; tile < 4; tile++ {
+		// First band printed with the inverted palette, second with the identity palette
+		expectedTop := shades[3-tile]
+		expectedBottom := shades[tile]
+
+		if r, _, _, _ := img.At(tile*8, 0).RGBA(); uint8(r>>8) != expectedTop {
+			t.Errorf("Tile %d top: expected shade %#02x, got %#02x", tile, expectedTop, uint8(r>>8))
+		}
+		if r, _, _, _ := img.At(tile*8, 8).RGBA(); uint8(r>>8) != expectedBottom {
+			t.Errorf("Tile %d bottom: expected shade %#02x, got %#02x", tile, expectedBottom, uint8(r>>8))
+		}
+	}
+}
+
+func TestPrinterCompressedData(t *testing.T) {
+	raw := tileRow()
+
+	// Encode each tile as a series of literal blocks
+	var compressed []byte
+	for tile := 0; tile < tilesPerRow; tile++ {
+		lo, hi := raw[tile*bytesPerTile], raw[tile*bytesPerTile+1]
+		compressed = append(compressed, 0x01, lo, hi)                         // Literal: 2 bytes
+		compressed = append(compressed, 0x07, lo, hi, lo, hi, lo, hi, lo, hi) // Literal: 8 bytes
+		compressed = append(compressed, 0x01, lo, hi, 0x03, lo, hi, lo, hi)
+	}
+
+	if got := decompress(compressed); string(got) != string(raw) {
+		t.Fatalf("Literal decompression mismatch")
+	}
+
+	if got := decompress([]byte{0x83, 0xAB, 0x00, 0xCD}); string(got) != string([]byte{0xAB, 0xAB, 0xAB, 0xAB, 0xAB, 0xCD}) {
+		t.Fatalf("Run decompression mismatch: got %x", got)
+	}
+
+	p := NewPrinter("", nil)
+	sendPacket(p, commandInit, 0, nil, false)
+	if _, status := sendPacket(p, commandData, 1, compressed, false); status != statusUnprocessedData {
+		t.Errorf("Compressed data: got status %#02x", status)
+	}
+	if string(p.buffer) != string(raw) {
+		t.Errorf("Compressed data was not decompressed into the buffer")
+	}
+}
@@ -0,0 +1,184 @@
+package gbc
+
+import (
+	"github.com/omstrumpf/goemu/internal/app/backends/gbc/interrupts"
+	"github.com/omstrumpf/goemu/internal/app/backends/gbc/state"
+	"github.com/omstrumpf/goemu/internal/app/log"
+)
+
+const (
+	// serialTransferClocks is the duration of an 8-bit transfer on the internal 8192 Hz serial clock
+	serialTransferClocks = 8 * 128
+)
+
+// SerialEndpoint is a device attached to the other end of the link cable
+type SerialEndpoint interface {
+	// Transfer exchanges a byte with the endpoint, when the gameboy drives the transfer with its internal clock.
+	// The gameboy's outgoing byte is passed in, and the byte shifted in from the endpoint is returned.
+	Transfer(out byte) (in byte)
+}
+
+// SerialSyncer is an optional extension of SerialEndpoint, for endpoints that must run in lock-step with the emulated clock
+type SerialSyncer interface {
+	// SyncPeriod returns the number of clocks between sync points
+	SyncPeriod() int
+
+	// Sync is called once every sync period, with the serial port in its current state
+	Sync(s *Serial)
+}
+
+// Serial is the gameboy's serial (link cable) controller
+type Serial struct {
+	mmu *MMU
+
+	endpoint SerialEndpoint
+	syncer   SerialSyncer // Set if the endpoint requires lock-step syncing
+
+	syncCountdown int // Clocks remaining until the next sync point
+
+	data byte // SB: shift register
+
+	transferActive bool // SC bit 7: a transfer is requested or in progress
+	internalClock  bool // SC bit 0: this gameboy drives the serial clock
+
+	transferCounter int // Clocks remaining in the active internal clock transfer
+}
+
+// NewSerial constructs a valid Serial struct, with no endpoint attached
+func NewSerial(mmu *MMU) *Serial {
+	s := new(Serial)
+
+	s.mmu = mmu
+
+	return s
+}
+
+// Attach connects an endpoint to the serial port. A nil endpoint disconnects the cable.
+func (s *Serial) Attach(endpoint SerialEndpoint) {
+	s.endpoint = endpoint
+
+	s.syncer, _ = endpoint.(SerialSyncer)
+	if s.syncer != nil {
+		s.syncCountdown = s.syncer.SyncPeriod()
+	}
+}
+
+// Data returns the contents of the shift register
+func (s *Serial) Data() byte {
+	return s.data
+}
+
+// DrivingTransfer returns true if a transfer is in progress on the internal clock
+func (s *Serial) DrivingTransfer() bool {
+	return s.transferActive && s.internalClock
+}
+
+// RunForClocks runs the serial controller for the given number of clock cycles
+func (s *Serial) RunForClocks(clocks int) {
+	// Sync before completing transfers, so that a transfer always spans at least one sync point
+	if s.syncer != nil {
+		s.syncCountdown -= clocks
+
+		if s.syncCountdown <= 0 {
+			s.syncCountdown += s.syncer.SyncPeriod()
+			s.syncer.Sync(s)
+		}
+	}
+
+	if !s.DrivingTransfer() {
+		return
+	}
+
+	s.transferCounter -= clocks
+
+	if s.transferCounter <= 0 {
+		in := byte(0xFF) // Disconnected cable reads high
+		if s.endpoint != nil {
+			in = s.endpoint.Transfer(s.data)
+		}
+
+		s.completeTransfer(in)
+	}
+}
+
+// ExternalTransfer performs a transfer clocked by the connected peer. The peer's outgoing byte is shifted in,
+// and the byte previously in the shift register is returned. The serial interrupt is only raised
+// if this gameboy has a transfer pending on the external clock.
+func (s *Serial) ExternalTransfer(in byte) (out byte) {
+	if s.DrivingTransfer() {
+		// Both sides are driving the clock. The peer's clock is ignored.
+		return 0xFF
+	}
+
+	out = s.data
+
+	if s.transferActive {
+		s.completeTransfer(in)
+	} else {
+		s.data = in
+	}
+
+	return out
+}
+
+func (s *Serial) completeTransfer(in byte) {
+	log.Tracef("Serial transfer complete. Sent %#02x, received %#02x", s.data, in)
+
+	s.data = in
+	s.transferActive = false
+	s.transferCounter = 0
+
+	s.mmu.interrupts.Request(interrupts.SerialBit)
+}
+
+func (s *Serial) Read(addr uint16) byte {
+	switch addr {
+	case 0xFF01:
+		return s.data
+	case 0xFF02:
+		ret := byte(0x7E)
+		if s.transferActive {
+			ret |= 0x80
+		}
+		if s.internalClock {
+			ret |= 0x01
+		}
+		return ret
+	}
+
+	log.Warningf("Encountered unexpected serial read: %#4x", addr)
+	return 0xFF
+}
+
+func (s *Serial) Write(addr uint16, val byte) {
+	switch addr {
+	case 0xFF01:
+		s.data = val
+		return
+	case 0xFF02:
+		s.transferActive = (val&0x80 != 0)
+		s.internalClock = (val&0x01 != 0)
+		if s.transferActive && s.internalClock {
+			s.transferCounter = serialTransferClocks
+		}
+		return
+	}
+
+	log.Warningf("Encountered unexpected serial write: %#4x", addr)
+}
+
+// SaveState writes the serial registers and transfer progress to the save state
+func (s *Serial) SaveState(w *state.Writer) {
+	w.U8(s.data)
+	w.Bool(s.transferActive)
+	w.Bool(s.internalClock)
+	w.Int(s.transferCounter)
+}
+
+// LoadState restores the serial registers and transfer progress from the save state
+func (s *Serial) LoadState(r *state.Reader) {
+	s.data = r.U8()
+	s.transferActive = r.Bool()
+	s.internalClock = r.Bool()
+	s.transferCounter = r.Int()
+}
@@ -0,0 +1,59 @@
+package gbc
+
+import (
+	"io"
+
+	"github.com/omstrumpf/goemu/internal/app/log"
+)
+
+// SerialLoopback is a SerialEndpoint with the output line wired to the input line.
+// Every byte sent is received back.
+type SerialLoopback struct{}
+
+// NewSerialLoopback constructs a valid SerialLoopback struct
+func NewSerialLoopback() *SerialLoopback {
+	return new(SerialLoopback)
+}
+
+// Transfer returns the outgoing byte
+func (sl *SerialLoopback) Transfer(out byte) byte {
+	return out
+}
+
+// SerialLogger is a SerialEndpoint that writes every byte sent to an io.Writer.
+// It acts as a disconnected cable, so every byte received is 0xFF.
+// Test ROMs commonly print their results this way.
+type SerialLogger struct {
+	w io.Writer
+}
+
+// NewSerialLogger constructs a valid SerialLogger struct writing to w
+func NewSerialLogger(w io.Writer) *SerialLogger {
+	return &SerialLogger{w: w}
+}
+
+// Transfer writes the outgoing byte, and returns 0xFF
+func (sl *SerialLogger) Transfer(out byte) byte {
+	if _, err := sl.w.Write([]byte{out}); err != nil {
+		log.Warningf("Serial logger failed to write: %v", err)
+	}
+
+	return 0xFF
+}
+
+// serialPeer is a SerialEndpoint connected to another in-process gameboy
+type serialPeer struct {
+	peer *GBC
+}
+
+// Transfer clocks the peer's serial port with the outgoing byte, and returns the peer's byte
+func (sp *serialPeer) Transfer(out byte) byte {
+	return sp.peer.serial.ExternalTransfer(out)
+}
+
+// LinkGBCs connects two in-process gameboys with a link cable.
+// Whichever gameboy starts a transfer on its internal clock drives the exchange.
+func LinkGBCs(a *GBC, b *GBC) {
+	a.AttachSerial(&serialPeer{peer: b})
+	b.AttachSerial(&serialPeer{peer: a})
+}
@@ -0,0 +1,149 @@
+package gbc
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+
+	"github.com/omstrumpf/goemu/internal/app/log"
+)
+
+const (
+	// serialLinkSyncPeriod is the number of clocks between lock-step sync points.
+	// It must be shorter than a transfer, so that every transfer spans at least one sync point.
+	serialLinkSyncPeriod = serialTransferClocks / 2
+
+	serialLinkMagic   = "GOEMU-LINK"
+	serialLinkVersion = 1
+
+	serialLinkMessageLength = 10
+
+	serialLinkFlagStarting = 1 << 0 // A transfer was started on the internal clock since the last sync point
+	serialLinkFlagDriving  = 1 << 1 // A transfer is in progress on the internal clock
+)
+
+// SerialLink is a SerialEndpoint connecting two gameboys over a byte stream, such as a TCP connection.
+//
+// Both gameboys run in lock-step: every sync period, each side sends its serial port state and blocks until
+// it receives the peer's state for the same sync point. A transfer started on the internal clock is exchanged
+// at the first sync point after it starts, using the state both sides observed at that sync point, so the
+// result is deterministic regardless of network timing. Because of the lock-step, pausing one side stalls the other.
+//
+// If the connection fails, the link behaves as a disconnected cable.
+type SerialLink struct {
+	conn io.ReadWriter
+
+	connected bool
+	syncs     uint64 // Number of sync points passed
+
+	received    byte // Byte received from the peer for the current transfer
+	hasReceived bool // Whether the current transfer has been exchanged
+}
+
+// NewSerialLink constructs a valid SerialLink over the given connection, and performs the handshake with the peer
+func NewSerialLink(conn io.ReadWriter) (*SerialLink, error) {
+	sl := &SerialLink{
+		conn:      conn,
+		connected: true,
+	}
+
+	handshake := append([]byte(serialLinkMagic), serialLinkVersion)
+	if _, err := conn.Write(handshake); err != nil {
+		return nil, err
+	}
+
+	peer := make([]byte, len(handshake))
+	if _, err := io.ReadFull(conn, peer); err != nil {
+		return nil, err
+	}
+
+	if string(peer[:len(serialLinkMagic)]) != serialLinkMagic {
+		return nil, errors.New("link peer is not a goemu instance")
+	}
+	if peer[len(serialLinkMagic)] != serialLinkVersion {
+		return nil, fmt.Errorf("link peer uses protocol version %d (expected %d)", peer[len(serialLinkMagic)], serialLinkVersion)
+	}
+
+	return sl, nil
+}
+
+// Connected returns true if the link to the peer is still up
+func (sl *SerialLink) Connected() bool {
+	return sl.connected
+}
+
+// Transfer returns the byte exchanged with the peer when the transfer started
+func (sl *SerialLink) Transfer(out byte) byte {
+	if !sl.hasReceived {
+		return 0xFF
+	}
+
+	sl.hasReceived = false
+
+	return sl.received
+}
+
+// SyncPeriod returns the number of clocks between sync points
+func (sl *SerialLink) SyncPeriod() int {
+	return serialLinkSyncPeriod
+}
+
+// Sync exchanges serial port state with the peer, blocking until the peer reaches the same sync point
+func (sl *SerialLink) Sync(s *Serial) {
+	if !sl.connected {
+		return
+	}
+
+	starting := s.DrivingTransfer() && !sl.hasReceived
+
+	var msg [serialLinkMessageLength]byte
+	binary.LittleEndian.PutUint64(msg[0:], sl.syncs)
+	if starting {
+		msg[8] |= serialLinkFlagStarting
+	}
+	if s.DrivingTransfer() {
+		msg[8] |= serialLinkFlagDriving
+	}
+	msg[9] = s.Data()
+
+	if _, err := sl.conn.Write(msg[:]); err != nil {
+		sl.disconnect(err)
+		return
+	}
+
+	var peer [serialLinkMessageLength]byte
+	if _, err := io.ReadFull(sl.conn, peer[:]); err != nil {
+		sl.disconnect(err)
+		return
+	}
+
+	if peerSyncs := binary.LittleEndian.Uint64(peer[0:]); peerSyncs != sl.syncs {
+		sl.disconnect(fmt.Errorf("peer is at sync point %d, expected %d", peerSyncs, sl.syncs))
+		return
+	}
+	sl.syncs++
+
+	peerFlags := peer[8]
+	peerData := peer[9]
+
+	if starting {
+		if peerFlags&serialLinkFlagDriving != 0 {
+			// Both sides are driving the clock
+			sl.received = 0xFF
+		} else {
+			sl.received = peerData
+		}
+		sl.hasReceived = true
+	}
+
+	if peerFlags&serialLinkFlagStarting != 0 && !s.DrivingTransfer() {
+		s.ExternalTransfer(peerData)
+	}
+}
+
+func (sl *SerialLink) disconnect(err error) {
+	log.Errorf("Link cable disconnected: %v", err)
+
+	sl.connected = false
+}
@@ -0,0 +1,111 @@
+package gbc
+
+import (
+	"net"
+	"sync"
+	"testing"
+)
+
+// serialTestROM sends the given byte over serial with the given SC value, waits for the transfer, and stores the result in 0xFF80
+func serialTestROM(out byte, control byte) []byte {
+	rom := testROM()
+
+	copy(rom[0x0100:], []byte{
+		0xC3, 0x50, 0x01, // JP 0x0150
+	})
+
+	copy(rom[0x0150:], []byte{
+		0x3E, out, // LD A,out
+		0xE0, 0x01, // LDH (SB),A
+		0x3E, control, // LD A,control
+		0xE0, 0x02, // LDH (SC),A
+		0xF0, 0x02, // LDH A,(SC)
+		0xCB, 0x7F, // BIT 7,A
+		0x20, 0xFA, // JR NZ,-6
+		0xF0, 0x01, // LDH A,(SB)
+		0xE0, 0x80, // LDH (0xFF80),A
+		0x18, 0xFE, // JR -2
+	})
+
+	return finalizeHeader(rom)
+}
+
+func TestSerialLinkTCP(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+
+	var serverConn net.Conn
+	var serverLink *SerialLink
+	var serverErr error
+	accepted := make(chan struct{})
+	go func() {
+		defer close(accepted)
+		serverConn, serverErr = listener.Accept()
+		if serverErr == nil {
+			serverLink, serverErr = NewSerialLink(serverConn)
+		}
+	}()
+
+	clientConn, err := net.Dial("tcp", listener.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	clientLink, err := NewSerialLink(clientConn)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	<-accepted
+	if serverErr != nil {
+		t.Fatal(serverErr)
+	}
+
+	master := NewGBC(Config{SkipLogo: true, SpeedFactor: 1}, serialTestROM(0xAA, 0x81), nil)
+	slave := NewGBC(Config{SkipLogo: true, SpeedFactor: 1}, serialTestROM(0xBB, 0x80), nil)
+	master.AttachSerial(clientLink)
+	slave.AttachSerial(serverLink)
+
+	var wg sync.WaitGroup
+	run := func(gbc *GBC, conn net.Conn) {
+		defer wg.Done()
+		for i := 0; i < 10; i++ {
+			gbc.Tick()
+		}
+		// Unblocks the peer, if it is waiting on a final sync point
+		conn.Close()
+	}
+
+	wg.Add(2)
+	go run(master, clientConn)
+	go run(slave, serverConn)
+	wg.Wait()
+
+	if got := master.mmu.Read(0xFF80); got != 0xBB {
+		t.Errorf("Expected master to receive 0xBB, got %#02x", got)
+	}
+	if got := slave.mmu.Read(0xFF80); got != 0xAA {
+		t.Errorf("Expected slave to receive 0xAA, got %#02x", got)
+	}
+	if clientLink.syncs != serverLink.syncs {
+		t.Errorf("Expected both sides to pass the same number of sync points, got %d and %d", clientLink.syncs, serverLink.syncs)
+	}
+}
+
+func TestSerialLinkHandshake(t *testing.T) {
+	a, b := net.Pipe()
+	defer a.Close()
+	defer b.Close()
+
+	go b.Write([]byte("NOT-GOEMU!\x01"))
+	go func() {
+		buf := make([]byte, 11)
+		b.Read(buf)
+	}()
+
+	if _, err := NewSerialLink(a); err == nil {
+		t.Errorf("Expected handshake with a non-goemu peer to fail")
+	}
+}
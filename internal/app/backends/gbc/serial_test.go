@@ -0,0 +1,117 @@
+package gbc
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/omstrumpf/goemu/internal/app/backends/gbc/interrupts"
+)
+
+func newTestSerial() (*MMU, *Serial) {
+	mmu := NewMMU(nil)
+	serial := NewSerial(mmu)
+	mmu.serial = serial
+
+	return mmu, serial
+}
+
+func TestSerialInternalClockDisconnected(t *testing.T) {
+	mmu, serial := newTestSerial()
+
+	mmu.Write(0xFF01, 0x42)
+	mmu.Write(0xFF02, 0x81)
+
+	if mmu.Read(0xFF02) != 0xFF {
+		t.Errorf("Expected SC to read 0xFF during transfer, got %#02x", mmu.Read(0xFF02))
+	}
+
+	serial.RunForClocks(serialTransferClocks - 1)
+	if mmu.Read(0xFF01) != 0x42 {
+		t.Errorf("Expected SB to be unchanged before transfer completes, got %#02x", mmu.Read(0xFF01))
+	}
+	if mmu.interrupts.Read(0xFF0F)&(1<<interrupts.SerialBit) != 0 {
+		t.Errorf("Expected serial interrupt not to be requested before transfer completes")
+	}
+
+	serial.RunForClocks(1)
+	if mmu.Read(0xFF01) != 0xFF {
+		t.Errorf("Expected SB to read 0xFF from a disconnected cable, got %#02x", mmu.Read(0xFF01))
+	}
+	if mmu.Read(0xFF02) != 0x7F {
+		t.Errorf("Expected SC transfer bit to clear after transfer, got %#02x", mmu.Read(0xFF02))
+	}
+	if mmu.interrupts.Read(0xFF0F)&(1<<interrupts.SerialBit) == 0 {
+		t.Errorf("Expected serial interrupt to be requested after transfer")
+	}
+}
+
+func TestSerialExternalClockWaits(t *testing.T) {
+	mmu, serial := newTestSerial()
+	serial.Attach(NewSerialLoopback())
+
+	mmu.Write(0xFF01, 0x42)
+	mmu.Write(0xFF02, 0x80)
+
+	serial.RunForClocks(serialTransferClocks * 4)
+	if mmu.Read(0xFF02)&0x80 == 0 {
+		t.Errorf("Expected external clock transfer to wait for the peer")
+	}
+
+	if out := serial.ExternalTransfer(0x99); out != 0x42 {
+		t.Errorf("Expected external transfer to send 0x42, got %#02x", out)
+	}
+	if mmu.Read(0xFF01) != 0x99 {
+		t.Errorf("Expected external transfer to receive 0x99, got %#02x", mmu.Read(0xFF01))
+	}
+	if mmu.interrupts.Read(0xFF0F)&(1<<interrupts.SerialBit) == 0 {
+		t.Errorf("Expected serial interrupt to be requested after external transfer")
+	}
+}
+
+func TestSerialEndpoints(t *testing.T) {
+	mmu, serial := newTestSerial()
+
+	serial.Attach(NewSerialLoopback())
+	mmu.Write(0xFF01, 0x42)
+	mmu.Write(0xFF02, 0x81)
+	serial.RunForClocks(serialTransferClocks)
+	if mmu.Read(0xFF01) != 0x42 {
+		t.Errorf("Expected loopback to receive the sent byte 0x42, got %#02x", mmu.Read(0xFF01))
+	}
+
+	var buf bytes.Buffer
+	serial.Attach(NewSerialLogger(&buf))
+	for _, c := range []byte("ok") {
+		mmu.Write(0xFF01, c)
+		mmu.Write(0xFF02, 0x81)
+		serial.RunForClocks(serialTransferClocks)
+	}
+	if buf.String() != "ok" {
+		t.Errorf("Expected logger to write \"ok\", got %q", buf.String())
+	}
+}
+
+func TestSerialLinkGBCs(t *testing.T) {
+	a := NewGBC(Config{SkipLogo: true, SpeedFactor: 1}, testROM(), nil)
+	b := NewGBC(Config{SkipLogo: true, SpeedFactor: 1}, testROM(), nil)
+	LinkGBCs(a, b)
+
+	// b waits on the external clock
+	b.mmu.Write(0xFF01, 0xBB)
+	b.mmu.Write(0xFF02, 0x80)
+
+	// a drives the transfer
+	a.mmu.Write(0xFF01, 0xAA)
+	a.mmu.Write(0xFF02, 0x81)
+	a.serial.RunForClocks(serialTransferClocks)
+
+	if a.mmu.Read(0xFF01) != 0xBB {
+		t.Errorf("Expected master to receive 0xBB, got %#02x", a.mmu.Read(0xFF01))
+	}
+	if b.mmu.Read(0xFF01) != 0xAA {
+		t.Errorf("Expected slave to receive 0xAA, got %#02x", b.mmu.Read(0xFF01))
+	}
+	if b.mmu.Read(0xFF02)&0x80 != 0 {
+		t.Errorf("Expected slave transfer to complete")
+	}
+}
@@ -3,6 +3,7 @@ package gbc
 import (
 	"sort"
 
+	"github.com/omstrumpf/goemu/internal/app/backends/gbc/state"
 	"github.com/omstrumpf/goemu/internal/app/log"
 )
 
@@ -91,30 +92,46 @@ func (oam *oam) Write(addr uint16, val byte) {
 	}
 }
 
-func (oam *oam) VisibleSpritesOnLine(line byte, tallSprites bool) []*sprite {
+func (oam *oam) SaveState(w *state.Writer) {
+	for addr := uint16(0); addr < 0xA0; addr++ {
+		w.U8(oam.Read(addr))
+	}
+}
+
+func (oam *oam) LoadState(r *state.Reader) {
+	for addr := uint16(0); addr < 0xA0; addr++ {
+		oam.Write(addr, r.U8())
+	}
+}
+
+// VisibleSpritesOnLine returns the sprites to draw on the line, in drawing order (highest priority last).
+// The PPU selects the first 10 sprites on the line in OAM order, including those with an offscreen X position.
+// In CGB mode, sprite priority is by OAM index alone. Otherwise the leftmost sprite has priority,
+// and sprites at the same X position are prioritized by OAM index.
+func (oam *oam) VisibleSpritesOnLine(line byte, tallSprites bool, cgb bool) []*sprite {
 	var ret []*sprite
 
-	height := byte(8)
+	height := 8
 	if tallSprites {
 		height = 16
 	}
 
-	// Collect all sprites on the line
+	// Collect the first 10 sprites on the line
 	for i := range oam.sprites {
 		sprite := &oam.sprites[i]
-		if sprite.yPos != 0 && line+16 >= sprite.yPos && line+16-height < sprite.yPos {
+		if y := int(line) + 16; y >= int(sprite.yPos) && y < int(sprite.yPos)+height {
 			ret = append(ret, sprite)
+			if len(ret) == 10 {
+				break
+			}
 		}
 	}
 
-	// Sort by x position
-	sort.Slice(ret, func(i, j int) bool {
-		return ret[i].xPos < ret[j].xPos
-	})
-
-	// Keep the first 10 from the left
-	if len(ret) > 10 {
-		ret = ret[:10]
+	// Sort by x position, keeping OAM order for sprites at the same position
+	if !cgb {
+		sort.SliceStable(ret, func(i, j int) bool {
+			return ret[i].xPos < ret[j].xPos
+		})
 	}
 
 	// Reverse
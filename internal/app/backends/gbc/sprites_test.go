@@ -0,0 +1,164 @@
+package gbc
+
+import (
+	"image/color"
+	"testing"
+)
+
+// writeSprite writes a sprite's attributes into OAM
+func writeSprite(o *oam, i int, y, x, tile, flags byte) {
+	o.Write(uint16(i*4), y)
+	o.Write(uint16(i*4+1), x)
+	o.Write(uint16(i*4+2), tile)
+	o.Write(uint16(i*4+3), flags)
+}
+
+// spriteIndices returns the OAM indices of the given sprites
+func spriteIndices(o *oam, sprites []*sprite) []int {
+	var ret []int
+	for _, s := range sprites {
+		for i := range o.sprites {
+			if s == &o.sprites[i] {
+				ret = append(ret, i)
+			}
+		}
+	}
+	return ret
+}
+
+func TestOAMVisibleSpritesOnLine(t *testing.T) {
+	o := new(oam)
+
+	// 12 sprites on line 0, with the later sprites further left
+	for i := 0; i < 12; i++ {
+		writeSprite(o, i, 16, byte(100-i*8), 0, 0)
+	}
+	// Sprites at the same position as sprite 1, and offscreen, which still count towards the limit
+	writeSprite(o, 1, 16, 40, 0, 0)
+	writeSprite(o, 4, 16, 40, 0, 0)
+	writeSprite(o, 2, 16, 0, 0, 0)
+	// A sprite on another line
+	writeSprite(o, 3, 40, 8, 0, 0)
+
+	// The first 10 on the line in OAM order, drawn from lowest priority to highest
+	expected := []int{0, 5, 6, 7, 4, 1, 8, 9, 10, 2}
+	got := spriteIndices(o, o.VisibleSpritesOnLine(0, false, false))
+	if len(got) != len(expected) {
+		t.Fatalf("Expected sprites %v, got %v", expected, got)
+	}
+	for i := range expected {
+		if got[i] != expected[i] {
+			t.Fatalf("Expected sprites %v, got %v", expected, got)
+		}
+	}
+
+	// CGB priority is by OAM index only
+	expected = []int{10, 9, 8, 7, 6, 5, 4, 2, 1, 0}
+	got = spriteIndices(o, o.VisibleSpritesOnLine(0, false, true))
+	for i := range expected {
+		if got[i] != expected[i] {
+			t.Fatalf("Expected CGB sprites %v, got %v", expected, got)
+		}
+	}
+
+	// Tall sprites cover 16 lines
+	if got := spriteIndices(o, o.VisibleSpritesOnLine(32, true, false)); len(got) != 1 || got[0] != 3 {
+		t.Errorf("Expected only sprite 3 on line 32 with tall sprites, got %v", got)
+	}
+	if got := spriteIndices(o, o.VisibleSpritesOnLine(32, false, false)); len(got) != 0 {
+		t.Errorf("Expected no sprites on line 32, got %v", got)
+	}
+}
+
+func TestPPUOverlappingSprites(t *testing.T) {
+	white := color.RGBA{255, 255, 255, 0xFF}
+	black := color.RGBA{0, 0, 0, 0xFF}
+	lightGray := color.RGBA{192, 192, 192, 0xFF}
+
+	for _, renderer := range []Renderer{RendererScanline, RendererFIFO} {
+		mmu := NewMMU(nil)
+		ppu := NewPPU(mmu)
+		mmu.ppu = ppu
+		ppu.renderer = renderer
+
+		mmu.Write(0xFF40, 0x83) // LCD, sprites, blank BG
+		mmu.Write(0xFF47, 0xE4)
+		mmu.Write(0xFF48, 0xE4)
+		mmu.Write(0xFF49, 0xE4)
+
+		// Tiles 1 and 4 are solid color 3, tile 2 is solid color 1, tile 3 is transparent
+		for i := uint16(0); i < 16; i++ {
+			mmu.Write(0x8010+i, 0xFF)
+			mmu.Write(0x8040+i, 0xFF)
+			if i%2 == 0 {
+				mmu.Write(0x8020+i, 0xFF)
+			}
+		}
+
+		render := func(line byte) {
+			ppu.line = line
+			if renderer == RendererFIFO {
+				renderFIFOLine(ppu)
+			} else {
+				ppu.renderLine()
+			}
+		}
+
+		// At the same X, the sprite earlier in OAM wins
+		writeSprite(ppu.oam, 0, 16, 8, 2, 0)
+		writeSprite(ppu.oam, 1, 16, 8, 1, 0)
+		render(0)
+		if got := ppu.framebuffer[0]; got != lightGray {
+			t.Errorf("%v: expected the first sprite in OAM to win at the same X, got %v", renderer, got)
+		}
+
+		// Otherwise the leftmost sprite wins, regardless of OAM order
+		writeSprite(ppu.oam, 1, 16, 4, 1, 0)
+		render(0)
+		if got := ppu.framebuffer[0]; got != black {
+			t.Errorf("%v: expected the leftmost sprite to win, got %v", renderer, got)
+		}
+		if got := ppu.framebuffer[4]; got != lightGray {
+			t.Errorf("%v: expected the second sprite to show past the first, got %v", renderer, got)
+		}
+
+		// A transparent sprite pixel shows the sprite beneath it
+		writeSprite(ppu.oam, 1, 16, 8, 3, 0)
+		render(0)
+		if got := ppu.framebuffer[0]; got != lightGray {
+			t.Errorf("%v: expected a transparent sprite to show the sprite beneath, got %v", renderer, got)
+		}
+
+		// The winning sprite pixel alone is compared against the BG: a behind-BG sprite hides those beneath it
+		// BG on with unsigned tile data, and the first map row set to tile 2 (solid color 1)
+		mmu.Write(0xFF40, 0x93)
+		for i := uint16(0); i < 32; i++ {
+			mmu.Write(0x9800+i, 2)
+		}
+		writeSprite(ppu.oam, 0, 16, 8, 1, 0x80)
+		writeSprite(ppu.oam, 1, 16, 8, 4, 0)
+		render(0)
+		if got := ppu.framebuffer[0]; got != lightGray {
+			t.Errorf("%v: expected the BG to show over a behind-BG sprite with priority over another, got %v", renderer, got)
+		}
+		for i := uint16(0); i < 32; i++ {
+			mmu.Write(0x9800+i, 0)
+		}
+		mmu.Write(0xFF40, 0x83)
+
+		// Tall sprites ignore bit 0 of the tile number: tile 3 draws tiles 2 and 3
+		for i := 0; i < 2; i++ {
+			writeSprite(ppu.oam, i, 0, 0, 0, 0)
+		}
+		mmu.Write(0xFF40, 0x87)
+		writeSprite(ppu.oam, 0, 32, 8, 3, 0)
+		render(16)
+		if got := ppu.framebuffer[16*ScreenWidth]; got != lightGray {
+			t.Errorf("%v: expected the top of a tall sprite to use the even tile, got %v", renderer, got)
+		}
+		render(24)
+		if got := ppu.framebuffer[24*ScreenWidth]; got != white {
+			t.Errorf("%v: expected the bottom of a tall sprite to use the odd tile, got %v", renderer, got)
+		}
+	}
+}
@@ -0,0 +1,238 @@
+package state
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+)
+
+// ErrTruncated is returned when a save state ends before all values have been read
+var ErrTruncated = errors.New("save state data is truncated")
+
+// Writer serializes component state into a little-endian binary buffer
+type Writer struct {
+	buf bytes.Buffer
+}
+
+// NewWriter constructs a valid Writer struct
+func NewWriter() *Writer {
+	return new(Writer)
+}
+
+// NewWriterSize constructs a valid Writer struct with room for size bytes, to avoid growing the buffer while writing
+func NewWriterSize(size int) *Writer {
+	w := new(Writer)
+	w.buf.Grow(size)
+	return w
+}
+
+// Data returns the serialized state
+func (w *Writer) Data() []byte {
+	return w.buf.Bytes()
+}
+
+// U8 writes an 8-bit value
+func (w *Writer) U8(val byte) {
+	w.buf.WriteByte(val)
+}
+
+// U16 writes a 16-bit value
+func (w *Writer) U16(val uint16) {
+	var b [2]byte
+	binary.LittleEndian.PutUint16(b[:], val)
+	w.buf.Write(b[:])
+}
+
+// U32 writes a 32-bit value
+func (w *Writer) U32(val uint32) {
+	var b [4]byte
+	binary.LittleEndian.PutUint32(b[:], val)
+	w.buf.Write(b[:])
+}
+
+// U64 writes a 64-bit value
+func (w *Writer) U64(val uint64) {
+	var b [8]byte
+	binary.LittleEndian.PutUint64(b[:], val)
+	w.buf.Write(b[:])
+}
+
+// Int writes a signed integer as 64 bits
+func (w *Writer) Int(val int) {
+	w.U64(uint64(int64(val)))
+}
+
+// Float64 writes a 64-bit float
+func (w *Writer) Float64(val float64) {
+	w.U64(math.Float64bits(val))
+}
+
+// Bool writes a boolean as a single byte
+func (w *Writer) Bool(val bool) {
+	if val {
+		w.U8(1)
+	} else {
+		w.U8(0)
+	}
+}
+
+// Bytes writes a length-prefixed byte slice
+func (w *Writer) Bytes(val []byte) {
+	w.U32(uint32(len(val)))
+	w.buf.Write(val)
+}
+
+// Raw writes a byte slice without a length prefix, for buffers whose length is fixed by the reader
+func (w *Writer) Raw(val []byte) {
+	w.buf.Write(val)
+}
+
+// String writes a length-prefixed string
+func (w *Writer) String(val string) {
+	w.Bytes([]byte(val))
+}
+
+// Reader deserializes component state written by a Writer.
+// The first error encountered is retained, and all subsequent reads return zero values.
+type Reader struct {
+	buf *bytes.Reader
+	err error
+
+	scratch [8]byte // Backing for fixed-size values, to avoid allocating on every read
+}
+
+// NewReader constructs a valid Reader struct over the given data
+func NewReader(data []byte) *Reader {
+	return &Reader{buf: bytes.NewReader(data)}
+}
+
+// Err returns the first error encountered while reading, if any
+func (r *Reader) Err() error {
+	return r.err
+}
+
+// Fail records an error on the reader, if one has not already been recorded
+func (r *Reader) Fail(err error) {
+	if r.err == nil {
+		r.err = err
+	}
+}
+
+// read returns the next n bytes, or nil on error. The result is only valid until the next read.
+func (r *Reader) read(n int) []byte {
+	var b []byte
+	if n <= len(r.scratch) {
+		b = r.scratch[:n]
+	} else {
+		b = make([]byte, n)
+	}
+
+	if !r.readInto(b) {
+		return nil
+	}
+
+	return b
+}
+
+// readInto fills b with the next bytes, and returns false on error
+func (r *Reader) readInto(b []byte) bool {
+	if r.err != nil {
+		return false
+	}
+
+	if _, err := io.ReadFull(r.buf, b); err != nil {
+		r.Fail(ErrTruncated)
+		return false
+	}
+
+	return true
+}
+
+// U8 reads an 8-bit value
+func (r *Reader) U8() byte {
+	b := r.read(1)
+	if b == nil {
+		return 0
+	}
+	return b[0]
+}
+
+// U16 reads a 16-bit value
+func (r *Reader) U16() uint16 {
+	b := r.read(2)
+	if b == nil {
+		return 0
+	}
+	return binary.LittleEndian.Uint16(b)
+}
+
+// U32 reads a 32-bit value
+func (r *Reader) U32() uint32 {
+	b := r.read(4)
+	if b == nil {
+		return 0
+	}
+	return binary.LittleEndian.Uint32(b)
+}
+
+// U64 reads a 64-bit value
+func (r *Reader) U64() uint64 {
+	b := r.read(8)
+	if b == nil {
+		return 0
+	}
+	return binary.LittleEndian.Uint64(b)
+}
+
+// Int reads a signed integer written by Writer.Int
+func (r *Reader) Int() int {
+	return int(int64(r.U64()))
+}
+
+// Float64 reads a 64-bit float
+func (r *Reader) Float64() float64 {
+	return math.Float64frombits(r.U64())
+}
+
+// Bool reads a boolean
+func (r *Reader) Bool() bool {
+	return r.U8() != 0
+}
+
+// Bytes reads a length-prefixed byte slice into dst. The stored length must match len(dst).
+func (r *Reader) Bytes(dst []byte) {
+	n := int(r.U32())
+	if r.err != nil {
+		return
+	}
+
+	if n != len(dst) {
+		r.Fail(fmt.Errorf("save state buffer length mismatch: expected %d, got %d", len(dst), n))
+		return
+	}
+
+	r.readInto(dst)
+}
+
+// Raw reads len(dst) bytes written by Writer.Raw into dst
+func (r *Reader) Raw(dst []byte) {
+	r.readInto(dst)
+}
+
+// String reads a length-prefixed string
+func (r *Reader) String() string {
+	n := int(r.U32())
+	if r.err != nil {
+		return ""
+	}
+
+	if n > r.buf.Len() {
+		r.Fail(ErrTruncated)
+		return ""
+	}
+
+	return string(r.read(n))
+}
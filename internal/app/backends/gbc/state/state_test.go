@@ -0,0 +1,94 @@
+package state
+
+import "testing"
+
+func TestStateRoundTrip(t *testing.T) {
+	w := NewWriter()
+
+	w.U8(0xAB)
+	w.U16(0x1234)
+	w.U32(0xDEADBEEF)
+	w.U64(0x0102030405060708)
+	w.Int(-42)
+	w.Float64(0.25)
+	w.Bool(true)
+	w.Bool(false)
+	w.Bytes([]byte{1, 2, 3})
+	w.Raw([]byte{4, 5})
+	w.String("goemu")
+
+	r := NewReader(w.Data())
+
+	if v := r.U8(); v != 0xAB {
+		t.Errorf("Expected U8 to read 0xAB, got %#02x", v)
+	}
+	if v := r.U16(); v != 0x1234 {
+		t.Errorf("Expected U16 to read 0x1234, got %#04x", v)
+	}
+	if v := r.U32(); v != 0xDEADBEEF {
+		t.Errorf("Expected U32 to read 0xDEADBEEF, got %#08x", v)
+	}
+	if v := r.U64(); v != 0x0102030405060708 {
+		t.Errorf("Expected U64 to read 0x0102030405060708, got %#016x", v)
+	}
+	if v := r.Int(); v != -42 {
+		t.Errorf("Expected Int to read -42, got %d", v)
+	}
+	if v := r.Float64(); v != 0.25 {
+		t.Errorf("Expected Float64 to read 0.25, got %f", v)
+	}
+	if v := r.Bool(); !v {
+		t.Errorf("Expected Bool to read true")
+	}
+	if v := r.Bool(); v {
+		t.Errorf("Expected Bool to read false")
+	}
+
+	buf := make([]byte, 3)
+	r.Bytes(buf)
+	if buf[0] != 1 || buf[1] != 2 || buf[2] != 3 {
+		t.Errorf("Expected Bytes to read [1 2 3], got %v", buf)
+	}
+
+	raw := make([]byte, 2)
+	r.Raw(raw)
+	if raw[0] != 4 || raw[1] != 5 {
+		t.Errorf("Expected Raw to read [4 5], got %v", raw)
+	}
+
+	if v := r.String(); v != "goemu" {
+		t.Errorf("Expected String to read goemu, got %q", v)
+	}
+
+	if r.Err() != nil {
+		t.Errorf("Expected no read error, got %v", r.Err())
+	}
+}
+
+func TestStateTruncated(t *testing.T) {
+	w := NewWriter()
+	w.U16(0x1234)
+
+	r := NewReader(w.Data())
+	r.U32()
+
+	if r.Err() != ErrTruncated {
+		t.Errorf("Expected truncated read to fail with ErrTruncated, got %v", r.Err())
+	}
+
+	if v := r.U8(); v != 0 {
+		t.Errorf("Expected reads after an error to return 0, got %#02x", v)
+	}
+}
+
+func TestStateBytesLengthMismatch(t *testing.T) {
+	w := NewWriter()
+	w.Bytes([]byte{1, 2, 3, 4})
+
+	r := NewReader(w.Data())
+	r.Bytes(make([]byte, 2))
+
+	if r.Err() == nil {
+		t.Errorf("Expected reading into a differently sized buffer to fail")
+	}
+}
@@ -2,6 +2,7 @@ package gbc
 
 import (
 	"github.com/omstrumpf/goemu/internal/app/backends/gbc/interrupts"
+	"github.com/omstrumpf/goemu/internal/app/backends/gbc/state"
 	"github.com/omstrumpf/goemu/internal/app/log"
 )
 
@@ -105,3 +106,27 @@ func (t *Timer) Write(addr uint16, val byte) {
 
 	log.Warningf("Encountered unexpected timer write: %#4x", addr)
 }
+
+// SaveState writes the timer registers and counters to the save state
+func (t *Timer) SaveState(w *state.Writer) {
+	w.U8(t.div)
+	w.U8(t.tima)
+	w.U8(t.tma)
+	w.U8(t.tac)
+	w.Int(t.speedMod)
+	w.Int(t.timerCounter)
+	w.Int(t.dividerCounter)
+	w.Bool(t.enable)
+}
+
+// LoadState restores the timer registers and counters from the save state
+func (t *Timer) LoadState(r *state.Reader) {
+	t.div = r.U8()
+	t.tima = r.U8()
+	t.tma = r.U8()
+	t.tac = r.U8()
+	t.speedMod = r.Int()
+	t.timerCounter = r.Int()
+	t.dividerCounter = r.Int()
+	t.enable = r.Bool()
+}
@@ -16,6 +16,9 @@ type Console interface {
 
 	IsStopped() bool
 
+	SaveState() []byte
+	LoadState([]byte) error
+
 	GetFrameBuffer() []color.RGBA
 	GetFrameTime() time.Duration
 	GetAudioChannel() *chan audio.ChanneledSample
@@ -26,6 +29,28 @@ type Console interface {
 	GetGameName() string
 }
 
+// Rumbler is implemented by consoles that can drive a rumble motor, for frontends to poll
+type Rumbler interface {
+	// Rumbling returns true if the rumble motor is currently running
+	Rumbling() bool
+}
+
+// PaletteSwitcher is implemented by consoles with selectable display palettes, for frontends to cycle through
+type PaletteSwitcher interface {
+	// NextPalette switches to the next display palette, and returns its name
+	NextPalette() string
+}
+
+// SpeedController is implemented by consoles that resample their audio output for the emulation speed,
+// so that it plays at the same pitch when the frontend runs faster or slower than real time
+type SpeedController interface {
+	// SetSpeedFactor sets the emulation speed relative to real time. 0 or less is for unpaced emulation.
+	SetSpeedFactor(float64)
+
+	// SpeedFactor returns the emulation speed the audio output is resampled for
+	SpeedFactor() float64
+}
+
 // Button represents a button on the console
 type Button byte
 
@@ -1,53 +1,109 @@
 package audio
 
 import (
+	"fmt"
+	"sync/atomic"
 	"time"
 
 	"github.com/faiface/beep"
 	"github.com/faiface/beep/speaker"
 	console_audio "github.com/omstrumpf/goemu/internal/app/console/audio"
+	"github.com/omstrumpf/goemu/internal/app/io/audio/stream"
 	"github.com/omstrumpf/goemu/internal/app/log"
 )
 
-// TODO playing to speaker is super delayed
-
-// Player plays audio to the speakers
+// Player plays audio to the speakers.
+// Samples are resampled with dynamic rate control into a ring buffer, which the speakers read from without locking.
+// The emulation is paced by waiting for room in the buffer, so that it runs at the speakers' rate.
 type Player struct {
-	InputChannel chan console_audio.Sample
+	// Updated by the speaker goroutine. Kept first in the struct for 64-bit alignment on 32-bit platforms.
+	underruns uint64
+
+	bitrate int
+	ring    *stream.Ring
+
+	resampler *stream.Resampler
+	target    int // Buffer fill the emulation is paced to, in samples
+
+	deviceBuffer int  // Samples buffered by the speaker device
+	playing      bool // The speaker device is open
+
+	muted int32
+}
+
+// Stats describes the state of the audio output
+type Stats struct {
+	Buffered  time.Duration // Audio waiting in the buffer
+	Device    time.Duration // Audio buffered by the speaker device
+	Ratio     float64       // Current resampling ratio of the dynamic rate control
+	Underruns uint64        // Number of times the speakers ran out of audio, including while paused
+}
+
+// Latency returns the delay from a sample being emulated to it reaching the speakers
+func (s Stats) Latency() time.Duration {
+	return s.Buffered + s.Device
+}
 
-	muted bool
+func (s Stats) String() string {
+	return fmt.Sprintf("latency %.1fms (buffered %.1fms, device %.1fms), rate %.4f, %d underruns",
+		ms(s.Latency()), ms(s.Buffered), ms(s.Device), s.Ratio, s.Underruns)
 }
 
-// NewPlayer constructs a valid Player struct
-func NewPlayer(bitrate int) *Player {
+func ms(d time.Duration) float64 {
+	return float64(d) / float64(time.Millisecond)
+}
+
+// NewPlayer constructs a valid Player struct, that plays audio at bitrate with about the given latency
+func NewPlayer(bitrate int, latency time.Duration) *Player {
+	sampleRate := beep.SampleRate(bitrate)
+
+	// The speaker device holds two of its buffers: one being played, and one being filled.
+	// The rest of the latency is the ring buffer, which must have at least a device buffer waiting when it is read.
+	deviceBuffer := sampleRate.N(latency / 4)
+	if deviceBuffer < 1 {
+		deviceBuffer = 1
+	}
+
 	p := &Player{
-		InputChannel: make(chan console_audio.Sample, bitrate/6),
+		bitrate:      bitrate,
+		ring:         stream.NewRing(bitrate / 2),
+		target:       2 * deviceBuffer,
+		deviceBuffer: deviceBuffer,
 	}
+	p.resampler = stream.NewResampler(p.target)
 
-	sampleRate := beep.SampleRate(bitrate)
-	speaker.Init(sampleRate, sampleRate.N(time.Second/10))
+	if err := speaker.Init(sampleRate, deviceBuffer); err != nil {
+		log.Errorf("Failed to initialize speaker: %v", err)
+		return p
+	}
+	p.playing = true
+
+	buf := make([]console_audio.Sample, deviceBuffer)
+	starved := false
 
 	streamer := beep.StreamerFunc(func(samples [][2]float64) (n int, ok bool) {
-		numStreamed := 0
+		if len(buf) < len(samples) {
+			buf = make([]console_audio.Sample, len(samples))
+		}
 
+		read := p.ring.Read(buf[:len(samples)])
+		if read < len(samples) && !starved {
+			// Count each time the speakers run out, rather than every read while out (e.g. paused)
+			atomic.AddUint64(&p.underruns, 1)
+			log.Tracef("ran out of samples")
+		}
+		starved = read < len(samples)
+
+		muted := atomic.LoadInt32(&p.muted) != 0
 		for i := range samples {
-			select {
-			case sample := <-p.InputChannel:
-				if !p.muted {
-					samples[i][0] = sample.L()
-					samples[i][1] = sample.R()
-				} else {
-					samples[i][0] = 0
-					samples[i][1] = 0
-				}
-				numStreamed++
-			default:
-				log.Tracef("ran out of samples")
-				break
+			if i < read && !muted {
+				samples[i] = buf[i]
+			} else {
+				samples[i] = [2]float64{}
 			}
 		}
 
-		return numStreamed, true
+		return len(samples), true
 	})
 
 	speaker.Play(streamer)
@@ -55,7 +111,52 @@ func NewPlayer(bitrate int) *Player {
 	return p
 }
 
+// Write queues a sample to be played, resampled for the buffer's fill. Samples that don't fit are dropped.
+func (p *Player) Write(sample console_audio.Sample) {
+	p.resampler.Update(p.ring.Len())
+	p.resampler.Push(sample, func(s console_audio.Sample) {
+		p.ring.Write(s)
+	})
+}
+
+// WaitForRoom blocks until the buffer has drained to its target fill, and returns false if it didn't by the timeout,
+// or if there are no speakers to drain it
+func (p *Player) WaitForRoom(timeout time.Duration) bool {
+	if !p.playing {
+		return false
+	}
+
+	deadline := time.Now().Add(timeout)
+
+	for p.ring.Len() > p.target {
+		if time.Now().After(deadline) {
+			return false
+		}
+		time.Sleep(time.Millisecond)
+	}
+
+	return true
+}
+
+// Stats returns the current state of the audio output
+func (p *Player) Stats() Stats {
+	duration := func(samples int) time.Duration {
+		return time.Duration(samples) * time.Second / time.Duration(p.bitrate)
+	}
+
+	return Stats{
+		Buffered:  duration(p.ring.Len()),
+		Device:    duration(2 * p.deviceBuffer),
+		Ratio:     p.resampler.Ratio(),
+		Underruns: atomic.LoadUint64(&p.underruns),
+	}
+}
+
 // SetMute sets the muted setting on the Player
 func (p *Player) SetMute(muted bool) {
-	p.muted = muted
+	var v int32
+	if muted {
+		v = 1
+	}
+	atomic.StoreInt32(&p.muted, v)
 }
@@ -0,0 +1,63 @@
+package stream
+
+import (
+	"github.com/omstrumpf/goemu/internal/app/console/audio"
+)
+
+// MaxRateAdjust is the largest fraction the resampling ratio is nudged by. Pitch changes this small are inaudible.
+const MaxRateAdjust = 0.005
+
+// Resampler resamples audio by linear interpolation, with dynamic rate control: the ratio is nudged to keep
+// a buffer near a target fill, so that small differences between the emulated and the speaker sample rates
+// don't drain or overflow the buffer over time.
+type Resampler struct {
+	target int     // Target buffer fill, in samples
+	ratio  float64 // Output samples per input sample
+
+	pos  float64      // Position of the next output sample, between the previous and the next input samples
+	prev audio.Sample // Previous input sample
+}
+
+// NewResampler constructs a valid Resampler struct that keeps a buffer near target samples full
+func NewResampler(target int) *Resampler {
+	if target < 1 {
+		target = 1
+	}
+
+	return &Resampler{
+		target: target,
+		ratio:  1,
+	}
+}
+
+// Update sets the resampling ratio for the buffer's current fill. A buffer fuller than the target is fed
+// fewer samples, and an emptier buffer is fed more.
+func (r *Resampler) Update(fill int) {
+	adjust := float64(r.target-fill) / float64(r.target)
+	if adjust > 1 {
+		adjust = 1
+	} else if adjust < -1 {
+		adjust = -1
+	}
+
+	r.ratio = 1 + MaxRateAdjust*adjust
+}
+
+// Ratio returns the current resampling ratio, in output samples per input sample
+func (r *Resampler) Ratio() float64 {
+	return r.ratio
+}
+
+// Push adds an input sample, and calls emit with the output samples up to it
+func (r *Resampler) Push(sample audio.Sample, emit func(audio.Sample)) {
+	for r.pos < 1 {
+		emit(audio.Sample{
+			r.prev[0] + (sample[0]-r.prev[0])*r.pos,
+			r.prev[1] + (sample[1]-r.prev[1])*r.pos,
+		})
+		r.pos += 1 / r.ratio
+	}
+
+	r.pos--
+	r.prev = sample
+}
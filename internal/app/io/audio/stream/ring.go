@@ -0,0 +1,74 @@
+package stream
+
+import (
+	"sync/atomic"
+
+	"github.com/omstrumpf/goemu/internal/app/console/audio"
+)
+
+// Ring is a fixed-size FIFO of audio samples, for passing audio from the emulation to the speakers without locking.
+// It is safe for one goroutine to write while another reads, but not for multiple writers or multiple readers.
+type Ring struct {
+	// Total samples written and read. Each is only stored by its own side, and loaded atomically by the other.
+	// Kept first in the struct for 64-bit alignment on 32-bit platforms.
+	written uint64
+	read    uint64
+
+	buf  []audio.Sample
+	mask uint64
+}
+
+// NewRing constructs a valid Ring struct that holds at least capacity samples
+func NewRing(capacity int) *Ring {
+	size := 1
+	for size < capacity {
+		size <<= 1
+	}
+
+	return &Ring{
+		buf:  make([]audio.Sample, size),
+		mask: uint64(size - 1),
+	}
+}
+
+// Write adds a sample, and returns false if the ring is full and the sample was dropped. Only called by the writer.
+func (r *Ring) Write(sample audio.Sample) bool {
+	written := r.written
+	if written-atomic.LoadUint64(&r.read) >= uint64(len(r.buf)) {
+		return false
+	}
+
+	r.buf[written&r.mask] = sample
+	atomic.StoreUint64(&r.written, written+1)
+
+	return true
+}
+
+// Read removes up to len(dst) samples into dst, and returns the number of samples read. Only called by the reader.
+func (r *Ring) Read(dst []audio.Sample) int {
+	read := r.read
+	available := atomic.LoadUint64(&r.written) - read
+
+	n := len(dst)
+	if uint64(n) > available {
+		n = int(available)
+	}
+
+	for i := 0; i < n; i++ {
+		dst[i] = r.buf[(read+uint64(i))&r.mask]
+	}
+	atomic.StoreUint64(&r.read, read+uint64(n))
+
+	return n
+}
+
+// Len returns the number of samples in the ring. Safe to call from either side, but may be stale.
+func (r *Ring) Len() int {
+	read := atomic.LoadUint64(&r.read)
+	return int(atomic.LoadUint64(&r.written) - read)
+}
+
+// Cap returns the number of samples the ring holds when full
+func (r *Ring) Cap() int {
+	return len(r.buf)
+}
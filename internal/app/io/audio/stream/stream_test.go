@@ -0,0 +1,117 @@
+package stream
+
+import (
+	"math"
+	"runtime"
+	"sync"
+	"testing"
+
+	"github.com/omstrumpf/goemu/internal/app/console/audio"
+)
+
+func TestRing(t *testing.T) {
+	r := NewRing(3)
+
+	if r.Cap() != 4 {
+		t.Errorf("Expected capacity to round up to 4, got %d", r.Cap())
+	}
+
+	for i := 0; i < 4; i++ {
+		if !r.Write(audio.Sample{float64(i), 0}) {
+			t.Errorf("Expected write %d to fit", i)
+		}
+	}
+	if r.Write(audio.Sample{4, 0}) {
+		t.Errorf("Expected a write to a full ring to be dropped")
+	}
+
+	dst := make([]audio.Sample, 3)
+	if n := r.Read(dst); n != 3 || dst[0][0] != 0 || dst[2][0] != 2 {
+		t.Errorf("Expected to read the first 3 samples, got %d: %v", n, dst)
+	}
+
+	// Wrap around
+	r.Write(audio.Sample{5, 0})
+	r.Write(audio.Sample{6, 0})
+
+	if r.Len() != 3 {
+		t.Errorf("Expected 3 samples in the ring, got %d", r.Len())
+	}
+	if n := r.Read(dst); n != 3 || dst[0][0] != 3 || dst[1][0] != 5 || dst[2][0] != 6 {
+		t.Errorf("Expected to read the samples in order across the wrap, got %d: %v", n, dst)
+	}
+	if n := r.Read(dst); n != 0 {
+		t.Errorf("Expected an empty ring to read nothing, got %d", n)
+	}
+}
+
+func TestRingConcurrent(t *testing.T) {
+	const total = 10000
+
+	r := NewRing(64)
+
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for i := 0; i < total; {
+			if r.Write(audio.Sample{float64(i), 0}) {
+				i++
+			} else {
+				runtime.Gosched()
+			}
+		}
+	}()
+
+	dst := make([]audio.Sample, 16)
+	for next := 0; next < total; {
+		n := r.Read(dst)
+		if n == 0 {
+			runtime.Gosched()
+		}
+		for _, s := range dst[:n] {
+			if int(s[0]) != next {
+				t.Fatalf("Expected sample %d, got %v", next, s[0])
+			}
+			next++
+		}
+	}
+
+	wg.Wait()
+}
+
+func TestResamplerRateControl(t *testing.T) {
+	r := NewResampler(1000)
+
+	r.Update(1000)
+	if r.Ratio() != 1 {
+		t.Errorf("Expected a ratio of 1 at the target fill, got %v", r.Ratio())
+	}
+
+	r.Update(0)
+	if r.Ratio() != 1+MaxRateAdjust {
+		t.Errorf("Expected an empty buffer to be fed faster, got ratio %v", r.Ratio())
+	}
+
+	r.Update(5000)
+	if r.Ratio() != 1-MaxRateAdjust {
+		t.Errorf("Expected an overfull buffer to be fed slower, got ratio %v", r.Ratio())
+	}
+
+	// A ramp resamples to a ramp with the same slope per input sample, after the first output from silence
+	var out []audio.Sample
+	for i := 0; i < 10000; i++ {
+		r.Push(audio.Sample{float64(i), -float64(i)}, func(s audio.Sample) {
+			out = append(out, s)
+		})
+	}
+
+	if expected := 10000 * r.Ratio(); math.Abs(float64(len(out))-expected) > 2 {
+		t.Errorf("Expected about %v output samples, got %d", expected, len(out))
+	}
+	for i := 2; i < len(out); i++ {
+		if step := out[i][0] - out[i-1][0]; math.Abs(step-1/r.Ratio()) > 1e-9 || out[i][1] != -out[i][0] {
+			t.Fatalf("Expected output %d to continue the ramp, got %v after %v", i, out[i], out[i-1])
+		}
+	}
+}
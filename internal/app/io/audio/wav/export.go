@@ -0,0 +1,89 @@
+package wav
+
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/omstrumpf/goemu/internal/app/console/audio"
+	"github.com/omstrumpf/goemu/internal/app/io/screenshot"
+)
+
+// Export writes a console's audio output to a mixed stereo WAV file, and optionally a stereo WAV file per channel.
+// Channels are written at the same scale as the mix, so they sum to it.
+type Export struct {
+	filename   string
+	sampleRate int
+	perChannel bool
+
+	mixed    *Writer
+	channels []*Writer // Created on the first sample, which gives the number of channels
+}
+
+// CreateExport creates the named WAV file for the mixed output, and constructs an Export for it.
+// If perChannel is set, each channel is also written to its own file, named by ChannelFilename.
+func CreateExport(filename string, sampleRate int, perChannel bool) (*Export, error) {
+	mixed, err := Create(filename, sampleRate, 2)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Export{
+		filename:   filename,
+		sampleRate: sampleRate,
+		perChannel: perChannel,
+		mixed:      mixed,
+	}, nil
+}
+
+// WriteSample writes a sample of the mixed output, and of each channel if enabled
+func (e *Export) WriteSample(sample audio.ChanneledSample) error {
+	if err := e.mixed.WriteFrame(sample.L(), sample.R()); err != nil {
+		return err
+	}
+
+	if !e.perChannel {
+		return nil
+	}
+
+	for len(e.channels) < len(sample.Channels) {
+		w, err := Create(ChannelFilename(e.filename, len(e.channels)), e.sampleRate, 2)
+		if err != nil {
+			return err
+		}
+		e.channels = append(e.channels, w)
+	}
+
+	for i, c := range sample.Channels {
+		if err := e.channels[i].WriteFrame(c.L(), c.R()); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Close finalizes and closes all of the files, returning the first error
+func (e *Export) Close() error {
+	err := e.mixed.Close()
+
+	for _, w := range e.channels {
+		if closeErr := w.Close(); err == nil {
+			err = closeErr
+		}
+	}
+
+	return err
+}
+
+// ChannelFilename returns the name of the file a channel is exported to, numbered from 1 after the mixed filename
+func ChannelFilename(filename string, channel int) string {
+	ext := filepath.Ext(filename)
+	return fmt.Sprintf("%s-ch%d%s", strings.TrimSuffix(filename, ext), channel+1, ext)
+}
+
+// Filename returns a timestamped name for an audio export of the named game in dir
+func Filename(dir string, game string, t time.Time) string {
+	return strings.TrimSuffix(screenshot.Filename(dir, game, t), ".png") + ".wav"
+}
@@ -0,0 +1,93 @@
+package wav
+
+import (
+	"encoding/binary"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/omstrumpf/goemu/internal/app/console/audio"
+)
+
+func TestExport(t *testing.T) {
+	dir, err := ioutil.TempDir("", "goemu-wav")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	filename := filepath.Join(dir, "song.wav")
+
+	e, err := CreateExport(filename, 48000, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for i := 0; i < 3; i++ {
+		err := e.WriteSample(audio.ChanneledSample{Channels: []audio.Sample{{0.25, 0}, {0, 0.25}, {0.25, 0.25}, {0, 0}}})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if err := e.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	// Reads the first frame of a stereo file, after checking it holds all 3
+	firstFrame := func(name string) (int16, int16) {
+		data, err := ioutil.ReadFile(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(data) != headerLength+12 {
+			t.Fatalf("Expected %s to hold 3 stereo frames, got %d bytes", name, len(data))
+		}
+		return int16(binary.LittleEndian.Uint16(data[44:])), int16(binary.LittleEndian.Uint16(data[46:]))
+	}
+
+	if l, r := firstFrame(filename); l != 16384 || r != 16384 {
+		t.Errorf("Expected the mixed file to sum the channels, got %d, %d", l, r)
+	}
+
+	expected := [][2]int16{{8192, 0}, {0, 8192}, {8192, 8192}, {0, 0}}
+	for i, e := range expected {
+		if l, r := firstFrame(ChannelFilename(filename, i)); l != e[0] || r != e[1] {
+			t.Errorf("Expected channel %d to be written at the mixed scale as %v, got %d, %d", i+1, e, l, r)
+		}
+	}
+}
+
+func TestExportMixedOnly(t *testing.T) {
+	dir, err := ioutil.TempDir("", "goemu-wav")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	e, err := CreateExport(filepath.Join(dir, "song.wav"), 48000, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	e.WriteSample(audio.ChanneledSample{Channels: []audio.Sample{{0.25, 0}, {0, 0.25}}})
+	if err := e.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	if files, _ := ioutil.ReadDir(dir); len(files) != 1 {
+		t.Errorf("Expected only the mixed file to be written, got %d files", len(files))
+	}
+}
+
+func TestExportFilenames(t *testing.T) {
+	if got := ChannelFilename(filepath.Join("music", "song.wav"), 2); got != filepath.Join("music", "song-ch3.wav") {
+		t.Errorf("Unexpected channel filename %s", got)
+	}
+
+	at := time.Date(2019, 5, 4, 13, 2, 1, 250000000, time.UTC)
+	if got := Filename("music", "POKEMON RED", at); got != filepath.Join("music", "POKEMON_RED-20190504-130201.250.wav") {
+		t.Errorf("Unexpected export filename %s", got)
+	}
+}
@@ -0,0 +1,133 @@
+package wav
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"io"
+	"math"
+	"os"
+)
+
+const (
+	headerLength  = 44
+	bitsPerSample = 16
+)
+
+// Writer streams 16-bit PCM audio to a WAV file. The header sizes are filled in on Close.
+type Writer struct {
+	out io.WriteSeeker
+	buf *bufio.Writer
+
+	closer io.Closer // Closed along with the writer, if set
+
+	sampleRate int
+	channels   int
+	dataBytes  uint32
+}
+
+// NewWriter constructs a valid Writer that writes to out with the given sample rate and number of channels
+func NewWriter(out io.WriteSeeker, sampleRate int, channels int) (*Writer, error) {
+	if channels <= 0 {
+		return nil, errors.New("wav: channel count must be positive")
+	}
+
+	w := &Writer{
+		out:        out,
+		buf:        bufio.NewWriter(out),
+		sampleRate: sampleRate,
+		channels:   channels,
+	}
+
+	// Placeholder header, rewritten with correct sizes on Close
+	if err := w.writeHeader(); err != nil {
+		return nil, err
+	}
+
+	return w, nil
+}
+
+// Create creates the named file and constructs a Writer for it. The file is closed when the Writer is closed.
+func Create(filename string, sampleRate int, channels int) (*Writer, error) {
+	f, err := os.Create(filename)
+	if err != nil {
+		return nil, err
+	}
+
+	w, err := NewWriter(f, sampleRate, channels)
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	w.closer = f
+
+	return w, nil
+}
+
+// WriteFrame writes a single sample for each channel. Values are clamped to [-1, 1].
+func (w *Writer) WriteFrame(values ...float64) error {
+	if len(values) != w.channels {
+		return errors.New("wav: frame does not match channel count")
+	}
+
+	var b [2]byte
+	for _, v := range values {
+		v = math.Max(-1, math.Min(1, v))
+		binary.LittleEndian.PutUint16(b[:], uint16(int16(math.Round(v*math.MaxInt16))))
+		if _, err := w.buf.Write(b[:]); err != nil {
+			return err
+		}
+	}
+
+	w.dataBytes += uint32(2 * w.channels)
+
+	return nil
+}
+
+// Close finalizes the WAV header and closes the underlying file, if the Writer owns it
+func (w *Writer) Close() error {
+	err := w.buf.Flush()
+
+	if err == nil {
+		_, err = w.out.Seek(0, io.SeekStart)
+	}
+	if err == nil {
+		err = w.writeHeader()
+	}
+	if err == nil {
+		_, err = w.out.Seek(0, io.SeekEnd)
+	}
+
+	if w.closer != nil {
+		if closeErr := w.closer.Close(); err == nil {
+			err = closeErr
+		}
+	}
+
+	return err
+}
+
+func (w *Writer) writeHeader() error {
+	blockAlign := w.channels * bitsPerSample / 8
+
+	var h [headerLength]byte
+	copy(h[0:], "RIFF")
+	binary.LittleEndian.PutUint32(h[4:], headerLength-8+w.dataBytes)
+	copy(h[8:], "WAVE")
+	copy(h[12:], "fmt ")
+	binary.LittleEndian.PutUint32(h[16:], 16) // fmt chunk size
+	binary.LittleEndian.PutUint16(h[20:], 1)  // PCM
+	binary.LittleEndian.PutUint16(h[22:], uint16(w.channels))
+	binary.LittleEndian.PutUint32(h[24:], uint32(w.sampleRate))
+	binary.LittleEndian.PutUint32(h[28:], uint32(w.sampleRate*blockAlign))
+	binary.LittleEndian.PutUint16(h[32:], uint16(blockAlign))
+	binary.LittleEndian.PutUint16(h[34:], bitsPerSample)
+	copy(h[36:], "data")
+	binary.LittleEndian.PutUint32(h[40:], w.dataBytes)
+
+	if _, err := w.buf.Write(h[:]); err != nil {
+		return err
+	}
+
+	return w.buf.Flush()
+}
@@ -0,0 +1,61 @@
+package wav
+
+import (
+	"encoding/binary"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestWAVWriter(t *testing.T) {
+	dir, err := ioutil.TempDir("", "goemu-wav")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	filename := filepath.Join(dir, "out.wav")
+
+	w, err := Create(filename, 44100, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	w.WriteFrame(0, 0)
+	w.WriteFrame(1, -1)
+	w.WriteFrame(2, -2) // Clamped
+
+	if err := w.WriteFrame(0); err == nil {
+		t.Errorf("Expected WriteFrame with the wrong number of channels to fail")
+	}
+
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	data, err := ioutil.ReadFile(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(data) != headerLength+12 {
+		t.Fatalf("Expected WAV file of %d bytes, got %d", headerLength+12, len(data))
+	}
+	if string(data[0:4]) != "RIFF" || string(data[8:12]) != "WAVE" || string(data[36:40]) != "data" {
+		t.Errorf("Expected WAV file to have RIFF/WAVE/data chunk markers")
+	}
+	if size := binary.LittleEndian.Uint32(data[40:]); size != 12 {
+		t.Errorf("Expected data chunk size of 12, got %d", size)
+	}
+	if rate := binary.LittleEndian.Uint32(data[24:]); rate != 44100 {
+		t.Errorf("Expected sample rate of 44100, got %d", rate)
+	}
+
+	if v := int16(binary.LittleEndian.Uint16(data[48:])); v != 32767 {
+		t.Errorf("Expected full-scale positive sample of 32767, got %d", v)
+	}
+	if v := int16(binary.LittleEndian.Uint16(data[54:])); v != -32767 {
+		t.Errorf("Expected clamped negative sample of -32767, got %d", v)
+	}
+}
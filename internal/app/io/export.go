@@ -0,0 +1,70 @@
+package io
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/omstrumpf/goemu/internal/app/io/audio/wav"
+)
+
+// StartAudioExport starts writing the audio output to a WAV file at path, stopping any active export.
+// If the AudioExportChannels option is set, each channel is also written to its own file next to it.
+func (io *IO) StartAudioExport(path string) error {
+	if err := io.StopAudioExport(); err != nil {
+		return err
+	}
+
+	e, err := wav.CreateExport(path, io.console.GetAudioBitrate(), io.config.AudioExportChannels)
+	if err != nil {
+		return err
+	}
+
+	io.audioExport = e
+
+	return nil
+}
+
+// StopAudioExport finalizes the active audio export, if any
+func (io *IO) StopAudioExport() error {
+	if io.audioExport == nil {
+		return nil
+	}
+
+	err := io.audioExport.Close()
+	io.audioExport = nil
+
+	return err
+}
+
+// ExportingAudio returns true if an audio export is active
+func (io *IO) ExportingAudio() bool {
+	return io.audioExport != nil
+}
+
+// abortAudioExport closes the active audio export after a write error
+func (io *IO) abortAudioExport(err error) {
+	fmt.Printf("Failed to write audio export: %v\n", err)
+
+	io.audioExport.Close()
+	io.audioExport = nil
+}
+
+func (io *IO) toggleAudioExport() {
+	if io.ExportingAudio() {
+		if err := io.StopAudioExport(); err != nil {
+			fmt.Printf("Failed to write audio export: %v\n", err)
+			return
+		}
+
+		fmt.Println("Stopped exporting audio.")
+		return
+	}
+
+	filename := wav.Filename(io.config.ScreenshotDir, io.console.GetGameName(), time.Now())
+	if err := io.StartAudioExport(filename); err != nil {
+		fmt.Printf("Failed to start audio export: %v\n", err)
+		return
+	}
+
+	fmt.Printf("Exporting audio to %s.\n", filename)
+}
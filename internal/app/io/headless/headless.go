@@ -0,0 +1,150 @@
+package headless
+
+import (
+	"github.com/omstrumpf/goemu/internal/app/console"
+	"github.com/omstrumpf/goemu/internal/app/io/audio/wav"
+	"github.com/omstrumpf/goemu/internal/app/io/recorder"
+	"github.com/omstrumpf/goemu/internal/app/io/screenshot"
+	"github.com/omstrumpf/goemu/internal/app/log"
+)
+
+// Headless is a frontend that drives the console without a display or speakers.
+// It runs a fixed number of frames as fast as possible, optionally exporting audio to WAV files.
+type Headless struct {
+	console console.Console
+
+	frames   uint64 // Number of frames to run
+	rendered uint64 // Number of frames rendered so far
+
+	audioOut *wav.Export
+	recorder recorder.Recorder
+}
+
+// NewHeadless constructs a valid Headless struct that runs for the given number of frames.
+// If wavFile is non-empty, the console's audio output is written to it, and if wavChannels is set, each channel
+// to its own file next to it.
+func NewHeadless(console console.Console, frames uint64, wavFile string, wavChannels bool) (*Headless, error) {
+	h := &Headless{
+		console: console,
+		frames:  frames,
+	}
+
+	if len(wavFile) > 0 {
+		w, err := wav.CreateExport(wavFile, console.GetAudioBitrate(), wavChannels)
+		if err != nil {
+			return nil, err
+		}
+		h.audioOut = w
+	}
+
+	return h, nil
+}
+
+// ProcessInput is unused on the headless frontend, which has no input devices
+func (h *Headless) ProcessInput() {}
+
+// ShouldEmulate always returns true, the headless frontend cannot be paused
+func (h *Headless) ShouldEmulate() bool {
+	return true
+}
+
+// ShouldExit returns true once the requested number of frames have been rendered
+func (h *Headless) ShouldExit() bool {
+	return h.rendered >= h.frames
+}
+
+// Render consumes the frame's audio output, and records the audio and frame if enabled
+func (h *Headless) Render() {
+	h.rendered++
+
+	channel := h.console.GetAudioChannel()
+
+	for {
+		select {
+		case sample := <-*channel:
+			if h.audioOut != nil {
+				if err := h.audioOut.WriteSample(sample); err != nil {
+					log.Errorf("Failed to write audio sample: %v", err)
+					h.closeAudio()
+				}
+			}
+			if h.recorder != nil {
+				if err := h.recorder.WriteSample(sample.Combine()); err != nil {
+					log.Errorf("Failed to write recording: %v", err)
+					h.closeRecording()
+				}
+			}
+		default:
+			if h.recorder != nil {
+				if err := h.recorder.WriteFrame(h.console.GetFrameBuffer()); err != nil {
+					log.Errorf("Failed to write recording: %v", err)
+					h.closeRecording()
+				}
+			}
+			return
+		}
+	}
+}
+
+// StartRecording records every frame and the audio output to path, until the frontend is closed.
+// Paths ending in .avi are recorded as an AVI file, and other paths as a directory of PNG frames and a WAV file.
+func (h *Headless) StartRecording(path string) error {
+	if err := h.closeRecording(); err != nil {
+		return err
+	}
+
+	r, err := recorder.Create(path, h.console.GetScreenWidth(), h.console.GetScreenHeight(), h.console.GetFrameTime(), h.console.GetAudioBitrate())
+	if err != nil {
+		return err
+	}
+	h.recorder = r
+
+	return nil
+}
+
+// RenderedFrames returns the number of frames rendered so far
+func (h *Headless) RenderedFrames() uint64 {
+	return h.rendered
+}
+
+// Close finalizes any output files
+func (h *Headless) Close() error {
+	err := h.closeAudio()
+	if recordErr := h.closeRecording(); err == nil {
+		err = recordErr
+	}
+
+	return err
+}
+
+// WriteFrameBuffer writes the console's current frame buffer to a PNG file, at an integer scale
+func (h *Headless) WriteFrameBuffer(filename string, scale int) error {
+	return screenshot.Write(filename, h.console.GetFrameBuffer(), h.console.GetScreenWidth(), h.console.GetScreenHeight(), scale)
+}
+
+// Screenshot writes the console's current frame buffer to a timestamped PNG file in dir, and returns the filename
+func (h *Headless) Screenshot(dir string, scale int) (string, error) {
+	return screenshot.Capture(h.console, dir, scale)
+}
+
+func (h *Headless) closeAudio() error {
+	if h.audioOut == nil {
+		return nil
+	}
+
+	err := h.audioOut.Close()
+	h.audioOut = nil
+
+	return err
+}
+
+func (h *Headless) closeRecording() error {
+	if h.recorder == nil {
+		return nil
+	}
+
+	err := h.recorder.Close()
+	h.recorder = nil
+
+	return err
+}
@@ -3,6 +3,7 @@ package io
 import (
 	"fmt"
 	"image/color"
+	"io/ioutil"
 	"time"
 
 	"github.com/faiface/pixel"
@@ -10,11 +11,37 @@ import (
 	"github.com/omstrumpf/goemu/internal/app/console"
 	"github.com/omstrumpf/goemu/internal/app/io/audio"
 	audio_inspector "github.com/omstrumpf/goemu/internal/app/io/audio/inspector"
+	"github.com/omstrumpf/goemu/internal/app/io/audio/wav"
+	"github.com/omstrumpf/goemu/internal/app/io/recorder"
+	"github.com/omstrumpf/goemu/internal/app/io/rewind"
+	"github.com/omstrumpf/goemu/internal/app/io/screenshot"
 )
 
+// Config holds the options for the windowed frontend
+type Config struct {
+	StateFilePrefix string // Path prefix for save state slot files
+	ScreenshotDir   string // Directory to write screenshots to
+	ScreenshotScale int    // Integer scale to write screenshots at. 1 is native resolution.
+
+	SpeedFactor      float64 // Emulation speed relative to real time. 0 is unlimited.
+	FastForwardSpeed float64 // Speed multiplier while fast-forwarding
+	SlowMotionSpeed  float64 // Speed multiplier in slow motion
+
+	AudioLatency time.Duration // Target delay from emulating audio to playing it
+
+	AudioExportChannels bool // Audio exports also write each channel to its own WAV file
+
+	RewindLength   time.Duration // Emulated time that can be rewound. 0 disables rewind.
+	RewindInterval int           // Emulated frames between rewind snapshots
+}
+
+// rewindTint is the color mask the screen is drawn with while rewinding
+var rewindTint = color.RGBA{0xB0, 0xC0, 0xFF, 0xFF}
+
 // IO manages the graphical and audio output of the emulator
 type IO struct {
 	console console.Console
+	config  Config
 
 	win *pixelgl.Window
 	pic *pixel.PictureData
@@ -24,20 +51,48 @@ type IO struct {
 
 	paused bool
 	muted  bool
+
+	fastForward     bool // Fast-forward is toggled on
+	fastForwardHeld bool // The fast-forward key is held
+	slowMotion      bool // Slow motion is toggled on
+	advance         bool // Emulate a single frame while paused
+
+	rumbleOffset float64 // Horizontal screen shake offset while the console is rumbling
+
+	stateSlot int // Currently selected save state slot
+
+	rewinder  *rewind.Rewinder // Nil if rewind is disabled
+	rewinding bool             // The rewind key is held
+
+	pacer *pacer // Paces frames by the clock when they can't be paced by the audio output
+
+	recorder    recorder.Recorder // Active recording, or nil
+	audioExport *wav.Export       // Active audio export, or nil
 }
 
 // NewIO constructs a valid IO struct
-func NewIO(console console.Console) *IO {
+func NewIO(console console.Console, config Config) *IO {
 	io := new(IO)
 
 	io.console = console
+	io.config = config
+	io.stateSlot = 1
 
 	io.audioInspector = audio_inspector.NewAudioInspector() // TODO only open this at user request
-	io.audioPlayer = audio.NewPlayer(io.console.GetAudioBitrate())
+	io.audioPlayer = audio.NewPlayer(io.console.GetAudioBitrate(), config.AudioLatency)
+	io.pacer = newPacer(console.GetFrameTime())
 
-	io.setupWindow()
+	if config.RewindLength > 0 {
+		interval := config.RewindInterval
+		if interval < 1 {
+			interval = 1
+		}
+		snapshots := int(config.RewindLength / (time.Duration(interval) * console.GetFrameTime()))
 
-	go io.distributeAudio()
+		io.rewinder = rewind.NewRewinder(console, interval, snapshots)
+	}
+
+	io.setupWindow()
 
 	return io
 }
@@ -58,6 +113,11 @@ func (io *IO) ProcessInput() {
 			io.console.ReleaseButton(val)
 		}
 	}
+
+	io.holdFastForward(io.win.Pressed(fastForwardKey))
+	io.rewind(io.win.Pressed(rewindKey))
+
+	io.applySpeed()
 }
 
 // Render renders the console's frame buffer to the display
@@ -66,20 +126,35 @@ func (io *IO) Render() {
 
 	picture := pixel.Picture(io.pic)
 	sprite := pixel.NewSprite(picture, picture.Bounds())
-	sprite.Draw(io.win, pixel.IM)
+	if io.rewinding {
+		sprite.DrawColorMask(io.win, pixel.IM, rewindTint)
+	} else {
+		sprite.Draw(io.win, pixel.IM)
+	}
 
-	shift := io.win.Bounds().Size().Scaled(0.5).Sub(pixel.ZV)
+	shift := io.win.Bounds().Size().Scaled(0.5).Sub(pixel.ZV).Add(pixel.V(io.rumble(), 0))
 	mat := pixel.IM.ScaledXY(pixel.ZV, pixel.V(io.getScaleFactor(), io.getScaleFactor()*-1)).Moved(shift)
 	io.win.SetMatrix(mat)
 
 	io.win.Update()
 
 	io.audioInspector.Render()
+
+	io.drainAudio()
+
+	if io.ShouldEmulate() {
+		if io.rewinder != nil {
+			io.rewinder.Frame()
+		}
+		io.recordFrame()
+	}
+
+	io.advance = false
 }
 
-// ShouldEmulate returns true if the emulator should emulate (not paused)
+// ShouldEmulate returns true if the emulator should emulate (not paused or rewinding, or advancing a single frame)
 func (io *IO) ShouldEmulate() bool {
-	return !io.paused
+	return (!io.paused || io.advance) && !io.rewinding
 }
 
 // ShouldExit returns true if the emulator should exit
@@ -89,7 +164,7 @@ func (io *IO) ShouldExit() bool {
 
 func (io *IO) setupWindow() {
 	win, err := pixelgl.NewWindow(pixelgl.WindowConfig{
-		Title:     "GoEmu Emulator (" + io.console.GetConsoleName() + " - " + io.console.GetGameName() + ")",
+		Title:     io.title(),
 		Bounds:    pixel.R(0, 0, float64(io.console.GetScreenWidth()), float64(io.console.GetScreenHeight())),
 		Resizable: true,
 	})
@@ -106,21 +181,75 @@ func (io *IO) setupWindow() {
 	}
 }
 
-func (io *IO) distributeAudio() {
-	channel := io.console.GetAudioChannel()
+// title returns the window title, showing the emulation speed if it is not real time
+func (io *IO) title() string {
+	title := "GoEmu Emulator (" + io.console.GetConsoleName() + " - " + io.console.GetGameName() + ")"
 
-	for {
-		if io == nil {
-			return
+	if speed := io.SpeedFactor(); speed <= 0 {
+		title += " [Unlimited]"
+	} else if speed != 1 {
+		title += fmt.Sprintf(" [%gx]", speed)
+	}
+
+	return title
+}
+
+// rewind steps the console back through its rewind history while held. Audio is muted while rewinding,
+// and the window title shows how much history is left.
+func (io *IO) rewind(held bool) {
+	if io.rewinder == nil || io.paused {
+		held = false
+	}
+
+	if held != io.rewinding {
+		io.rewinding = held
+		io.audioPlayer.SetMute(io.muted || io.rewinding)
+
+		if !io.rewinding {
+			io.win.SetTitle(io.title())
 		}
+	}
+
+	if !io.rewinding {
+		return
+	}
+
+	if _, err := io.rewinder.Step(); err != nil {
+		fmt.Printf("Failed to rewind: %v\n", err)
+	}
+
+	io.win.SetTitle(fmt.Sprintf("%s [Rewinding, %.1fs left]", io.title(), io.rewinder.Available().Seconds()))
+}
 
+// drainAudio distributes the audio samples emulated since the last frame to the speakers, inspector, recording,
+// and audio export
+func (io *IO) drainAudio() {
+	channel := io.console.GetAudioChannel()
+
+	for {
 		select {
 		case sample := <-*channel:
-			io.audioPlayer.InputChannel <- sample.Combine()
+			if io.recorder != nil {
+				if err := io.recorder.WriteSample(sample.Combine()); err != nil {
+					io.abortRecording(err)
+				}
+			}
+			if io.audioExport != nil {
+				if err := io.audioExport.WriteSample(sample); err != nil {
+					io.abortAudioExport(err)
+				}
+			}
+
+			io.audioPlayer.Write(sample.Combine())
+
+			// Drop samples the inspector can't keep up with, rather than holding back emulation
 			// TODO this assumes 4 channels
-			io.audioInspector.InputChannel <- [4]float64{sample.Channels[0].M(), sample.Channels[1].M(), sample.Channels[2].M(), sample.Channels[3].M()}
+			select {
+			case io.audioInspector.InputChannel <- [4]float64{sample.Channels[0].M(), sample.Channels[1].M(), sample.Channels[2].M(), sample.Channels[3].M()}:
+			default:
+			}
 		default:
-			time.Sleep(100 * time.Millisecond)
+			return
 		}
 	}
 }
@@ -135,6 +264,23 @@ func (io *IO) getScaleFactor() float64 {
 	return scaleHeight
 }
 
+// rumble returns the horizontal screen offset for this frame, shaking the screen while the console's rumble motor runs
+func (io *IO) rumble() float64 {
+	rumbler, ok := io.console.(console.Rumbler)
+	if !ok || !rumbler.Rumbling() {
+		io.rumbleOffset = 0
+		return 0
+	}
+
+	if io.rumbleOffset <= 0 {
+		io.rumbleOffset = io.getScaleFactor()
+	} else {
+		io.rumbleOffset = -io.getScaleFactor()
+	}
+
+	return io.rumbleOffset
+}
+
 func (io *IO) mute() {
 	fmt.Println("Muting audio.")
 
@@ -145,6 +291,138 @@ func (io *IO) mute() {
 func (io *IO) unmute() {
 	fmt.Println("Unmuting audio.")
 
-	io.audioPlayer.SetMute(false)
+	io.audioPlayer.SetMute(io.rewinding)
 	io.muted = false
 }
+
+// Screenshot writes the console's current frame to a timestamped PNG file in the screenshot directory, and returns the filename
+func (io *IO) Screenshot() (string, error) {
+	return screenshot.Capture(io.console, io.config.ScreenshotDir, io.config.ScreenshotScale)
+}
+
+func (io *IO) takeScreenshot() {
+	filename, err := io.Screenshot()
+	if err != nil {
+		fmt.Printf("Failed to write screenshot: %v\n", err)
+		return
+	}
+
+	fmt.Printf("Saved screenshot to %s.\n", filename)
+}
+
+// StartRecording starts recording every emulated frame and the audio output to path, stopping any active recording.
+// Paths ending in .avi are recorded as an AVI file, and other paths as a directory of PNG frames and a WAV file.
+func (io *IO) StartRecording(path string) error {
+	if err := io.StopRecording(); err != nil {
+		return err
+	}
+
+	r, err := recorder.Create(path, io.console.GetScreenWidth(), io.console.GetScreenHeight(), io.console.GetFrameTime(), io.console.GetAudioBitrate())
+	if err != nil {
+		return err
+	}
+
+	io.recorder = r
+
+	return nil
+}
+
+// StopRecording finalizes the active recording, if any
+func (io *IO) StopRecording() error {
+	if io.recorder == nil {
+		return nil
+	}
+
+	err := io.recorder.Close()
+	io.recorder = nil
+
+	return err
+}
+
+// Recording returns true if a recording is active
+func (io *IO) Recording() bool {
+	return io.recorder != nil
+}
+
+// recordFrame records the console's current frame, after the audio emulated in it
+func (io *IO) recordFrame() {
+	if io.recorder == nil {
+		return
+	}
+
+	if err := io.recorder.WriteFrame(io.console.GetFrameBuffer()); err != nil {
+		io.abortRecording(err)
+	}
+}
+
+// abortRecording closes the active recording after a write error
+func (io *IO) abortRecording(err error) {
+	fmt.Printf("Failed to write recording: %v\n", err)
+
+	io.recorder.Close()
+	io.recorder = nil
+}
+
+func (io *IO) toggleRecording() {
+	if io.Recording() {
+		if err := io.StopRecording(); err != nil {
+			fmt.Printf("Failed to write recording: %v\n", err)
+			return
+		}
+
+		fmt.Println("Stopped recording.")
+		return
+	}
+
+	filename := recorder.Filename(io.config.ScreenshotDir, io.console.GetGameName(), time.Now())
+	if err := io.StartRecording(filename); err != nil {
+		fmt.Printf("Failed to start recording: %v\n", err)
+		return
+	}
+
+	fmt.Printf("Recording to %s.\n", filename)
+}
+
+func (io *IO) nextPalette() {
+	switcher, ok := io.console.(console.PaletteSwitcher)
+	if !ok {
+		return
+	}
+
+	fmt.Printf("Switched to palette %s.\n", switcher.NextPalette())
+}
+
+func (io *IO) stateFile() string {
+	return fmt.Sprintf("%s.state%d", io.config.StateFilePrefix, io.stateSlot)
+}
+
+func (io *IO) selectStateSlot(slot int) {
+	fmt.Printf("Selected save state slot %d.\n", slot)
+
+	io.stateSlot = slot
+}
+
+func (io *IO) saveState() {
+	err := ioutil.WriteFile(io.stateFile(), io.console.SaveState(), 0644)
+	if err != nil {
+		fmt.Printf("Failed to write save state: %v\n", err)
+		return
+	}
+
+	fmt.Printf("Saved state to slot %d.\n", io.stateSlot)
+}
+
+func (io *IO) loadState() {
+	data, err := ioutil.ReadFile(io.stateFile())
+	if err != nil {
+		fmt.Printf("Failed to read save state: %v\n", err)
+		return
+	}
+
+	if err := io.console.LoadState(data); err != nil {
+		fmt.Printf("Failed to load save state: %v\n", err)
+		return
+	}
+
+	fmt.Printf("Loaded state from slot %d.\n", io.stateSlot)
+}
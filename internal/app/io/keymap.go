@@ -16,6 +16,12 @@ var buttonKeys = map[pixelgl.Button]console.Button{
 	pixelgl.KeyX:         console.ButtonB,
 }
 
+// rewindKey rewinds the console while held
+const rewindKey = pixelgl.KeyR
+
+// fastForwardKey fast-forwards while held
+const fastForwardKey = pixelgl.KeyTab
+
 var functionKeys = map[pixelgl.Button]func(*IO){
 	pixelgl.KeyEscape: func(io *IO) {
 		io.paused = !io.paused
@@ -30,4 +36,46 @@ var functionKeys = map[pixelgl.Button]func(*IO){
 			io.mute()
 		}
 	},
+	pixelgl.KeyF: func(io *IO) {
+		io.toggleFastForward()
+	},
+	pixelgl.KeyS: func(io *IO) {
+		io.toggleSlowMotion()
+	},
+	pixelgl.KeyN: func(io *IO) {
+		io.advanceFrame()
+	},
+	pixelgl.KeyL: func(io *IO) {
+		io.printAudioStats()
+	},
+	pixelgl.KeyC: func(io *IO) {
+		io.nextPalette()
+	},
+	pixelgl.Key1: func(io *IO) {
+		io.selectStateSlot(1)
+	},
+	pixelgl.Key2: func(io *IO) {
+		io.selectStateSlot(2)
+	},
+	pixelgl.Key3: func(io *IO) {
+		io.selectStateSlot(3)
+	},
+	pixelgl.Key4: func(io *IO) {
+		io.selectStateSlot(4)
+	},
+	pixelgl.KeyF12: func(io *IO) {
+		io.takeScreenshot()
+	},
+	pixelgl.KeyF10: func(io *IO) {
+		io.toggleRecording()
+	},
+	pixelgl.KeyF9: func(io *IO) {
+		io.toggleAudioExport()
+	},
+	pixelgl.KeyF5: func(io *IO) {
+		io.saveState()
+	},
+	pixelgl.KeyF8: func(io *IO) {
+		io.loadState()
+	},
 }
@@ -0,0 +1,76 @@
+package io
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/omstrumpf/goemu/internal/app/console"
+)
+
+// maxFrameLag is how far behind schedule the pacer can fall before it stops trying to catch up
+const maxFrameLag = 100 * time.Millisecond
+
+// pacer paces frames by the clock, to a speed relative to real time which can change between frames
+type pacer struct {
+	frameTime time.Duration
+	next      time.Time // When the next frame is due
+}
+
+// newPacer constructs a valid pacer for frames of the given real-time duration
+func newPacer(frameTime time.Duration) *pacer {
+	return &pacer{frameTime: frameTime, next: time.Now()}
+}
+
+// wait blocks until the next frame is due at the given speed. Speeds of 0 or less are unlimited, and don't wait.
+func (p *pacer) wait(speed float64) {
+	now := time.Now()
+
+	if speed <= 0 {
+		p.next = now
+		return
+	}
+
+	p.next = p.next.Add(time.Duration(float64(p.frameTime) / speed))
+	if p.next.Before(now.Add(-maxFrameLag)) {
+		p.next = now
+	}
+
+	time.Sleep(p.next.Sub(now))
+}
+
+// reset schedules the next frame from now, after frames were paced by something else
+func (p *pacer) reset() {
+	p.next = time.Now()
+}
+
+// WaitFrame blocks until the next frame is due. While the audio output plays at the emulation speed, frames are
+// paced by the speakers draining the audio buffer, so that the audio neither drifts nor runs out. Otherwise, such as
+// while paused, rewinding, or recording or exporting audio at another speed, frames are paced by the clock.
+func (io *IO) WaitFrame() {
+	speed := io.SpeedFactor()
+
+	if io.audioPaced(speed) && io.audioPlayer.WaitForRoom(2*io.console.GetFrameTime()) {
+		io.pacer.reset()
+		return
+	}
+
+	io.pacer.wait(speed)
+}
+
+// audioPaced returns true if the next frame's audio plays at the given emulation speed
+func (io *IO) audioPaced(speed float64) bool {
+	if speed <= 0 || !io.ShouldEmulate() {
+		return false
+	}
+
+	controller, ok := io.console.(console.SpeedController)
+	if !ok {
+		return speed == 1
+	}
+
+	return controller.SpeedFactor() == speed
+}
+
+func (io *IO) printAudioStats() {
+	fmt.Printf("Audio: %v.\n", io.audioPlayer.Stats())
+}
@@ -0,0 +1,328 @@
+package recorder
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"image/color"
+	"io"
+	"math"
+	"os"
+	"time"
+
+	"github.com/omstrumpf/goemu/internal/app/console/audio"
+)
+
+const (
+	aviVideoChunk = "00db" // Uncompressed video frame, in stream 0
+	aviAudioChunk = "01wb" // Audio data, in stream 1
+
+	aviHasIndex      = 0x10  // avih flag: the file has an idx1 index
+	aviIsInterleaved = 0x100 // avih flag: video and audio chunks are interleaved
+	aviKeyFrame      = 0x10  // idx1 flag: the chunk is a key frame
+
+	aviMaxSize = math.MaxUint32 // RIFF sizes are 32 bits
+
+	aviAudioBlockAlign = 4 // 16-bit stereo
+)
+
+// aviIndexEntry is an entry of the idx1 chunk, locating a chunk in the movi list
+type aviIndexEntry struct {
+	id     string
+	offset uint32 // Offset from the movi fourcc to the chunk header
+	size   uint32
+}
+
+// aviWriter records to an uncompressed AVI file, with 24-bit RGB video and 16-bit stereo PCM audio.
+// The headers are written as placeholders, and rewritten with the final counts and sizes on Close.
+type aviWriter struct {
+	out *os.File
+	buf *bufio.Writer
+
+	width      int
+	height     int
+	frameTime  time.Duration
+	sampleRate int
+
+	headerSize uint32 // Bytes before the movi list data, which does not change with the counts and sizes
+
+	frames   uint32
+	samples  uint32
+	moviSize uint32 // Bytes of chunks in the movi list
+	index    []aviIndexEntry
+
+	frame []byte // Frame data, as bottom-up BGR rows
+	audio []byte // PCM data written since the last frame
+}
+
+// newAVIWriter constructs a valid aviWriter that writes to out
+func newAVIWriter(out *os.File, width int, height int, frameTime time.Duration, sampleRate int) (*aviWriter, error) {
+	if width <= 0 || height <= 0 || frameTime <= 0 || sampleRate <= 0 {
+		return nil, errors.New("avi: invalid video or audio format")
+	}
+
+	w := &aviWriter{
+		out:        out,
+		buf:        bufio.NewWriter(out),
+		width:      width,
+		height:     height,
+		frameTime:  frameTime,
+		sampleRate: sampleRate,
+	}
+	w.frame = make([]byte, w.stride()*height)
+
+	header := w.header()
+	w.headerSize = uint32(len(header))
+
+	if _, err := w.buf.Write(header); err != nil {
+		return nil, err
+	}
+
+	return w, nil
+}
+
+// WriteFrame writes the audio recorded since the last frame, followed by the frame
+func (w *aviWriter) WriteFrame(frame []color.RGBA) error {
+	if len(frame) < w.width*w.height {
+		return errors.New("avi: frame buffer is smaller than the video")
+	}
+
+	if err := w.flushAudio(); err != nil {
+		return err
+	}
+
+	// Rows are stored bottom-up, as BGR
+	for y := 0; y < w.height; y++ {
+		row := w.frame[(w.height-1-y)*w.stride():]
+		for x, c := range frame[y*w.width : (y+1)*w.width] {
+			row[x*3] = c.B
+			row[x*3+1] = c.G
+			row[x*3+2] = c.R
+		}
+	}
+
+	if err := w.writeChunk(aviVideoChunk, w.frame); err != nil {
+		return err
+	}
+
+	w.frames++
+
+	return nil
+}
+
+// WriteSample buffers an audio sample, to be written before the next frame
+func (w *aviWriter) WriteSample(sample audio.Sample) error {
+	var b [aviAudioBlockAlign]byte
+	binary.LittleEndian.PutUint16(b[0:], pcm16(sample.L()))
+	binary.LittleEndian.PutUint16(b[2:], pcm16(sample.R()))
+	w.audio = append(w.audio, b[:]...)
+
+	w.samples++
+
+	return nil
+}
+
+// Close writes the remaining audio and the index, rewrites the headers, and closes the file
+func (w *aviWriter) Close() error {
+	err := w.flushAudio()
+
+	if err == nil {
+		err = w.writeIndex()
+	}
+	if err == nil {
+		err = w.buf.Flush()
+	}
+	if err == nil {
+		_, err = w.out.Seek(0, io.SeekStart)
+	}
+	if err == nil {
+		_, err = w.out.Write(w.header())
+	}
+
+	if closeErr := w.out.Close(); err == nil {
+		err = closeErr
+	}
+
+	return err
+}
+
+// flushAudio writes the buffered audio as a chunk
+func (w *aviWriter) flushAudio() error {
+	if len(w.audio) == 0 {
+		return nil
+	}
+
+	err := w.writeChunk(aviAudioChunk, w.audio)
+	w.audio = w.audio[:0]
+
+	return err
+}
+
+// writeChunk writes a chunk to the movi list, and indexes it
+func (w *aviWriter) writeChunk(id string, data []byte) error {
+	size := 8 + uint32(len(data)+len(data)%2)
+	if uint64(w.headerSize)+uint64(w.moviSize)+uint64(size)+16*uint64(len(w.index)+1) > aviMaxSize {
+		return errors.New("avi: recording exceeds the maximum AVI file size")
+	}
+
+	w.index = append(w.index, aviIndexEntry{id: id, offset: 4 + w.moviSize, size: uint32(len(data))})
+
+	if _, err := w.buf.Write(chunk(id, data)); err != nil {
+		return err
+	}
+
+	w.moviSize += size
+
+	return nil
+}
+
+// writeIndex writes the idx1 chunk, after the movi list
+func (w *aviWriter) writeIndex() error {
+	var data bytes.Buffer
+	for _, e := range w.index {
+		data.WriteString(e.id)
+		writeLE(&data, uint32(aviKeyFrame), e.offset, e.size)
+	}
+
+	_, err := w.buf.Write(chunk("idx1", data.Bytes()))
+	return err
+}
+
+// stride returns the number of bytes in a row of a frame, which is padded to 4 bytes
+func (w *aviWriter) stride() int {
+	return (w.width*3 + 3) &^ 3
+}
+
+// header returns the AVI headers, up to the start of the movi list data, with the current counts and sizes
+func (w *aviWriter) header() []byte {
+	frameSize := uint32(len(w.frame))
+	byteRate := uint32(float64(frameSize)*float64(time.Second)/float64(w.frameTime)) + uint32(w.sampleRate*aviAudioBlockAlign)
+
+	var avih bytes.Buffer
+	writeLE(&avih,
+		uint32(w.frameTime/time.Microsecond), // Microseconds per frame
+		byteRate,                             // Max bytes per second
+		uint32(0),                            // Padding granularity
+		uint32(aviHasIndex|aviIsInterleaved), // Flags
+		w.frames,                             // Total frames
+		uint32(0),                            // Initial frames
+		uint32(2),                            // Streams
+		frameSize,                            // Suggested buffer size
+		uint32(w.width),
+		uint32(w.height),
+		[4]uint32{}, // Reserved
+	)
+
+	var videoHeader bytes.Buffer
+	videoHeader.WriteString("vidsDIB ")
+	writeLE(&videoHeader,
+		uint32(0),                         // Flags
+		uint16(0),                         // Priority
+		uint16(0),                         // Language
+		uint32(0),                         // Initial frames
+		uint32(w.frameTime.Nanoseconds()), // Scale: the frame rate is rate/scale
+		uint32(time.Second.Nanoseconds()), // Rate
+		uint32(0),                         // Start
+		w.frames,                          // Length
+		frameSize,                         // Suggested buffer size
+		uint32(math.MaxUint32),            // Quality: default
+		uint32(0),                         // Sample size: variable
+		[4]uint16{0, 0, uint16(w.width), uint16(w.height)}, // Frame rectangle
+	)
+
+	var videoFormat bytes.Buffer // BITMAPINFOHEADER
+	writeLE(&videoFormat,
+		uint32(40), // Header size
+		int32(w.width),
+		int32(w.height), // Positive for bottom-up rows
+		uint16(1),       // Planes
+		uint16(24),      // Bits per pixel
+		uint32(0),       // Compression: none
+		frameSize,
+		[4]uint32{}, // Resolution and color table sizes
+	)
+
+	var audioHeader bytes.Buffer
+	audioHeader.WriteString("auds")
+	writeLE(&audioHeader,
+		uint32(0), // Handler
+		uint32(0), // Flags
+		uint16(0), // Priority
+		uint16(0), // Language
+		uint32(0), // Initial frames
+		uint32(1), // Scale: the sample rate is rate/scale
+		uint32(w.sampleRate),
+		uint32(0), // Start
+		w.samples, // Length
+		uint32(w.sampleRate*aviAudioBlockAlign), // Suggested buffer size
+		uint32(math.MaxUint32),                  // Quality: default
+		uint32(aviAudioBlockAlign),              // Sample size
+		[4]uint16{},                             // Frame rectangle
+	)
+
+	var audioFormat bytes.Buffer // WAVEFORMAT
+	writeLE(&audioFormat,
+		uint16(1), // PCM
+		uint16(2), // Channels
+		uint32(w.sampleRate),
+		uint32(w.sampleRate*aviAudioBlockAlign), // Bytes per second
+		uint16(aviAudioBlockAlign),
+		uint16(16), // Bits per sample
+	)
+
+	hdrl := list("hdrl",
+		chunk("avih", avih.Bytes()),
+		list("strl", chunk("strh", videoHeader.Bytes()), chunk("strf", videoFormat.Bytes())),
+		list("strl", chunk("strh", audioHeader.Bytes()), chunk("strf", audioFormat.Bytes())),
+	)
+
+	indexSize := 8 + 16*uint32(len(w.index))
+	riffSize := 4 + uint32(len(hdrl)) + 12 + w.moviSize + indexSize
+
+	var h bytes.Buffer
+	h.WriteString("RIFF")
+	writeLE(&h, riffSize)
+	h.WriteString("AVI ")
+	h.Write(hdrl)
+	h.WriteString("LIST")
+	writeLE(&h, 4+w.moviSize)
+	h.WriteString("movi")
+
+	return h.Bytes()
+}
+
+// chunk returns a RIFF chunk with the given id and data, padded to an even length
+func chunk(id string, data []byte) []byte {
+	var b bytes.Buffer
+	b.WriteString(id)
+	writeLE(&b, uint32(len(data)))
+	b.Write(data)
+	if len(data)%2 != 0 {
+		b.WriteByte(0)
+	}
+	return b.Bytes()
+}
+
+// list returns a RIFF list of the given kind, containing the given chunks
+func list(kind string, chunks ...[]byte) []byte {
+	var data bytes.Buffer
+	data.WriteString(kind)
+	for _, c := range chunks {
+		data.Write(c)
+	}
+	return chunk("LIST", data.Bytes())
+}
+
+// writeLE writes the values in little endian byte order
+func writeLE(b *bytes.Buffer, values ...interface{}) {
+	for _, v := range values {
+		binary.Write(b, binary.LittleEndian, v)
+	}
+}
+
+// pcm16 converts an audio sample value to a signed 16-bit PCM value, clamping it to [-1, 1]
+func pcm16(v float64) uint16 {
+	v = math.Max(-1, math.Min(1, v))
+	return uint16(int16(math.Round(v * math.MaxInt16)))
+}
@@ -0,0 +1,51 @@
+package recorder
+
+import (
+	"image/color"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/omstrumpf/goemu/internal/app/console/audio"
+	"github.com/omstrumpf/goemu/internal/app/io/screenshot"
+)
+
+// Recorder captures emulated frames, and the audio output alongside them.
+// Video and audio are timed by the emulated frame rate and sample rate, not the wall clock.
+type Recorder interface {
+	// WriteFrame records a video frame
+	WriteFrame(frame []color.RGBA) error
+
+	// WriteSample records a stereo audio sample. Values are clamped to [-1, 1].
+	WriteSample(sample audio.Sample) error
+
+	// Close finalizes the recording
+	Close() error
+}
+
+// Create starts a recording at path, of frames of the given size, and audio at the given sample rate.
+// Paths ending in .avi are recorded as an uncompressed AVI file with PCM audio. Any other path is a directory,
+// created if needed, that the recording is written to as a numbered PNG file per frame, and a WAV file of the audio.
+func Create(path string, width int, height int, frameTime time.Duration, sampleRate int) (Recorder, error) {
+	if strings.HasSuffix(strings.ToLower(path), ".avi") {
+		f, err := os.Create(path)
+		if err != nil {
+			return nil, err
+		}
+
+		w, err := newAVIWriter(f, width, height, frameTime, sampleRate)
+		if err != nil {
+			f.Close()
+			return nil, err
+		}
+
+		return w, nil
+	}
+
+	return newSequenceWriter(path, width, height, sampleRate)
+}
+
+// Filename returns the name of an AVI recording of the game started at the given time, in dir
+func Filename(dir string, game string, t time.Time) string {
+	return strings.TrimSuffix(screenshot.Filename(dir, game, t), ".png") + ".avi"
+}
@@ -0,0 +1,162 @@
+package recorder
+
+import (
+	"encoding/binary"
+	"image/color"
+	"image/png"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/omstrumpf/goemu/internal/app/console/audio"
+)
+
+// recordTestClip records 3 frames of 2x2 video, with 2 audio samples per frame
+func recordTestClip(t *testing.T, path string) []color.RGBA {
+	r, err := Create(path, 2, 2, time.Second/50, 100)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	red := color.RGBA{0xFF, 0, 0, 0xFF}
+	green := color.RGBA{0, 0xFF, 0, 0xFF}
+	blue := color.RGBA{0, 0, 0xFF, 0xFF}
+	frame := []color.RGBA{red, green, blue, red}
+
+	for i := 0; i < 3; i++ {
+		r.WriteSample(audio.Sample{0.5, -0.5})
+		r.WriteSample(audio.Sample{2, -2}) // Clamped
+		if err := r.WriteFrame(frame); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if err := r.WriteFrame(frame[:3]); err == nil {
+		t.Errorf("Expected a short frame to be rejected")
+	}
+
+	if err := r.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	return frame
+}
+
+func TestAVIRecording(t *testing.T) {
+	dir, err := ioutil.TempDir("", "goemu-recorder")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	filename := filepath.Join(dir, "out.avi")
+	recordTestClip(t, filename)
+
+	data, err := ioutil.ReadFile(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	u32 := func(offset int) uint32 { return binary.LittleEndian.Uint32(data[offset:]) }
+
+	if string(data[0:4]) != "RIFF" || string(data[8:12]) != "AVI " || int(u32(4)) != len(data)-8 {
+		t.Fatalf("Invalid RIFF header: %q, size %d for a %d byte file", data[0:12], u32(4), len(data))
+	}
+
+	// avih follows the hdrl list header
+	if string(data[24:28]) != "avih" {
+		t.Fatalf("Expected avih chunk, got %q", data[24:28])
+	}
+	if u32(32) != 20000 || u32(48) != 3 || u32(56) != 2 || u32(64) != 2 || u32(68) != 2 {
+		t.Errorf("Unexpected avih: %d us per frame, %d frames, %d streams, %dx%d", u32(32), u32(48), u32(56), u32(64), u32(68))
+	}
+
+	// Walk the movi list
+	movi := 0
+	for i := 12; i+12 <= len(data); i++ {
+		if string(data[i:i+4]) == "LIST" && string(data[i+8:i+12]) == "movi" {
+			movi = i + 8
+			break
+		}
+	}
+	if movi == 0 {
+		t.Fatal("Missing movi list")
+	}
+
+	var chunks []string
+	var video, pcm []byte
+	end := movi + int(u32(movi-4))
+	for i := movi + 4; i < end; {
+		id, size := string(data[i:i+4]), int(u32(i+4))
+		chunks = append(chunks, id)
+		switch id {
+		case "00db":
+			video = data[i+8 : i+8+size]
+		case "01wb":
+			pcm = data[i+8 : i+8+size]
+		}
+		i += 8 + size + size%2
+	}
+
+	if len(chunks) != 6 || chunks[0] != "01wb" || chunks[1] != "00db" {
+		t.Errorf("Expected interleaved audio and video chunks, got %v", chunks)
+	}
+
+	// Frames are stored bottom-up as BGR, with rows padded to 4 bytes
+	expectedVideo := []byte{0xFF, 0, 0, 0, 0, 0xFF, 0, 0, 0, 0, 0xFF, 0, 0xFF, 0, 0, 0}
+	if string(video) != string(expectedVideo) {
+		t.Errorf("Expected frame data %v, got %v", expectedVideo, video)
+	}
+
+	if len(pcm) != 8 || int16(binary.LittleEndian.Uint16(pcm[0:])) != 16384 || int16(binary.LittleEndian.Uint16(pcm[6:])) != -32767 {
+		t.Errorf("Unexpected PCM data %v", pcm)
+	}
+
+	if string(data[end:end+4]) != "idx1" || int(u32(end+4)) != 16*len(chunks) {
+		t.Errorf("Expected an index of %d entries after the movi list", len(chunks))
+	}
+}
+
+func TestSequenceRecording(t *testing.T) {
+	dir, err := ioutil.TempDir("", "goemu-recorder")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	out := filepath.Join(dir, "clip")
+	frame := recordTestClip(t, out)
+
+	for i := 0; i < 3; i++ {
+		f, err := os.Open(filepath.Join(out, "frame00000"+string(rune('0'+i))+".png"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		img, err := png.Decode(f)
+		f.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := color.RGBAModel.Convert(img.At(1, 0)); got != frame[1] {
+			t.Errorf("Expected frame %d pixel (1, 0) to be %v, got %v", i, frame[1], got)
+		}
+	}
+
+	info, err := os.Stat(filepath.Join(out, sequenceAudioFile))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Size() != 44+6*4 {
+		t.Errorf("Expected 6 stereo samples in the WAV file, got %d bytes", info.Size())
+	}
+}
+
+func TestFilename(t *testing.T) {
+	at := time.Date(2019, 5, 4, 13, 2, 1, 250000000, time.UTC)
+
+	if got := Filename("videos", "POKEMON RED", at); got != filepath.Join("videos", "POKEMON_RED-20190504-130201.250.avi") {
+		t.Errorf("Unexpected recording filename %s", got)
+	}
+}
@@ -0,0 +1,66 @@
+package recorder
+
+import (
+	"fmt"
+	"image/color"
+	"os"
+	"path/filepath"
+
+	"github.com/omstrumpf/goemu/internal/app/console/audio"
+	"github.com/omstrumpf/goemu/internal/app/io/audio/wav"
+	"github.com/omstrumpf/goemu/internal/app/io/screenshot"
+)
+
+// sequenceAudioFile is the name of the audio file in a sequence recording's directory
+const sequenceAudioFile = "audio.wav"
+
+// sequenceWriter records to a directory, as a numbered PNG file per frame and a WAV file of the audio
+type sequenceWriter struct {
+	dir    string
+	width  int
+	height int
+
+	frames int
+	audio  *wav.Writer
+}
+
+// newSequenceWriter constructs a valid sequenceWriter that writes to dir, creating it if needed
+func newSequenceWriter(dir string, width int, height int, sampleRate int) (*sequenceWriter, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+
+	a, err := wav.Create(filepath.Join(dir, sequenceAudioFile), sampleRate, 2)
+	if err != nil {
+		return nil, err
+	}
+
+	return &sequenceWriter{
+		dir:    dir,
+		width:  width,
+		height: height,
+		audio:  a,
+	}, nil
+}
+
+// WriteFrame writes the frame to the next numbered PNG file
+func (w *sequenceWriter) WriteFrame(frame []color.RGBA) error {
+	filename := filepath.Join(w.dir, fmt.Sprintf("frame%06d.png", w.frames))
+	if err := screenshot.Write(filename, frame, w.width, w.height, 1); err != nil {
+		return err
+	}
+
+	w.frames++
+
+	return nil
+}
+
+// WriteSample writes an audio sample to the WAV file
+func (w *sequenceWriter) WriteSample(sample audio.Sample) error {
+	return w.audio.WriteFrame(sample.L(), sample.R())
+}
+
+// Close finalizes the WAV file
+func (w *sequenceWriter) Close() error {
+	return w.audio.Close()
+}
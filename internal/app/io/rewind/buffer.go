@@ -0,0 +1,102 @@
+package rewind
+
+// Buffer is a bounded stack of snapshots, which drops the oldest snapshot when full.
+// The newest snapshot is kept whole, and each older snapshot is stored as a delta from the snapshot after it,
+// so pushing, popping, and dropping snapshots never needs more than one delta to be encoded or decoded.
+type Buffer struct {
+	newest []byte
+
+	deltas [][]byte // Ring of deltas of the older snapshots, oldest first from start
+	start  int
+	count  int
+
+	size int // Bytes held by the snapshots
+}
+
+// NewBuffer constructs a valid Buffer struct that holds up to capacity snapshots
+func NewBuffer(capacity int) *Buffer {
+	if capacity < 1 {
+		capacity = 1
+	}
+
+	return &Buffer{
+		deltas: make([][]byte, capacity-1),
+	}
+}
+
+// Push adds a snapshot, dropping the oldest snapshot if the buffer is full. The buffer keeps a copy of the data.
+func (b *Buffer) Push(data []byte) {
+	snapshot := append([]byte(nil), data...)
+
+	if b.newest != nil && len(b.deltas) > 0 {
+		if b.count == len(b.deltas) {
+			b.size -= len(b.deltas[b.start])
+			b.deltas[b.start] = nil
+			b.start = (b.start + 1) % len(b.deltas)
+			b.count--
+		}
+
+		delta := encodeDelta(b.newest, snapshot)
+		b.deltas[(b.start+b.count)%len(b.deltas)] = delta
+		b.count++
+		b.size += len(delta)
+	}
+
+	b.size += len(snapshot) - len(b.newest)
+	b.newest = snapshot
+}
+
+// Pop removes and returns the newest snapshot, or false if the buffer is empty
+func (b *Buffer) Pop() ([]byte, bool) {
+	if b.newest == nil {
+		return nil, false
+	}
+
+	snapshot := b.newest
+	b.newest = nil
+	b.size -= len(snapshot)
+
+	if b.count > 0 {
+		i := (b.start + b.count - 1) % len(b.deltas)
+		delta := b.deltas[i]
+		b.deltas[i] = nil
+		b.count--
+		b.size -= len(delta)
+
+		previous, err := applyDelta(snapshot, delta)
+		if err != nil {
+			// Deltas are only produced by Push, so this is a bug. The older snapshots can't be recovered.
+			b.Clear()
+			return snapshot, true
+		}
+
+		b.newest = previous
+		b.size += len(previous)
+	}
+
+	return snapshot, true
+}
+
+// Clear removes all snapshots
+func (b *Buffer) Clear() {
+	b.newest = nil
+	for i := range b.deltas {
+		b.deltas[i] = nil
+	}
+	b.start = 0
+	b.count = 0
+	b.size = 0
+}
+
+// Len returns the number of snapshots in the buffer
+func (b *Buffer) Len() int {
+	if b.newest == nil {
+		return 0
+	}
+	return b.count + 1
+}
+
+// Size returns the number of bytes held by the snapshots
+func (b *Buffer) Size() int {
+	return b.size
+}
@@ -0,0 +1,102 @@
+package rewind
+
+import (
+	"encoding/binary"
+	"errors"
+)
+
+// errCorruptDelta is returned when a delta cannot be decoded
+var errCorruptDelta = errors.New("rewind: corrupt snapshot delta")
+
+// encodeDelta returns a delta that reconstructs old from base.
+// The delta is the XOR of old and base, compressed as runs of zeros (unchanged bytes) and literal XORed bytes:
+//
+//	uvarint len(old), then repeated { uvarint zero run length, uvarint literal length, literal bytes }
+//
+// Consecutive machine states differ in few bytes, so deltas are much smaller than the states.
+func encodeDelta(old []byte, base []byte) []byte {
+	delta := appendUvarint(nil, uint64(len(old)))
+
+	for i := 0; i < len(old); {
+		// Run of unchanged bytes
+		start := i
+		for i < len(old) && old[i] == baseByte(base, i) {
+			i++
+		}
+		delta = appendUvarint(delta, uint64(i-start))
+
+		// Run of changed bytes. Short runs of unchanged bytes are included, since a run header costs at least 2 bytes.
+		start = i
+		for i < len(old) && (old[i] != baseByte(base, i) || unchangedRun(old, base, i) < 3) {
+			i++
+		}
+		delta = appendUvarint(delta, uint64(i-start))
+		for j := start; j < i; j++ {
+			delta = append(delta, old[j]^baseByte(base, j))
+		}
+	}
+
+	return delta
+}
+
+// applyDelta reconstructs the data a delta was encoded from, using the same base
+func applyDelta(base []byte, delta []byte) ([]byte, error) {
+	n, k := binary.Uvarint(delta)
+	if k <= 0 {
+		return nil, errCorruptDelta
+	}
+	delta = delta[k:]
+
+	out := make([]byte, n)
+	copy(out, base)
+
+	for pos := uint64(0); pos < n; {
+		zeros, k := binary.Uvarint(delta)
+		if k <= 0 {
+			return nil, errCorruptDelta
+		}
+		delta = delta[k:]
+
+		literals, k := binary.Uvarint(delta)
+		if k <= 0 || literals > uint64(len(delta)-k) {
+			return nil, errCorruptDelta
+		}
+		delta = delta[k:]
+
+		pos += zeros
+		if pos+literals > n {
+			return nil, errCorruptDelta
+		}
+
+		for _, b := range delta[:literals] {
+			out[pos] ^= b
+			pos++
+		}
+		delta = delta[literals:]
+	}
+
+	return out, nil
+}
+
+// baseByte returns the byte of base at i, treating base as zero-padded
+func baseByte(base []byte, i int) byte {
+	if i < len(base) {
+		return base[i]
+	}
+	return 0
+}
+
+// unchangedRun returns the number of unchanged bytes starting at i, up to 3
+func unchangedRun(old []byte, base []byte, i int) int {
+	n := 0
+	for n < 3 && i+n < len(old) && old[i+n] == baseByte(base, i+n) {
+		n++
+	}
+	return n
+}
+
+// appendUvarint appends the varint encoding of v to b
+func appendUvarint(b []byte, v uint64) []byte {
+	var buf [binary.MaxVarintLen64]byte
+	return append(b, buf[:binary.PutUvarint(buf[:], v)]...)
+}
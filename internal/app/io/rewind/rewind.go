@@ -0,0 +1,79 @@
+package rewind
+
+import (
+	"time"
+
+	"github.com/omstrumpf/goemu/internal/app/console"
+)
+
+// Rewinder captures the console's state every few emulated frames, and steps the console back through the captured states
+type Rewinder struct {
+	console console.Console
+	buffer  *Buffer
+
+	interval int  // Emulated frames between captures
+	frames   int  // Emulated frames since the last capture or step
+	fresh    bool // The newest snapshot was captured at the current state
+}
+
+// NewRewinder constructs a valid Rewinder struct that captures the console's state every interval frames,
+// keeping up to capacity snapshots
+func NewRewinder(c console.Console, interval int, capacity int) *Rewinder {
+	if interval < 1 {
+		interval = 1
+	}
+
+	return &Rewinder{
+		console:  c,
+		buffer:   NewBuffer(capacity),
+		interval: interval,
+	}
+}
+
+// Frame must be called after every emulated frame. It captures the console's state every interval frames.
+func (r *Rewinder) Frame() {
+	r.frames++
+	r.fresh = false
+
+	if r.frames < r.interval {
+		return
+	}
+
+	r.buffer.Push(r.console.SaveState())
+	r.frames = 0
+	r.fresh = true
+}
+
+// Step restores the console to the newest captured state, and removes it from the history.
+// Returns false if there is no history left to rewind through.
+func (r *Rewinder) Step() (bool, error) {
+	// A state captured this frame would not move the console back
+	if r.fresh && r.buffer.Len() > 1 {
+		r.buffer.Pop()
+	}
+
+	snapshot, ok := r.buffer.Pop()
+	if !ok {
+		return false, nil
+	}
+
+	r.frames = 0
+	r.fresh = false
+
+	if err := r.console.LoadState(snapshot); err != nil {
+		r.buffer.Clear()
+		return false, err
+	}
+
+	return true, nil
+}
+
+// Available returns the emulated time that can currently be rewound
+func (r *Rewinder) Available() time.Duration {
+	return time.Duration(r.buffer.Len()*r.interval) * r.console.GetFrameTime()
+}
+
+// Size returns the number of bytes of memory held by the captured states
+func (r *Rewinder) Size() int {
+	return r.buffer.Size()
+}
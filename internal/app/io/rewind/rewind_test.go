@@ -0,0 +1,138 @@
+package rewind
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/omstrumpf/goemu/internal/app/console"
+)
+
+// fakeConsole is a console whose state is a frame counter, spread through a larger state like a real machine's
+type fakeConsole struct {
+	console.Console
+
+	frame byte
+}
+
+func (c *fakeConsole) SaveState() []byte {
+	data := make([]byte, 1024)
+	data[100] = c.frame
+	data[900] = c.frame * 3
+	return data
+}
+
+func (c *fakeConsole) LoadState(data []byte) error {
+	if len(data) != 1024 {
+		return errors.New("bad state")
+	}
+	c.frame = data[100]
+	return nil
+}
+
+func (c *fakeConsole) GetFrameTime() time.Duration {
+	return time.Second / 60
+}
+
+func TestDeltaRoundTrip(t *testing.T) {
+	base := bytes.Repeat([]byte{1, 2, 3, 4}, 64)
+
+	changed := append([]byte(nil), base...)
+	changed[0] = 9
+	changed[10] = 9
+	changed[12] = 9 // Close enough to the previous change to share a literal run
+	changed[200] = 9
+
+	cases := map[string][]byte{
+		"unchanged": base,
+		"changed":   changed,
+		"shorter":   base[:100],
+		"longer":    append(append([]byte(nil), base...), 5, 6, 7),
+		"empty":     nil,
+	}
+
+	for name, old := range cases {
+		delta := encodeDelta(old, base)
+		got, err := applyDelta(base, delta)
+		if err != nil {
+			t.Errorf("%s: failed to apply delta: %v", name, err)
+			continue
+		}
+		if !bytes.Equal(got, old) {
+			t.Errorf("%s: expected delta to reconstruct %v, got %v", name, old, got)
+		}
+	}
+
+	if delta := encodeDelta(changed, base); len(delta) > 20 {
+		t.Errorf("Expected a small delta for 4 changed bytes, got %d bytes", len(delta))
+	}
+
+	if _, err := applyDelta(base, []byte{10, 0, 20, 1}); err == nil {
+		t.Errorf("Expected a truncated delta to be rejected")
+	}
+}
+
+func TestBuffer(t *testing.T) {
+	b := NewBuffer(3)
+
+	if _, ok := b.Pop(); ok {
+		t.Errorf("Expected an empty buffer to have nothing to pop")
+	}
+
+	for i := byte(1); i <= 5; i++ {
+		b.Push(bytes.Repeat([]byte{i}, 8))
+	}
+
+	if b.Len() != 3 {
+		t.Fatalf("Expected the buffer to be bounded to 3 snapshots, got %d", b.Len())
+	}
+
+	for _, expected := range []byte{5, 4, 3} {
+		data, ok := b.Pop()
+		if !ok || !bytes.Equal(data, bytes.Repeat([]byte{expected}, 8)) {
+			t.Errorf("Expected to pop snapshot %d, got %v", expected, data)
+		}
+	}
+
+	if _, ok := b.Pop(); ok || b.Len() != 0 || b.Size() != 0 {
+		t.Errorf("Expected the buffer to be empty after popping every snapshot, %d snapshots of %d bytes left", b.Len(), b.Size())
+	}
+}
+
+func TestRewinder(t *testing.T) {
+	c := &fakeConsole{}
+	r := NewRewinder(c, 2, 100)
+
+	for i := 0; i < 10; i++ {
+		c.frame++
+		r.Frame()
+	}
+
+	if r.Available() != 10*c.GetFrameTime() {
+		t.Errorf("Expected 10 frames of history, got %v", r.Available())
+	}
+
+	// The state at frame 10 was just captured, so the first step goes back to frame 8
+	for _, expected := range []byte{8, 6, 4, 2} {
+		if ok, err := r.Step(); !ok || err != nil {
+			t.Fatalf("Expected to step back to frame %d, got %t, %v", expected, ok, err)
+		}
+		if c.frame != expected {
+			t.Errorf("Expected to step back to frame %d, got frame %d", expected, c.frame)
+		}
+	}
+
+	if ok, _ := r.Step(); ok {
+		t.Errorf("Expected no history left to rewind through")
+	}
+
+	// Resuming captures again from the rewound state
+	c.frame++
+	r.Frame()
+	c.frame++
+	r.Frame()
+	if ok, _ := r.Step(); !ok || c.frame != 4 {
+		t.Errorf("Expected to step back to the state captured after rewinding")
+	}
+}
@@ -0,0 +1,83 @@
+package screenshot
+
+import (
+	"errors"
+	"fmt"
+	"image"
+	"image/color"
+	"image/png"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/omstrumpf/goemu/internal/app/console"
+)
+
+// Image converts a frame buffer to an image, scaling each pixel up to a square of scale x scale pixels
+func Image(frame []color.RGBA, width int, height int, scale int) *image.RGBA {
+	img := image.NewRGBA(image.Rect(0, 0, width*scale, height*scale))
+
+	for y := 0; y < height; y++ {
+		for x := 0; x < width; x++ {
+			c := frame[y*width+x]
+			for dy := 0; dy < scale; dy++ {
+				for dx := 0; dx < scale; dx++ {
+					img.SetRGBA(x*scale+dx, y*scale+dy, c)
+				}
+			}
+		}
+	}
+
+	return img
+}
+
+// Write writes a frame buffer to a PNG file at an integer scale
+func Write(filename string, frame []color.RGBA, width int, height int, scale int) error {
+	if scale < 1 {
+		return errors.New("screenshot: scale must be at least 1")
+	}
+	if len(frame) < width*height {
+		return errors.New("screenshot: frame buffer is smaller than the screen")
+	}
+
+	f, err := os.Create(filename)
+	if err != nil {
+		return err
+	}
+
+	if err := png.Encode(f, Image(frame, width, height, scale)); err != nil {
+		f.Close()
+		return err
+	}
+
+	return f.Close()
+}
+
+// Filename returns the name of a screenshot of the game taken at the given time, in dir
+func Filename(dir string, game string, t time.Time) string {
+	// Keep the game name safe for use in a filename
+	name := strings.Map(func(r rune) rune {
+		switch {
+		case r >= 'a' && r <= 'z', r >= 'A' && r <= 'Z', r >= '0' && r <= '9', r == '-':
+			return r
+		}
+		return '_'
+	}, strings.TrimSpace(game))
+	if len(name) == 0 {
+		name = "screenshot"
+	}
+
+	return filepath.Join(dir, fmt.Sprintf("%s-%s.png", name, t.Format("20060102-150405.000")))
+}
+
+// Capture writes the console's current frame to a timestamped PNG file in dir, and returns the filename
+func Capture(c console.Console, dir string, scale int) (string, error) {
+	filename := Filename(dir, c.GetGameName(), time.Now())
+
+	if err := Write(filename, c.GetFrameBuffer(), c.GetScreenWidth(), c.GetScreenHeight(), scale); err != nil {
+		return "", err
+	}
+
+	return filename, nil
+}
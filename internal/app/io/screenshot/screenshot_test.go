@@ -0,0 +1,71 @@
+package screenshot
+
+import (
+	"image/color"
+	"image/png"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestWrite(t *testing.T) {
+	dir, err := ioutil.TempDir("", "goemu-screenshot")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	red := color.RGBA{0xFF, 0, 0, 0xFF}
+	blue := color.RGBA{0, 0, 0xFF, 0xFF}
+	frame := []color.RGBA{red, blue, blue, red, red, blue}
+
+	for _, scale := range []int{1, 3} {
+		filename := filepath.Join(dir, "out.png")
+		if err := Write(filename, frame, 3, 2, scale); err != nil {
+			t.Fatal(err)
+		}
+
+		f, err := os.Open(filename)
+		if err != nil {
+			t.Fatal(err)
+		}
+		img, err := png.Decode(f)
+		f.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if b := img.Bounds(); b.Dx() != 3*scale || b.Dy() != 2*scale {
+			t.Fatalf("Expected a %dx%d image, got %v", 3*scale, 2*scale, b)
+		}
+
+		for y := 0; y < 2*scale; y++ {
+			for x := 0; x < 3*scale; x++ {
+				expected := frame[(y/scale)*3+x/scale]
+				if got := color.RGBAModel.Convert(img.At(x, y)); got != expected {
+					t.Errorf("Scale %d: expected pixel (%d, %d) to be %v, got %v", scale, x, y, expected, got)
+				}
+			}
+		}
+	}
+
+	if err := Write(filepath.Join(dir, "bad.png"), frame, 3, 2, 0); err == nil {
+		t.Errorf("Expected a scale of 0 to be rejected")
+	}
+	if err := Write(filepath.Join(dir, "bad.png"), frame, 4, 2, 1); err == nil {
+		t.Errorf("Expected a short frame buffer to be rejected")
+	}
+}
+
+func TestFilename(t *testing.T) {
+	at := time.Date(2019, 5, 4, 13, 2, 1, 250000000, time.UTC)
+
+	if got := Filename("shots", "POKEMON RED", at); got != filepath.Join("shots", "POKEMON_RED-20190504-130201.250.png") {
+		t.Errorf("Unexpected screenshot filename %s", got)
+	}
+	if got := Filename("", "", at); got != "screenshot-20190504-130201.250.png" {
+		t.Errorf("Unexpected screenshot filename for an untitled game %s", got)
+	}
+}
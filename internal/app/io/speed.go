@@ -0,0 +1,80 @@
+package io
+
+import (
+	"fmt"
+
+	"github.com/omstrumpf/goemu/internal/app/console"
+)
+
+// SpeedFactor returns the current emulation speed relative to real time, for pacing frames. 0 is unlimited.
+func (io *IO) SpeedFactor() float64 {
+	speed := io.config.SpeedFactor
+
+	switch {
+	case io.fastForward || io.fastForwardHeld:
+		if speed <= 0 {
+			return 0
+		}
+		return speed * io.config.FastForwardSpeed
+	case io.slowMotion:
+		if speed <= 0 {
+			speed = 1
+		}
+		return speed * io.config.SlowMotionSpeed
+	}
+
+	return speed
+}
+
+// applySpeed sets the speed the console resamples its audio output for, so that it keeps its pitch.
+// Recordings and audio exports are timed by emulated time, so their audio is left as emulated.
+func (io *IO) applySpeed() {
+	controller, ok := io.console.(console.SpeedController)
+	if !ok {
+		return
+	}
+
+	speed := io.SpeedFactor()
+	if io.Recording() || io.ExportingAudio() {
+		speed = 1
+	}
+
+	controller.SetSpeedFactor(speed)
+}
+
+// holdFastForward fast-forwards while held
+func (io *IO) holdFastForward(held bool) {
+	if held == io.fastForwardHeld {
+		return
+	}
+
+	io.fastForwardHeld = held
+	io.speedChanged()
+}
+
+func (io *IO) toggleFastForward() {
+	io.fastForward = !io.fastForward
+	io.speedChanged()
+}
+
+func (io *IO) toggleSlowMotion() {
+	io.slowMotion = !io.slowMotion
+	io.speedChanged()
+}
+
+// advanceFrame emulates a single frame while paused
+func (io *IO) advanceFrame() {
+	if io.paused {
+		io.advance = true
+	}
+}
+
+func (io *IO) speedChanged() {
+	if speed := io.SpeedFactor(); speed <= 0 {
+		fmt.Println("Running at unlimited speed.")
+	} else {
+		fmt.Printf("Running at %gx speed.\n", speed)
+	}
+
+	io.win.SetTitle(io.title())
+}
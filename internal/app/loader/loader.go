@@ -0,0 +1,118 @@
+package loader
+
+import (
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"io/ioutil"
+	"path"
+	"strings"
+)
+
+var (
+	zipMagic  = []byte{'P', 'K', 0x03, 0x04}
+	gzipMagic = []byte{0x1F, 0x8B}
+)
+
+// romExtensions are the file extensions recognized as ROMs inside archives
+var romExtensions = []string{".gb", ".gbc"}
+
+// LoadROM reads a romfile, decompressing it if it is a zip or gzip archive.
+// Within a zip archive, the named entry is loaded, or the first ROM entry if entry is empty.
+// Returns the ROM data and the name of the ROM, without directories or extension.
+func LoadROM(filename string, entry string) ([]byte, string, error) {
+	data, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return nil, "", err
+	}
+
+	switch {
+	case bytes.HasPrefix(data, zipMagic):
+		return loadZip(data, entry)
+	case bytes.HasPrefix(data, gzipMagic):
+		return loadGzip(data, filename)
+	}
+
+	if len(entry) > 0 {
+		return nil, "", fmt.Errorf("%s is not an archive, cannot load entry %s", filename, entry)
+	}
+
+	return data, romName(filename), nil
+}
+
+func loadZip(data []byte, entry string) ([]byte, string, error) {
+	r, err := zip.NewReader(bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		return nil, "", err
+	}
+
+	for _, f := range r.File {
+		if f.FileInfo().IsDir() {
+			continue
+		}
+
+		if len(entry) > 0 {
+			if f.Name != entry && path.Base(f.Name) != entry {
+				continue
+			}
+		} else if !isROM(f.Name) {
+			continue
+		}
+
+		rc, err := f.Open()
+		if err != nil {
+			return nil, "", err
+		}
+		defer rc.Close()
+
+		rom, err := ioutil.ReadAll(rc)
+		if err != nil {
+			return nil, "", err
+		}
+
+		return rom, romName(f.Name), nil
+	}
+
+	if len(entry) > 0 {
+		return nil, "", fmt.Errorf("zip archive has no entry named %s", entry)
+	}
+	return nil, "", fmt.Errorf("zip archive contains no %s files", strings.Join(romExtensions, " or "))
+}
+
+func loadGzip(data []byte, filename string) ([]byte, string, error) {
+	r, err := gzip.NewReader(bytes.NewReader(data))
+	if err != nil {
+		return nil, "", err
+	}
+	defer r.Close()
+
+	rom, err := ioutil.ReadAll(r)
+	if err != nil {
+		return nil, "", err
+	}
+
+	// Prefer the original filename stored in the gzip header
+	if len(r.Name) > 0 {
+		return rom, romName(r.Name), nil
+	}
+
+	return rom, romName(strings.TrimSuffix(filename, path.Ext(filename))), nil
+}
+
+// isROM returns true if the filename has a ROM extension
+func isROM(filename string) bool {
+	ext := strings.ToLower(path.Ext(filename))
+	for _, e := range romExtensions {
+		if ext == e {
+			return true
+		}
+	}
+	return false
+}
+
+// romName strips the directories and extension from a filename
+func romName(filename string) string {
+	base := path.Base(strings.ReplaceAll(filename, "\\", "/"))
+	return strings.TrimSuffix(base, path.Ext(base))
+}
@@ -0,0 +1,125 @@
+package loader
+
+import (
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func writeFile(t *testing.T, dir string, name string, data []byte) string {
+	filename := filepath.Join(dir, name)
+	if err := ioutil.WriteFile(filename, data, 0644); err != nil {
+		t.Fatal(err)
+	}
+	return filename
+}
+
+func zipData(t *testing.T, entries map[string][]byte, order []string) []byte {
+	var buf bytes.Buffer
+	w := zip.NewWriter(&buf)
+	for _, name := range order {
+		f, err := w.Create(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		f.Write(entries[name])
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func TestLoadROM(t *testing.T) {
+	dir, err := ioutil.TempDir("", "loader")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	rom := []byte{0x01, 0x02, 0x03}
+
+	data, name, err := LoadROM(writeFile(t, dir, "plain.gb", rom), "")
+	if err != nil || !bytes.Equal(data, rom) || name != "plain" {
+		t.Errorf("Expected plain ROM to load unchanged, got %v %q %v", data, name, err)
+	}
+
+	if _, _, err := LoadROM(filepath.Join(dir, "plain.gb"), "entry.gb"); err == nil {
+		t.Errorf("Expected an entry on a plain ROM to fail")
+	}
+}
+
+func TestLoadROMZip(t *testing.T) {
+	dir, err := ioutil.TempDir("", "loader")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	entries := map[string][]byte{
+		"readme.txt":        []byte("not a rom"),
+		"roms/First.GBC":    {0x01},
+		"roms/second.gb":    {0x02},
+		"roms/third.gb.bak": {0x03},
+	}
+	archive := writeFile(t, dir, "library.zip", zipData(t, entries, []string{"readme.txt", "roms/First.GBC", "roms/second.gb", "roms/third.gb.bak"}))
+
+	data, name, err := LoadROM(archive, "")
+	if err != nil || !bytes.Equal(data, []byte{0x01}) || name != "First" {
+		t.Errorf("Expected first ROM entry to load, got %v %q %v", data, name, err)
+	}
+
+	data, name, err = LoadROM(archive, "second.gb")
+	if err != nil || !bytes.Equal(data, []byte{0x02}) || name != "second" {
+		t.Errorf("Expected named ROM entry to load, got %v %q %v", data, name, err)
+	}
+
+	data, name, err = LoadROM(archive, "roms/third.gb.bak")
+	if err != nil || !bytes.Equal(data, []byte{0x03}) || name != "third.gb" {
+		t.Errorf("Expected named entry with full path to load, got %v %q %v", data, name, err)
+	}
+
+	if _, _, err := LoadROM(archive, "missing.gb"); err == nil {
+		t.Errorf("Expected a missing entry to fail")
+	}
+
+	empty := writeFile(t, dir, "empty.zip", zipData(t, entries, []string{"readme.txt"}))
+	if _, _, err := LoadROM(empty, ""); err == nil {
+		t.Errorf("Expected an archive without ROMs to fail")
+	}
+}
+
+func TestLoadROMGzip(t *testing.T) {
+	dir, err := ioutil.TempDir("", "loader")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	rom := []byte{0x01, 0x02, 0x03}
+
+	var buf bytes.Buffer
+	w := gzip.NewWriter(&buf)
+	w.Write(rom)
+	w.Close()
+
+	data, name, err := LoadROM(writeFile(t, dir, "game.gb.gz", buf.Bytes()), "")
+	if err != nil || !bytes.Equal(data, rom) || name != "game" {
+		t.Errorf("Expected gzip ROM to load, got %v %q %v", data, name, err)
+	}
+
+	buf.Reset()
+	w = gzip.NewWriter(&buf)
+	w.Name = "Inner Name.gbc"
+	w.Write(rom)
+	w.Close()
+
+	data, name, err = LoadROM(writeFile(t, dir, "renamed.gz", buf.Bytes()), "")
+	if err != nil || !bytes.Equal(data, rom) || name != "Inner Name" {
+		t.Errorf("Expected gzip header name to be used, got %v %q %v", data, name, err)
+	}
+}
@@ -0,0 +1,393 @@
+package patch
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"os"
+	"path/filepath"
+)
+
+var (
+	ipsMagic = []byte("PATCH")
+	upsMagic = []byte("UPS1")
+	bpsMagic = []byte("BPS1")
+)
+
+// MaxTargetSize is the largest patched rom that can be produced, the size of the largest GB rom
+const MaxTargetSize = 8 << 20
+
+// Extensions are the patch file extensions, in the order they are searched for
+var Extensions = []string{".ips", ".bps", ".ups"}
+
+var (
+	// ErrUnknownFormat is returned when the patch is not a recognized format
+	ErrUnknownFormat = errors.New("patch: unknown patch format")
+	// ErrTruncated is returned when the patch data ends unexpectedly
+	ErrTruncated = errors.New("patch: patch data is truncated")
+	// ErrTooLarge is returned when the patch's target size is over MaxTargetSize
+	ErrTooLarge = errors.New("patch: patch target size is too large")
+)
+
+// Apply applies an IPS, UPS, or BPS patch to the rom, detecting the format from the patch header.
+// The rom is not modified, and the patched data is returned.
+func Apply(rom []byte, patch []byte) ([]byte, error) {
+	switch {
+	case bytes.HasPrefix(patch, ipsMagic):
+		return ApplyIPS(rom, patch)
+	case bytes.HasPrefix(patch, upsMagic):
+		return ApplyUPS(rom, patch)
+	case bytes.HasPrefix(patch, bpsMagic):
+		return ApplyBPS(rom, patch)
+	}
+
+	return nil, ErrUnknownFormat
+}
+
+// FindPatch looks for a patch file in dir named after the rom, and returns its path.
+// Returns an empty string if there is none.
+func FindPatch(dir string, romName string) string {
+	for _, ext := range Extensions {
+		filename := filepath.Join(dir, romName+ext)
+		if info, err := os.Stat(filename); err == nil && !info.IsDir() {
+			return filename
+		}
+	}
+
+	return ""
+}
+
+// ApplyIPS applies an IPS patch to the rom. IPS patches carry no checksums.
+func ApplyIPS(rom []byte, patch []byte) ([]byte, error) {
+	if !bytes.HasPrefix(patch, ipsMagic) {
+		return nil, ErrUnknownFormat
+	}
+
+	out := append([]byte(nil), rom...)
+
+	p := patch[len(ipsMagic):]
+
+	for {
+		if len(p) < 3 {
+			return nil, ErrTruncated
+		}
+
+		if string(p[:3]) == "EOF" {
+			p = p[3:]
+			break
+		}
+
+		if len(p) < 5 {
+			return nil, ErrTruncated
+		}
+
+		offset := int(p[0])<<16 | int(p[1])<<8 | int(p[2])
+		size := int(binary.BigEndian.Uint16(p[3:]))
+		p = p[5:]
+
+		var data []byte
+		if size == 0 {
+			// RLE record
+			if len(p) < 3 {
+				return nil, ErrTruncated
+			}
+			size = int(binary.BigEndian.Uint16(p))
+			data = bytes.Repeat(p[2:3], size)
+			p = p[3:]
+		} else {
+			if len(p) < size {
+				return nil, ErrTruncated
+			}
+			data = p[:size]
+			p = p[size:]
+		}
+
+		if offset+size > len(out) {
+			out = append(out, make([]byte, offset+size-len(out))...)
+		}
+		copy(out[offset:], data)
+	}
+
+	// Optional truncation extension
+	if len(p) >= 3 {
+		length := int(p[0])<<16 | int(p[1])<<8 | int(p[2])
+		if length < len(out) {
+			out = out[:length]
+		}
+	}
+
+	return out, nil
+}
+
+// ApplyUPS applies a UPS patch to the rom, verifying the source, target, and patch checksums
+func ApplyUPS(rom []byte, patch []byte) ([]byte, error) {
+	if !bytes.HasPrefix(patch, upsMagic) {
+		return nil, ErrUnknownFormat
+	}
+
+	r, err := newReader(patch, len(upsMagic))
+	if err != nil {
+		return nil, err
+	}
+
+	sourceSize := r.varint()
+	targetSize := r.varint()
+	if r.err != nil {
+		return nil, r.err
+	}
+
+	if sourceSize != uint64(len(rom)) {
+		return nil, fmt.Errorf("patch: UPS source size %d does not match rom size %d", sourceSize, len(rom))
+	}
+	if targetSize > MaxTargetSize {
+		return nil, ErrTooLarge
+	}
+	if err := r.verifySource(rom); err != nil {
+		return nil, err
+	}
+
+	out := make([]byte, targetSize)
+	copy(out, rom)
+
+	pos := uint64(0)
+	for r.remaining() > 0 {
+		pos += r.varint()
+
+		for {
+			x := r.byte()
+			if r.err != nil {
+				return nil, r.err
+			}
+
+			if pos < targetSize {
+				out[pos] ^= x
+			}
+			pos++
+
+			if x == 0 {
+				break
+			}
+		}
+	}
+	if r.err != nil {
+		return nil, r.err
+	}
+
+	if err := r.verifyTarget(out); err != nil {
+		return nil, err
+	}
+
+	return out, nil
+}
+
+// ApplyBPS applies a BPS patch to the rom, verifying the source, target, and patch checksums
+func ApplyBPS(rom []byte, patch []byte) ([]byte, error) {
+	if !bytes.HasPrefix(patch, bpsMagic) {
+		return nil, ErrUnknownFormat
+	}
+
+	r, err := newReader(patch, len(bpsMagic))
+	if err != nil {
+		return nil, err
+	}
+
+	sourceSize := r.varint()
+	targetSize := r.varint()
+	metadataSize := r.varint()
+	r.skip(metadataSize)
+	if r.err != nil {
+		return nil, r.err
+	}
+
+	if sourceSize != uint64(len(rom)) {
+		return nil, fmt.Errorf("patch: BPS source size %d does not match rom size %d", sourceSize, len(rom))
+	}
+	if targetSize > MaxTargetSize {
+		return nil, ErrTooLarge
+	}
+	if err := r.verifySource(rom); err != nil {
+		return nil, err
+	}
+
+	out := make([]byte, 0, targetSize)
+
+	var sourceOffset, targetOffset int64
+
+	for r.remaining() > 0 {
+		data := r.varint()
+		command := data & 3
+		length := int64(data>>2) + 1
+
+		if uint64(len(out))+uint64(length) > targetSize {
+			return nil, errors.New("patch: BPS patch writes beyond target size")
+		}
+
+		switch command {
+		case 0: // SourceRead
+			start := int64(len(out))
+			if start+length > int64(len(rom)) {
+				return nil, errors.New("patch: BPS source read out of range")
+			}
+			out = append(out, rom[start:start+length]...)
+		case 1: // TargetRead
+			out = append(out, r.bytes(length)...)
+		case 2: // SourceCopy
+			sourceOffset += r.signedVarint()
+			if sourceOffset < 0 || sourceOffset+length > int64(len(rom)) {
+				return nil, errors.New("patch: BPS source copy out of range")
+			}
+			out = append(out, rom[sourceOffset:sourceOffset+length]...)
+			sourceOffset += length
+		case 3: // TargetCopy
+			targetOffset += r.signedVarint()
+			if targetOffset < 0 || targetOffset >= int64(len(out)) {
+				return nil, errors.New("patch: BPS target copy out of range")
+			}
+			// Copied byte by byte, as the source and destination may overlap
+			for i := int64(0); i < length; i++ {
+				out = append(out, out[targetOffset])
+				targetOffset++
+			}
+		}
+
+		if r.err != nil {
+			return nil, r.err
+		}
+	}
+
+	if uint64(len(out)) != targetSize {
+		return nil, fmt.Errorf("patch: BPS output size %d does not match target size %d", len(out), targetSize)
+	}
+
+	if err := r.verifyTarget(out); err != nil {
+		return nil, err
+	}
+
+	return out, nil
+}
+
+// reader decodes the body of a UPS or BPS patch. Errors are sticky.
+type reader struct {
+	data []byte // Patch body, excluding the checksum footer
+	pos  int
+
+	sourceCRC uint32
+	targetCRC uint32
+
+	err error
+}
+
+// newReader verifies the patch checksum and constructs a reader positioned after the header
+func newReader(patch []byte, headerLength int) (*reader, error) {
+	if len(patch) < headerLength+12 {
+		return nil, ErrTruncated
+	}
+
+	footer := patch[len(patch)-12:]
+
+	patchCRC := binary.LittleEndian.Uint32(footer[8:])
+	if crc := crc32.ChecksumIEEE(patch[:len(patch)-4]); crc != patchCRC {
+		return nil, fmt.Errorf("patch: patch checksum mismatch: expected %#08x, got %#08x", patchCRC, crc)
+	}
+
+	return &reader{
+		data:      patch[:len(patch)-12],
+		pos:       headerLength,
+		sourceCRC: binary.LittleEndian.Uint32(footer[0:]),
+		targetCRC: binary.LittleEndian.Uint32(footer[4:]),
+	}, nil
+}
+
+func (r *reader) verifySource(rom []byte) error {
+	if crc := crc32.ChecksumIEEE(rom); crc != r.sourceCRC {
+		return fmt.Errorf("patch: rom checksum mismatch, patch is for a different rom: expected %#08x, got %#08x", r.sourceCRC, crc)
+	}
+	return nil
+}
+
+func (r *reader) verifyTarget(out []byte) error {
+	if crc := crc32.ChecksumIEEE(out); crc != r.targetCRC {
+		return fmt.Errorf("patch: patched rom checksum mismatch: expected %#08x, got %#08x", r.targetCRC, crc)
+	}
+	return nil
+}
+
+func (r *reader) remaining() int {
+	if r.err != nil {
+		return 0
+	}
+	return len(r.data) - r.pos
+}
+
+func (r *reader) byte() byte {
+	if r.remaining() < 1 {
+		r.fail(ErrTruncated)
+		return 0
+	}
+	b := r.data[r.pos]
+	r.pos++
+	return b
+}
+
+func (r *reader) bytes(n int64) []byte {
+	if int64(r.remaining()) < n {
+		r.fail(ErrTruncated)
+		return nil
+	}
+	b := r.data[r.pos : r.pos+int(n)]
+	r.pos += int(n)
+	return b
+}
+
+func (r *reader) skip(n uint64) {
+	if uint64(r.remaining()) < n {
+		r.fail(ErrTruncated)
+		return
+	}
+	r.pos += int(n)
+}
+
+// varint decodes the variable length integer encoding shared by UPS and BPS
+func (r *reader) varint() uint64 {
+	var data uint64
+	shift := uint64(1)
+
+	for {
+		x := r.byte()
+		if r.err != nil {
+			return 0
+		}
+
+		data += uint64(x&0x7F) * shift
+		if x&0x80 != 0 {
+			return data
+		}
+
+		shift <<= 7
+		data += shift
+
+		if shift > 1<<56 {
+			r.fail(errors.New("patch: invalid variable length integer"))
+			return 0
+		}
+	}
+}
+
+// signedVarint decodes a relative offset, with the sign in the lowest bit
+func (r *reader) signedVarint() int64 {
+	data := r.varint()
+
+	offset := int64(data >> 1)
+	if data&1 != 0 {
+		return -offset
+	}
+	return offset
+}
+
+func (r *reader) fail(err error) {
+	if r.err == nil {
+		r.err = err
+	}
+}
@@ -0,0 +1,227 @@
+package patch
+
+import (
+	"bytes"
+	"encoding/binary"
+	"hash/crc32"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func encodeVarint(data uint64) []byte {
+	var out []byte
+	for {
+		x := byte(data & 0x7F)
+		data >>= 7
+		if data == 0 {
+			return append(out, 0x80|x)
+		}
+		out = append(out, x)
+		data--
+	}
+}
+
+func encodeSigned(offset int64) []byte {
+	if offset < 0 {
+		return encodeVarint(uint64(-offset)<<1 | 1)
+	}
+	return encodeVarint(uint64(offset) << 1)
+}
+
+// withFooter appends the source, target, and patch checksums to a UPS or BPS patch body
+func withFooter(body []byte, source []byte, target []byte) []byte {
+	var crc [4]byte
+
+	binary.LittleEndian.PutUint32(crc[:], crc32.ChecksumIEEE(source))
+	body = append(body, crc[:]...)
+	binary.LittleEndian.PutUint32(crc[:], crc32.ChecksumIEEE(target))
+	body = append(body, crc[:]...)
+	binary.LittleEndian.PutUint32(crc[:], crc32.ChecksumIEEE(body))
+
+	return append(body, crc[:]...)
+}
+
+var source = []byte("The quick brown fox jumps over the lazy dog")
+
+func TestIPS(t *testing.T) {
+	patch := []byte("PATCH")
+	patch = append(patch, 0x00, 0x00, 0x04, 0x00, 0x04) // Offset 4, 4 bytes
+	patch = append(patch, []byte("slow")...)            // "quick" becomes "slowk"
+	patch = append(patch, 0x00, 0x00, 0x2B, 0x00, 0x00) // Offset 43 (end), RLE
+	patch = append(patch, 0x00, 0x03, '!')              // 3 bytes of '!'
+	patch = append(patch, []byte("EOF")...)
+
+	out, err := Apply(source, patch)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := "The slowk brown fox jumps over the lazy dog!!!"
+	if string(out) != expected {
+		t.Errorf("Expected %q, got %q", expected, out)
+	}
+
+	if string(source) != "The quick brown fox jumps over the lazy dog" {
+		t.Errorf("Expected source rom to be unmodified")
+	}
+
+	// Truncation extension
+	out, err = Apply(source, append(patch, 0x00, 0x00, 0x09))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(out) != "The slowk" {
+		t.Errorf("Expected truncated output, got %q", out)
+	}
+
+	if _, err := Apply(source, patch[:12]); err != ErrTruncated {
+		t.Errorf("Expected truncated patch to fail, got %v", err)
+	}
+}
+
+func TestUPS(t *testing.T) {
+	target := []byte("The quick red fox jumps over the lazy dogs")
+
+	body := []byte("UPS1")
+	body = append(body, encodeVarint(uint64(len(source)))...)
+	body = append(body, encodeVarint(uint64(len(target)))...)
+
+	// Encode a hunk for each run of differing bytes
+	pos := 0
+	for i := 0; i < len(target); {
+		if i < len(source) && source[i] == target[i] {
+			i++
+			continue
+		}
+
+		body = append(body, encodeVarint(uint64(i-pos))...)
+		for ; i < len(target) && (i >= len(source) || source[i] != target[i]); i++ {
+			s := byte(0)
+			if i < len(source) {
+				s = source[i]
+			}
+			body = append(body, s^target[i])
+		}
+		body = append(body, 0x00)
+		i++
+		pos = i
+	}
+
+	patch := withFooter(body, source, target)
+
+	out, err := Apply(source, patch)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(out, target) {
+		t.Errorf("Expected %q, got %q", target, out)
+	}
+
+	// Wrong source rom
+	other := append([]byte(nil), source...)
+	other[0] = 't'
+	if _, err := Apply(other, patch); err == nil {
+		t.Errorf("Expected patching the wrong rom to fail")
+	}
+
+	// Corrupt patch
+	corrupt := append([]byte(nil), patch...)
+	corrupt[8] ^= 0xFF
+	if _, err := Apply(source, corrupt); err == nil {
+		t.Errorf("Expected a corrupt patch to fail")
+	}
+}
+
+func TestBPS(t *testing.T) {
+	target := []byte("The lazy dog jumps over the quick brown fox, ha ha ha")
+
+	body := []byte("BPS1")
+	body = append(body, encodeVarint(uint64(len(source)))...)
+	body = append(body, encodeVarint(uint64(len(target)))...)
+	body = append(body, encodeVarint(4)...)
+	body = append(body, []byte("meta")...)
+
+	action := func(command uint64, length int) []byte {
+		return encodeVarint(uint64(length-1)<<2 | command)
+	}
+
+	// SourceRead "The "
+	body = append(body, action(0, 4)...)
+	// SourceCopy "lazy dog" from offset 35
+	body = append(body, action(2, 8)...)
+	body = append(body, encodeSigned(35)...)
+	// SourceCopy " jumps over the " from offset 19
+	body = append(body, action(2, 16)...)
+	body = append(body, encodeSigned(19-43)...)
+	// SourceCopy "quick brown fox" from offset 4
+	body = append(body, action(2, 15)...)
+	body = append(body, encodeSigned(4-35)...)
+	// TargetRead ", ha"
+	body = append(body, action(1, 4)...)
+	body = append(body, []byte(", ha")...)
+	// TargetCopy " ha ha" from target offset 44, overlapping the output
+	body = append(body, action(3, 6)...)
+	body = append(body, encodeSigned(44)...)
+
+	patch := withFooter(body, source, target)
+
+	out, err := Apply(source, patch)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(out, target) {
+		t.Errorf("Expected %q, got %q", target, out)
+	}
+
+	// Checksums are verified
+	if _, err := Apply(source[:len(source)-1], patch); err == nil {
+		t.Errorf("Expected patching the wrong rom to fail")
+	}
+
+	badTarget := withFooter(body, source, []byte("something else"))
+	if _, err := Apply(source, badTarget); err == nil {
+		t.Errorf("Expected a target checksum mismatch to fail")
+	}
+}
+
+func TestTargetTooLarge(t *testing.T) {
+	for _, magic := range []string{"UPS1", "BPS1"} {
+		body := []byte(magic)
+		body = append(body, encodeVarint(uint64(len(source)))...)
+		body = append(body, encodeVarint(1<<62)...)
+		if magic == "BPS1" {
+			body = append(body, encodeVarint(0)...) // No metadata
+		}
+
+		if _, err := Apply(source, withFooter(body, source, source)); err != ErrTooLarge {
+			t.Errorf("%s: expected a huge target size to fail, got %v", magic, err)
+		}
+	}
+}
+
+func TestUnknownFormat(t *testing.T) {
+	if _, err := Apply(source, []byte("NOTAPATCH")); err != ErrUnknownFormat {
+		t.Errorf("Expected unknown format error, got %v", err)
+	}
+}
+
+func TestFindPatch(t *testing.T) {
+	dir, err := ioutil.TempDir("", "patch")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	if found := FindPatch(dir, "game"); found != "" {
+		t.Errorf("Expected no patch, found %s", found)
+	}
+
+	ioutil.WriteFile(filepath.Join(dir, "game.ups"), nil, 0644)
+	ioutil.WriteFile(filepath.Join(dir, "game.bps"), nil, 0644)
+
+	if found := FindPatch(dir, "game"); found != filepath.Join(dir, "game.bps") {
+		t.Errorf("Expected game.bps, found %s", found)
+	}
+}